@@ -0,0 +1,366 @@
+package store
+
+import (
+	"os"
+	"fmt"
+	"errors"
+	"time"
+	"regexp"
+	"strings"
+	"net/url"
+	"net/http"
+	netmail "net/mail"
+	mux "github.com/gorilla/mux"
+	sessions "github.com/gorilla/sessions"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+	mail "github.com/dustinnewman98/twitter_clone/mail"
+)
+
+const (
+	MAX_PASSWORD_LENGTH = 50
+	MAX_EMAIL_LENGTH = 254
+	VERIFY_EMAIL_TTL = 48 * time.Hour
+	RESET_PASSWORD_TTL = time.Hour
+	MAX_USERNAME_LENGTH = 50
+	USERNAME_CHANGE_COOLDOWN = 30 * 24 * time.Hour
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// reservedUsernames are the top-level paths that /{username} routes would
+// otherwise shadow.
+var reservedUsernames = map[string]bool{
+	"api": true,
+	"static": true,
+	"login": true,
+	"logout": true,
+	"forgot": true,
+	"reset": true,
+	"verify": true,
+	"tweet": true,
+	"notifications": true,
+	"welcome": true,
+	"messages": true,
+	"follow_requests": true,
+	"bookmarks": true,
+	"lists": true,
+	"settings": true,
+	"report": true,
+	"moderation": true,
+	"admin": true,
+	"media": true,
+	"metrics": true,
+}
+
+// siteURL is the externally visible origin used in emailed links and for
+// rel="me" checks. It only ever comes from BASE_URL: a request's Host and
+// X-Forwarded-Proto headers are chosen by the client, so building links from
+// them would let anyone send a victim a reset link to their own host.
+var siteURL string
+
+var ErrNoSiteURL = errors.New("BASE_URL is not set")
+
+// InitSiteURL reads BASE_URL, such as "https://example.com". The server
+// refuses to start without it.
+func InitSiteURL() error {
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		return ErrNoSiteURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+		return fmt.Errorf("BASE_URL %q is not an http or https origin", baseURL)
+	}
+	siteURL = baseURL
+	return nil
+}
+
+// SiteURL returns the origin set by InitSiteURL, or ErrNoSiteURL, in which
+// case no links may be sent.
+func SiteURL() (string, error) {
+	if siteURL == "" {
+		return "", ErrNoSiteURL
+	}
+	return siteURL, nil
+}
+
+// ValidEmail reports whether email is a single plain address.
+func ValidEmail(email string) bool {
+	if len(email) > MAX_EMAIL_LENGTH {
+		return false
+	}
+	address, err := netmail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// ValidPassword reports whether password may be used for an account.
+func ValidPassword(password string) bool {
+	return password != "" && len(password) <= MAX_PASSWORD_LENGTH
+}
+
+// ValidUsername reports whether username is well formed and not reserved.
+func ValidUsername(username string) bool {
+	return len(username) <= MAX_USERNAME_LENGTH && usernamePattern.MatchString(username) &&
+		!reservedUsernames[strings.ToLower(username)]
+}
+
+// sessionUser returns the signed-in user if they are the one named in the
+// route, or writes a response and returns false. The user is looked up by
+// uid because the username saved in the session goes stale when the account
+// is renamed from another session.
+func sessionUser(w http.ResponseWriter, r *http.Request) (model.User, *sessions.Session, bool) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Error(w, "", http.StatusUnauthorized)
+		return model.User{}, nil, false
+	}
+
+	user, err := Data.GetUser(r.Context(), uid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get user.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return model.User{}, nil, false
+	}
+
+	if mux.Vars(r)["username"] != user.Username {
+		logging.Warn(r.Context(), "Could not authenticate user.")
+		http.Error(w, "Permission Denied", http.StatusInternalServerError)
+		return model.User{}, nil, false
+	}
+	return user, session, true
+}
+
+// SessionVersionMiddleware signs out sessions saved before the account's
+// password last changed, then serves the request as signed out. Sessions
+// from before versions were recorded count as version 0.
+func SessionVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+		uid, ok := session.Values["uid"].(int64)
+		if ok == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		version, _ := session.Values["session_version"].(int64)
+		current, err := Data.GetSessionVersion(r.Context(), uid)
+		if err == nil && version != current {
+			session.Values["uid"] = 0
+			session.Values["username"] = ""
+			session.Options.MaxAge = -1
+			err = session.Save(r, w)
+			if err != nil {
+				logging.Error(r.Context(), "Could not end session.", "err", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sendVerificationEmail(r *http.Request, userId int64, email string) error {
+	site, err := SiteURL()
+	if err != nil {
+		return err
+	}
+	token, err := model.CreateUserToken(r.Context(), userId, model.TOKEN_VERIFY_EMAIL, email, VERIFY_EMAIL_TTL)
+	if err != nil {
+		return err
+	}
+	return mail.Send(mail.Message{
+		To: email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Confirm this address for your account by visiting:\n\n%s/verify/%s\n\nThe link expires in 48 hours.", site, token),
+	})
+}
+
+func PasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	editURL := fmt.Sprintf("/%s/edit", user.Username)
+
+	if r.FormValue("current_password") != user.Password {
+		http.Redirect(w, r, editURL+"?error=password_incorrect", http.StatusFound)
+		return
+	}
+
+	password := r.FormValue("new_password")
+	if password != r.FormValue("confirm_password") {
+		http.Redirect(w, r, editURL+"?error=password_mismatch", http.StatusFound)
+		return
+	}
+	if !ValidPassword(password) {
+		http.Redirect(w, r, editURL+"?error=password_invalid", http.StatusFound)
+		return
+	}
+
+	err := Data.ChangePassword(r.Context(), user.Id, password)
+	if err != nil {
+		logging.Error(r.Context(), "Could not change password.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Every other session is now signed out; this one stays signed in.
+	version, err := Data.GetSessionVersion(r.Context(), user.Id)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get session version.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.Values["session_version"] = version
+	err = session.Save(r, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, editURL+"?saved=password", http.StatusFound)
+	return
+}
+
+// UsernameHandler renames the signed-in user and updates their session to
+// match. Links to the old username redirect until someone else claims it.
+func UsernameHandler(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	editURL := fmt.Sprintf("/%s/edit", user.Username)
+
+	newUsername := strings.TrimPrefix(strings.TrimSpace(r.FormValue("username")), "@")
+	if !ValidUsername(newUsername) {
+		http.Redirect(w, r, editURL+"?error=username_invalid", http.StatusFound)
+		return
+	}
+
+	err := Data.ChangeUsername(r.Context(), user.Id, newUsername, USERNAME_CHANGE_COOLDOWN)
+	if err == model.ErrUsernameTaken {
+		http.Redirect(w, r, editURL+"?error=username_taken", http.StatusFound)
+		return
+	}
+	if err == model.ErrUsernameCooldown {
+		http.Redirect(w, r, editURL+"?error=username_cooldown", http.StatusFound)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not change username.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session.Values["username"] = newUsername
+	err = session.Save(r, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if user.Website != "" {
+		verifyWebsite(r.Context(), user.Id, user.Website, newUsername)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/%s/edit?saved=username", newUsername), http.StatusFound)
+}
+
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.Email != "" && !user.EmailVerified {
+		err := sendVerificationEmail(r, user.Id, user.Email)
+		if err != nil {
+			logging.Error(r.Context(), "Could not send verification email.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/%s/edit?saved=verification", user.Username), http.StatusFound)
+	return
+}
+
+func sendResetEmail(r *http.Request, site, username string) error {
+	user, err := Data.GetUserFromUsername(r.Context(), username)
+	if err != nil {
+		return err
+	}
+	token, err := model.CreateUserToken(r.Context(), user.Id, model.TOKEN_RESET_PASSWORD, user.Email, RESET_PASSWORD_TTL)
+	if err != nil {
+		return err
+	}
+	return mail.Send(mail.Message{
+		To: user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for @%s. If it was you, visit:\n\n%s/reset/%s\n\nThe link expires in one hour. Otherwise you can ignore this email.", user.Username, site, token),
+	})
+}
+
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+
+	site, err := SiteURL()
+	if err != nil {
+		logging.Error(r.Context(), "Could not send reset email.", "err", err)
+		http.Error(w, "Password reset is not available.", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Always report success, even if the email could not be sent, so the
+	// form cannot be used to discover which addresses have accounts.
+	username, err := model.GetUsernameFromVerifiedEmail(r.Context(), email)
+	if err == nil {
+		err = sendResetEmail(r, site, username)
+		if err != nil {
+			logging.Error(r.Context(), "Could not send reset email.", "err", err)
+		}
+	}
+
+	http.Redirect(w, r, "/forgot?sent=1", http.StatusFound)
+	return
+}
+
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	resetURL := fmt.Sprintf("/reset/%s", token)
+
+	password := r.FormValue("new_password")
+	if password != r.FormValue("confirm_password") {
+		http.Redirect(w, r, resetURL+"?error=password_mismatch", http.StatusFound)
+		return
+	}
+	if !ValidPassword(password) {
+		http.Redirect(w, r, resetURL+"?error=password_invalid", http.StatusFound)
+		return
+	}
+
+	userId, _, err := model.ConsumeUserToken(r.Context(), token, model.TOKEN_RESET_PASSWORD)
+	if err == model.ErrInvalidToken {
+		http.Redirect(w, r, resetURL, http.StatusFound)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not consume reset token.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = Data.ChangePassword(r.Context(), userId, password)
+	if err != nil {
+		logging.Error(r.Context(), "Could not change password.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+	return
+}
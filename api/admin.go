@@ -0,0 +1,102 @@
+package store
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+	mux "github.com/gorilla/mux"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// adminHandler authorizes an admin, resolves the {username} being managed
+// and applies update to it. Admins cannot manage their own account, so the
+// last admin can't lock everyone out.
+func adminHandler(update func(w http.ResponseWriter, r *http.Request, adminId int64, user model.User) (string, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminId, ok := authorize(w, r, model.ROLE_ADMIN)
+		if !ok {
+			return
+		}
+
+		username := mux.Vars(r)["username"]
+		user, err := Data.GetUserFromUsername(r.Context(), username)
+		if err != nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if user.Id == adminId {
+			http.Error(w, "You can't change your own account here", http.StatusForbidden)
+			return
+		}
+
+		saved, ok := update(w, r, adminId, user)
+		if !ok {
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/admin?username=%s&saved=%s", url.QueryEscape(username), saved), http.StatusFound)
+	}
+}
+
+// reasonField reads the form's reason, answering 400 when it is too long.
+func reasonField(w http.ResponseWriter, r *http.Request) (string, bool) {
+	reason := r.FormValue("reason")
+	if utf8.RuneCountInString(reason) > MAX_REPORT_COMMENT_LENGTH {
+		http.Error(w, "Reason is too long", http.StatusBadRequest)
+		return "", false
+	}
+	return reason, true
+}
+
+var SetRoleHandler = adminHandler(func(w http.ResponseWriter, r *http.Request, adminId int64, user model.User) (string, bool) {
+	role := r.FormValue("role")
+	if !model.ValidRole(role) {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return "", false
+	}
+	err := model.SetRole(r.Context(), adminId, user.Id, role)
+	if err != nil {
+		logging.Error(r.Context(), "Could not set role.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return "role", true
+})
+
+var SuspendUserHandler = adminHandler(func(w http.ResponseWriter, r *http.Request, adminId int64, user model.User) (string, bool) {
+	reason, ok := reasonField(w, r)
+	if !ok {
+		return "", false
+	}
+	until, ok := suspensionEnd(r)
+	if !ok {
+		http.Error(w, "Invalid suspension length", http.StatusBadRequest)
+		return "", false
+	}
+	err := model.SuspendUser(r.Context(), adminId, user.Id, reason, until)
+	if err == model.ErrOutranked {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return "", false
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not suspend user.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return "suspended", true
+})
+
+var UnsuspendUserHandler = adminHandler(func(w http.ResponseWriter, r *http.Request, adminId int64, user model.User) (string, bool) {
+	reason, ok := reasonField(w, r)
+	if !ok {
+		return "", false
+	}
+	err := model.UnsuspendUser(r.Context(), adminId, user.Id, reason)
+	if err != nil {
+		logging.Error(r.Context(), "Could not unsuspend user.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return "unsuspended", true
+})
@@ -4,16 +4,21 @@ import (
 	"io"
 	"path"
 	"os"
-	"log"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 	"context"
+	"database/sql"
 	"mime/multipart"
 	mux "github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
 	session "github.com/dustinnewman98/twitter_clone/session"
+	recommend "github.com/dustinnewman98/twitter_clone/recommend"
+	timeline "github.com/dustinnewman98/twitter_clone/timeline"
 	storage "cloud.google.com/go/storage"
 	uuid "github.com/gofrs/uuid"
 )
@@ -22,49 +27,64 @@ const (
 	LOGIN_COOKIE_NAME = "login"
 )
 
-var bucket *storage.BucketHandle
-var bucketName string = os.Getenv("BUCKET_NAME")
+// Data is where handlers read and write users, Tweets, follows, blocks,
+// messages and notifications. Tests can swap in a model.MemoryStore.
+var Data model.Store = model.PostgresStore{}
 
 func Init() error {
+	go func() {
+		for range time.Tick(PURGE_INTERVAL) {
+			purge()
+		}
+	}()
+
+	// MEDIA_DIR keeps uploads on disk, which is handy in development.
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		Media = LocalMedia{Dir: dir}
+		return nil
+	}
+
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
 	if err != nil {
-		log.Println("Could not create storage client.", err)
+		logging.Error(ctx, "Could not create storage client.", "err", err)
 		return err
 	}
-	bucket = client.Bucket(bucketName)
+	bucketName := os.Getenv("BUCKET_NAME")
+	Media = GCSMedia{Bucket: client.Bucket(bucketName), Name: bucketName}
 	return nil
 }
 
-func uploadImage(f multipart.File, fh *multipart.FileHeader) (string, error) {
-	ctx := context.Background()
-
-	if _, err := bucket.Attrs(ctx); err != nil {
-		fmt.Println("Failed at attrs")
-		return "", err
+func objectURL(name string) string {
+	if Media == nil {
+		return ""
 	}
+	return Media.URL(name)
+}
 
-	// random filename, retaining existing extension.
-	name := uuid.Must(uuid.NewV4()).String() + path.Ext(fh.Filename)
-
-	w := bucket.Object(name).NewWriter(ctx)
-
-	w.ACL = []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}}
-
-	// // Entries are immutable, be aggressive about caching (1 day).
-	// w.CacheControl = "public, max-age=86400"
-
-	if _, err := io.Copy(w, f); err != nil {
-		fmt.Println("Failed at copy")
-		return "", err
+// objectName reverses objectURL, returning false for URLs outside storage.
+func objectName(url string) (string, bool) {
+	prefix := objectURL("")
+	if prefix == "" || !strings.HasPrefix(url, prefix) {
+		return "", false
 	}
+	return strings.TrimPrefix(url, prefix), true
+}
 
-	if err := w.Close(); err != nil {
-		fmt.Println("Failed at close")
+// uploadObject stores r publicly under name and returns its URL.
+func uploadObject(ctx context.Context, kind, name, contentType string, r io.Reader) (string, error) {
+	err := putMedia(ctx, kind, name, contentType, r, true)
+	if err != nil {
 		return "", err
 	}
+	return objectURL(name), nil
+}
 
-	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, name), nil
+func uploadImage(ctx context.Context, f multipart.File, fh *multipart.FileHeader) (string, error) {
+	// random filename, retaining existing extension.
+	name := uuid.Must(uuid.NewV4()).String() + path.Ext(fh.Filename)
+
+	return uploadObject(ctx, "tweets", name, fh.Header.Get("Content-Type"), f)
 }
 
 func TweetHandler(w http.ResponseWriter, r *http.Request) {
@@ -76,7 +96,6 @@ func TweetHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
 		return
 	}
-	fmt.Println("Tweet: ", r.FormValue("tweet"))
 
 	tweet := model.TweetRequest{
 		UserId: uid.(int64),
@@ -85,9 +104,9 @@ func TweetHandler(w http.ResponseWriter, r *http.Request) {
 
 	f, fh, err := r.FormFile("image")
 	if err == nil {
-		image, err := uploadImage(f, fh)
+		image, err := uploadImage(r.Context(), f, fh)
 		if err != nil {
-			log.Println("Could not upload image.", err)
+			logging.Error(r.Context(), "Could not upload image.", "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -98,9 +117,13 @@ func TweetHandler(w http.ResponseWriter, r *http.Request) {
 		tweet.ParentId, _ = strconv.ParseInt(r.FormValue("parent"), 10, 64)
 	}
 
-	_, err = model.CreateTweet(tweet)
+	_, err = Data.CreateTweet(r.Context(), tweet)
+	if err == model.ErrBlocked {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		log.Println("Could not create tweet.\n", err)
+		logging.Error(r.Context(), "Could not create tweet.", "err", err)
 		return
 	}
 
@@ -119,14 +142,17 @@ func RetweetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	tweetId, err := strconv.ParseInt(r.FormValue("tweet_id"), 10, 64)
 	if err != nil {
-		log.Println("Invalid tweet ID: ", err)
+		logging.Warn(r.Context(), "Invalid tweet ID.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("UID: ", uid, "; TweetId: ", tweetId)
-	_, err = model.CreateRetweet(uid.(int64), tweetId)
+	_, err = Data.CreateRetweet(r.Context(), uid.(int64), tweetId)
+	if err == model.ErrBlocked {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		log.Println("Could not retweet.\n", err)
+		logging.Error(r.Context(), "Could not retweet.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -146,14 +172,17 @@ func LikeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	tweetId, err := strconv.ParseInt(r.FormValue("tweet_id"), 10, 64)
 	if err != nil {
-		log.Println("Invalid tweet ID: ", err)
+		logging.Warn(r.Context(), "Invalid tweet ID.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("UID: ", uid, "; TweetId: ", tweetId)
-	_, err = model.CreateLike(uid.(int64), tweetId)
+	_, err = Data.CreateLike(r.Context(), uid.(int64), tweetId)
+	if err == model.ErrBlocked {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		log.Println("Could not like.\n", err)
+		logging.Error(r.Context(), "Could not like.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -172,63 +201,116 @@ func FollowHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username := r.FormValue("username")
-	followed, err := model.GetUserIdFromUsername(username)
+	followed, err := Data.GetUserIdFromUsername(r.Context(), username)
 	if err != nil {
-		log.Println("Could not get user ID.\n", err)
+		logging.Error(r.Context(), "Could not get user ID.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("Follower: ", follower, "; Followed: ", followed)
-	_, err = model.CreateFollow(followed, follower.(int64))
+	following, err := Data.CreateFollow(r.Context(), followed, follower.(int64))
+	if err == model.ErrBlocked {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		log.Println("Could not follow user.\n", err)
+		logging.Error(r.Context(), "Could not follow user.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recommend.Invalidate(follower.(int64))
+	if following {
+		go timeline.Followed(logging.Detach(r.Context()), follower.(int64), followed)
+	}
 
-	http.Redirect(w, r, fmt.Sprintf("/%s", username), http.StatusMovedPermanently)
+	redirectBack(w, r, fmt.Sprintf("/%s", username))
 	return
 }
 
 func UserEditHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
-	// Check if user is authenticated
-	uid, ok := session.Values["uid"]
-	if ok == false {
-		http.Error(w, "", http.StatusInternalServerError)
-		return
-	}
-
-	username, _ := session.Values["username"]
-
-	if mux.Vars(r)["username"] != username {
-		log.Println("Could not authenticate user.")
-		http.Error(w, "Permission Denied", http.StatusInternalServerError)
+	user, _, ok := sessionUser(w, r)
+	if !ok {
 		return
 	}
 
 	displayName := r.FormValue("display_name")
 	bio := r.FormValue("bio")
 	location := r.FormValue("location")
-	website := r.FormValue("website")
+	birthday := r.FormValue("birthday")
+	protected := r.FormValue("protected") != ""
+
+	website, invalid := profileForm(displayName, bio, location, r.FormValue("website"), birthday)
+	if invalid != "" {
+		http.Redirect(w, r, fmt.Sprintf("/%s/edit?error=%s", user.Username, invalid), http.StatusFound)
+		return
+	}
 
 	userWithEdits := model.User{
 		DisplayName: displayName,
 		Bio: bio,
 		Location: location,
 		Website: website,
-		Id: uid.(int64),
-		Username: username.(string),
+		Birthday: birthday,
+		Protected: protected,
+		Id: user.Id,
+		Username: user.Username,
 	}
 
-	err := model.EditUser(userWithEdits)
+	err := Data.EditUser(r.Context(), userWithEdits)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		log.Println("Error editing: \n", err)
+		logging.Error(r.Context(), "Error editing.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("/%s", username), http.StatusMovedPermanently)
+	// Saving again re-checks an unverified website, so users can add the
+	// rel="me" link after setting it.
+	if website != "" && (website != user.Website || !user.WebsiteVerified) {
+		verifyWebsite(r.Context(), user.Id, website, user.Username)
+	}
+
+	for _, image := range profileImages {
+		err = updateProfileImage(r, user.Id, image)
+		if err == ErrInvalidImage {
+			http.Redirect(w, r, fmt.Sprintf("/%s/edit?error=%s_invalid", user.Username, image.field), http.StatusFound)
+			return
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Could not update profile image.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	email := r.FormValue("email")
+	if email != "" && !ValidEmail(email) {
+		http.Redirect(w, r, fmt.Sprintf("/%s/edit?error=email_invalid", user.Username), http.StatusFound)
+		return
+	}
+
+	if email != user.Email {
+		err = Data.SetEmail(r.Context(), user.Id, email)
+		if err == model.ErrEmailTaken {
+			http.Redirect(w, r, fmt.Sprintf("/%s/edit?error=email_taken", user.Username), http.StatusFound)
+			return
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Could not set email.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if email != "" {
+			err = sendVerificationEmail(r, user.Id, email)
+			if err != nil {
+				logging.Error(r.Context(), "Could not send verification email.", "err", err)
+			}
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/%s", user.Username), http.StatusMovedPermanently)
 	return
 }
 
@@ -241,6 +323,7 @@ func MessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+
 	conversationIdString := mux.Vars(r)["conversation_id"]
 	if conversationIdString == "" {
 		http.Redirect(w, r, "/messages", http.StatusMovedPermanently)
@@ -259,7 +342,7 @@ func MessageHandler(w http.ResponseWriter, r *http.Request) {
 		ConversationId: conversationId,
 	}
 
-	_, err = model.SmartCreateUser(request)
+	_, err = Data.CreateMessage(r.Context(), request)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
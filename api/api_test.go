@@ -0,0 +1,349 @@
+package store
+
+import (
+	"os"
+	"context"
+	"strings"
+	"strconv"
+	"testing"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+	mux "github.com/gorilla/mux"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	session "github.com/dustinnewman98/twitter_clone/session"
+)
+
+// useMemoryStore points the handlers at a fresh MemoryStore until the
+// returned function is called.
+func useMemoryStore() (*model.MemoryStore, func()) {
+	store := model.NewMemoryStore()
+	previous := Data
+	Data = store
+	return store, func() {
+		Data = previous
+	}
+}
+
+// signedIn builds a form POST from userId's session.
+func signedIn(t *testing.T, target string, form url.Values, userId int64) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	s, err := session.Store.New(r, LOGIN_COOKIE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Values["uid"] = userId
+	err = s.Save(r, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func createUser(t *testing.T, store model.Store, username string) int64 {
+	t.Helper()
+	userId, err := store.CreateUser(context.Background(), username, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userId
+}
+
+func TestTweetHandler(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	userId := createUser(t, store, "ada")
+
+	w := httptest.NewRecorder()
+	TweetHandler(w, signedIn(t, "/api/tweet", url.Values{"tweet": {"Hello"}}, userId))
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("Got status %d: %s", w.Code, w.Body)
+	}
+
+	history, err := store.GetHistory(context.Background(), userId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Text != "Hello" {
+		t.Errorf("Got history %+v", history)
+	}
+}
+
+func TestLikeHandler(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	author := createUser(t, store, "ada")
+	fan := createUser(t, store, "ben")
+	tweetId, err := store.CreateTweet(context.Background(), model.TweetRequest{UserId: author, Text: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	LikeHandler(w, signedIn(t, "/api/like", url.Values{"tweet_id": {strconv.FormatInt(tweetId, 10)}}, fan))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Got status %d: %s", w.Code, w.Body)
+	}
+	tweet, err := store.GetTweet(context.Background(), tweetId, fan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tweet.Liked {
+		t.Error("Tweet was not liked")
+	}
+
+	// Blocked users can't like.
+	err = store.CreateBlock(context.Background(), author, fan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	LikeHandler(w, signedIn(t, "/api/like", url.Values{"tweet_id": {strconv.FormatInt(tweetId, 10)}}, fan))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Got status %d liking a blocker's Tweet, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestFollowHandler(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	followed := createUser(t, store, "ada")
+	follower := createUser(t, store, "ben")
+
+	w := httptest.NewRecorder()
+	FollowHandler(w, signedIn(t, "/api/follow", url.Values{"username": {"ada"}}, follower))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/ada" {
+		t.Fatalf("Got status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	relationship, err := store.GetUsersRelationship(context.Background(), followed, follower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !relationship.SecondFollowsFirst {
+		t.Error("Follow was not created")
+	}
+}
+
+func TestApproveFollowRequestHandler(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	owner := createUser(t, store, "ada")
+	requester := createUser(t, store, "ben")
+	err := store.EditUser(context.Background(), model.User{Id: owner, Protected: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.CreateFollow(context.Background(), owner, requester)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	ApproveFollowRequestHandler(w, signedIn(t, "/api/follow_requests/approve", url.Values{"username": {"ben"}}, owner))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Got status %d: %s", w.Code, w.Body)
+	}
+	relationship, err := store.GetUsersRelationship(context.Background(), owner, requester)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !relationship.SecondFollowsFirst || relationship.SecondRequestedFirst {
+		t.Errorf("Got relationship %+v", relationship)
+	}
+}
+
+func TestBookmarkHandler(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	author := createUser(t, store, "ada")
+	reader := createUser(t, store, "ben")
+	tweetId, err := store.CreateTweet(context.Background(), model.TweetRequest{UserId: author, Text: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"tweet_id": {strconv.FormatInt(tweetId, 10)}}
+
+	w := httptest.NewRecorder()
+	BookmarkHandler(w, signedIn(t, "/api/bookmark", form, reader))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/bookmarks" {
+		t.Fatalf("Got status %d, location %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	tweets, hasMore, err := GetBookmarks(context.Background(), reader, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tweets) != 1 || tweets[0].Id != tweetId || hasMore {
+		t.Errorf("Got bookmarks %+v", tweets)
+	}
+
+	// Tweets the reader can't see can't be bookmarked.
+	err = store.CreateBlock(context.Background(), author, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	BookmarkHandler(w, signedIn(t, "/api/bookmark", form, reader))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Got status %d bookmarking a blocker's Tweet", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	UnbookmarkHandler(w, signedIn(t, "/api/unbookmark", form, reader))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Got status %d: %s", w.Code, w.Body)
+	}
+	err = store.DeleteBlock(context.Background(), author, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweets, _, err = GetBookmarks(context.Background(), reader, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tweets) != 0 {
+		t.Errorf("Got bookmarks %+v after removing", tweets)
+	}
+}
+
+func TestUserEditHandler(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	userId := createUser(t, store, "ada")
+	createUser(t, store, "ben")
+	form := url.Values{"display_name": {"Ada"}}
+
+	// The session has no username, so the user has to come from the uid.
+	w := httptest.NewRecorder()
+	UserEditHandler(w, mux.SetURLVars(signedIn(t, "/api/ada/edit", form, userId), map[string]string{"username": "ada"}))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/ada" {
+		t.Fatalf("Got status %d, location %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	user, err := store.GetUser(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DisplayName != "Ada" {
+		t.Errorf("Got display name %q", user.DisplayName)
+	}
+
+	// Nobody else's profile can be edited.
+	w = httptest.NewRecorder()
+	UserEditHandler(w, mux.SetURLVars(signedIn(t, "/api/ben/edit", form, userId), map[string]string{"username": "ben"}))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Got status %d editing another profile", w.Code)
+	}
+}
+
+func TestSessionVersionMiddleware(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	userId := createUser(t, store, "ada")
+
+	signedInAs := func() (int64, bool) {
+		var uid int64
+		var ok bool
+		handler := SessionVersionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+			uid, ok = s.Values["uid"].(int64)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), signedIn(t, "/api/tweet", nil, userId))
+		return uid, ok
+	}
+
+	if uid, ok := signedInAs(); !ok || uid != userId {
+		t.Fatalf("Got uid %d, %v before changing the password", uid, ok)
+	}
+
+	err := store.ChangePassword(context.Background(), userId, "changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, ok := signedInAs(); ok {
+		t.Errorf("Session for %d survived a password change", uid)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		page string
+		want int
+	}{
+		{"", 1},
+		{"2", 2},
+		{"0", 1},
+		{"-3", 1},
+		{"two", 1},
+		{strconv.Itoa(MAX_PAGE), MAX_PAGE},
+		{strconv.Itoa(MAX_PAGE + 1), MAX_PAGE},
+		{"9223372036854775807", MAX_PAGE},
+		{"99999999999999999999", 1},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/ada/followers?page=" + url.QueryEscape(test.page), nil)
+		if got := ParsePage(r); got != test.want {
+			t.Errorf("Got page %d for %q, want %d", got, test.page, test.want)
+		}
+	}
+}
+
+func TestRedirectBack(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"/ada?page=2", "/ada?page=2"},
+		{"/", "/"},
+		{"", "/fallback"},
+		{"ada", "/fallback"},
+		{"//evil.example", "/fallback"},
+		{"/\\evil.example", "/fallback"},
+		{"/\\/evil.example", "/fallback"},
+		{"https://evil.example", "/fallback"},
+		{"/\t/evil.example", "/fallback"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/api/pin", strings.NewReader(url.Values{"next": {test.next}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		redirectBack(w, r, "/fallback")
+		if location := w.Header().Get("Location"); location != test.want {
+			t.Errorf("Redirected to %q for next %q, want %q", location, test.next, test.want)
+		}
+	}
+}
+
+func TestInitSiteURL(t *testing.T) {
+	previous, previousEnv := siteURL, os.Getenv("BASE_URL")
+	defer func() {
+		siteURL = previous
+		os.Setenv("BASE_URL", previousEnv)
+	}()
+
+	for _, baseURL := range []string{"", "example.com", "ftp://example.com", "https://example.com/path"} {
+		siteURL = ""
+		os.Setenv("BASE_URL", baseURL)
+		if InitSiteURL() == nil {
+			t.Errorf("Accepted BASE_URL %q", baseURL)
+		}
+		if _, err := SiteURL(); err != ErrNoSiteURL {
+			t.Errorf("Got %v for BASE_URL %q, want ErrNoSiteURL", err, baseURL)
+		}
+	}
+
+	os.Setenv("BASE_URL", "https://example.com/")
+	if err := InitSiteURL(); err != nil {
+		t.Fatal(err)
+	}
+	if site, _ := SiteURL(); site != "https://example.com" {
+		t.Errorf("Got site URL %q", site)
+	}
+}
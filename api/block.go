@@ -0,0 +1,113 @@
+package store
+
+import (
+	"fmt"
+	"context"
+	"strings"
+	"net/url"
+	"net/http"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+	recommend "github.com/dustinnewman98/twitter_clone/recommend"
+	timeline "github.com/dustinnewman98/twitter_clone/timeline"
+)
+
+// localPath reports whether next is a path on this site. Browsers treat
+// backslashes like slashes, so "/\evil.example" is as off-site as
+// "//evil.example".
+func localPath(next string) bool {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return false
+	}
+	u, err := url.Parse(next)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// redirectBack sends the user to the local path in the "next" form value,
+// falling back to fallback so forms cannot redirect off-site.
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	next := r.FormValue("next")
+	if !localPath(next) {
+		next = fallback
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// relationshipHandler authenticates the request, resolves the "username"
+// form value and applies update to the pair.
+func relationshipHandler(update func(ctx context.Context, currentUserId, userId int64) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+		// Check if user is authenticated
+		uid, ok := session.Values["uid"]
+		if ok == false {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		username := r.FormValue("username")
+		userId, err := Data.GetUserIdFromUsername(r.Context(), username)
+		if err != nil {
+			logging.Error(r.Context(), "Could not get user ID.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if userId == uid.(int64) {
+			http.Error(w, "You cannot do that to yourself", http.StatusBadRequest)
+			return
+		}
+
+		err = update(r.Context(), uid.(int64), userId)
+		if err != nil {
+			logging.Error(r.Context(), "Could not update relationship.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recommend.Invalidate(uid.(int64))
+
+		redirectBack(w, r, fmt.Sprintf("/%s", username))
+		return
+	}
+}
+
+var BlockHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	err := Data.CreateBlock(ctx, currentUserId, userId)
+	if err != nil {
+		return err
+	}
+	timeline.Blocked(ctx, currentUserId, userId)
+	return nil
+})
+var UnblockHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	return Data.DeleteBlock(ctx, currentUserId, userId)
+})
+var MuteHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	return Data.CreateMute(ctx, currentUserId, userId)
+})
+var UnmuteHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	return Data.DeleteMute(ctx, currentUserId, userId)
+})
+
+var UnfollowHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	err := Data.DeleteFollow(ctx, userId, currentUserId)
+	if err != nil {
+		return err
+	}
+	timeline.Unfollowed(ctx, currentUserId, userId)
+	return nil
+})
+
+var ApproveFollowRequestHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	err := Data.ApproveFollowRequest(ctx, currentUserId, userId)
+	if err != nil {
+		return err
+	}
+	go timeline.Followed(logging.Detach(ctx), userId, currentUserId)
+	return nil
+})
+var DenyFollowRequestHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	return Data.DeleteFollowRequest(ctx, currentUserId, userId)
+})
+var CancelFollowRequestHandler = relationshipHandler(func(ctx context.Context, currentUserId, userId int64) error {
+	return Data.DeleteFollowRequest(ctx, userId, currentUserId)
+})
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"net/http"
+	"database/sql"
+	"encoding/json"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+)
+
+type BookmarksResponse struct {
+	Tweets []model.Tweet `json:"tweets"`
+	Query string `json:"q"`
+	Page int `json:"page"`
+	HasMore bool `json:"has_more"`
+}
+
+// GetBookmarks loads one page of userId's bookmarks matching query, fetching
+// one extra row to tell whether there is another page.
+func GetBookmarks(ctx context.Context, userId int64, query string, page int) ([]model.Tweet, bool, error) {
+	tweets, err := Data.GetBookmarks(ctx, userId, query, PAGE_SIZE + 1, (page - 1) * PAGE_SIZE)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(tweets) > PAGE_SIZE
+	if hasMore {
+		tweets = tweets[:PAGE_SIZE]
+	}
+	return tweets, hasMore, nil
+}
+
+func bookmarkHandler(remove bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+		// Check if user is authenticated
+		uid, ok := session.Values["uid"].(int64)
+		if ok == false {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		tweetId, err := strconv.ParseInt(r.FormValue("tweet_id"), 10, 64)
+		if err != nil {
+			logging.Warn(r.Context(), "Invalid tweet ID.", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if remove {
+			err = Data.DeleteBookmark(r.Context(), uid, tweetId)
+		} else {
+			// Only Tweets the user can see may be bookmarked.
+			_, err = Data.GetTweet(r.Context(), tweetId, uid)
+			if err == sql.ErrNoRows {
+				http.NotFound(w, r)
+				return
+			}
+			if err == nil {
+				err = Data.CreateBookmark(r.Context(), uid, tweetId)
+			}
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Could not update bookmark.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		redirectBack(w, r, "/bookmarks")
+	}
+}
+
+var BookmarkHandler = bookmarkHandler(false)
+var UnbookmarkHandler = bookmarkHandler(true)
+
+func BookmarksJSONHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	page := ParsePage(r)
+	query := r.URL.Query().Get("q")
+	tweets, hasMore, err := GetBookmarks(r.Context(), uid, query, page)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get bookmarks.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := BookmarksResponse{
+		Tweets: tweets,
+		Query: query,
+		Page: page,
+		HasMore: hasMore,
+	}
+	if response.Tweets == nil {
+		response.Tweets = []model.Tweet{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"fmt"
+	"time"
+	"context"
+	"net/http"
+	sessions "github.com/gorilla/sessions"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+const (
+	// DEACTIVATION_PERIOD is how long a deactivated account can be restored
+	// by signing in before it is deleted for good.
+	DEACTIVATION_PERIOD = 30 * 24 * time.Hour
+	PURGE_INTERVAL = time.Hour
+)
+
+// accountForm authenticates a deactivate or delete request and checks the
+// password field, returning the user or writing a response and returning
+// false.
+func accountForm(w http.ResponseWriter, r *http.Request, action string) (model.User, *sessions.Session, bool) {
+	user, session, ok := sessionUser(w, r)
+	if !ok {
+		return model.User{}, nil, false
+	}
+
+	if r.FormValue("password") != user.Password {
+		http.Redirect(w, r, fmt.Sprintf("/settings/account?error=%s_password", action), http.StatusFound)
+		return model.User{}, nil, false
+	}
+	return user, session, true
+}
+
+// endSession signs the user out and sends them to the login page.
+func endSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, account string) {
+	session.Values["uid"] = 0
+	session.Values["username"] = ""
+	session.Options.MaxAge = -1
+	err := session.Save(r, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/login?account=" + account, http.StatusFound)
+}
+
+// DeactivateHandler hides the signed-in account. Signing in again within
+// DEACTIVATION_PERIOD restores it.
+func DeactivateHandler(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := accountForm(w, r, "deactivate")
+	if !ok {
+		return
+	}
+
+	err := model.DeactivateUser(r.Context(), user.Id)
+	if err != nil {
+		logging.Error(r.Context(), "Could not deactivate user.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	endSession(w, r, session, "deactivated")
+}
+
+// DeleteAccountHandler permanently deletes the signed-in account. The form
+// repeats the username to confirm.
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := accountForm(w, r, "delete")
+	if !ok {
+		return
+	}
+	if r.FormValue("confirm_username") != user.Username {
+		http.Redirect(w, r, "/settings/account?error=delete_confirm", http.StatusFound)
+		return
+	}
+
+	err := DeleteAccount(r.Context(), user.Id)
+	if err != nil {
+		logging.Error(r.Context(), "Could not delete user.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	endSession(w, r, session, "deleted")
+}
+
+// DeleteAccount removes userId from the database and deletes their uploads.
+func DeleteAccount(ctx context.Context, userId int64) error {
+	files, err := model.GetUserFiles(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = Data.DeleteUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	deleteUserFiles(ctx, files)
+	return nil
+}
+
+// deleteUserFiles removes uploads from media storage. Failures are logged, not
+// returned, since the account they belonged to is already gone.
+func deleteUserFiles(ctx context.Context, files model.UserFiles) {
+	var names []string
+	for _, url := range files.TweetImages {
+		if name, ok := objectName(url); ok {
+			names = append(names, name)
+		}
+	}
+	if name, ok := objectName(files.AvatarURL); ok {
+		for _, size := range AVATAR_SIZES {
+			names = append(names, variant(name, size, size))
+		}
+	}
+	if name, ok := objectName(files.BannerURL); ok {
+		for _, width := range BANNER_WIDTHS {
+			names = append(names, variant(name, width, width / 3))
+		}
+	}
+	names = append(names, files.Exports...)
+	deleteObjects(ctx, names)
+}
+
+func deleteObjects(ctx context.Context, names []string) {
+	if Media == nil {
+		return
+	}
+	for _, name := range names {
+		err := deleteMedia(ctx, name)
+		if err != nil {
+			logging.Error(ctx, "Could not delete object.", "object", name, "err", err)
+		}
+	}
+}
+
+// purge deletes accounts deactivated for longer than DEACTIVATION_PERIOD and
+// exports older than EXPORT_TTL.
+func purge() {
+	ctx := context.Background()
+	userIds, err := model.GetExpiredDeactivations(ctx, time.Now().Add(-DEACTIVATION_PERIOD))
+	if err != nil {
+		logging.Error(ctx, "Could not get deactivated accounts.", "err", err)
+	}
+	for _, userId := range userIds {
+		err = DeleteAccount(ctx, userId)
+		if err != nil {
+			logging.Error(ctx, "Could not delete deactivated account.", "user_id", userId, "err", err)
+		}
+	}
+
+	exports, err := model.GetExpiredDataExports(ctx, time.Now().Add(-EXPORT_TTL))
+	if err != nil {
+		logging.Error(ctx, "Could not get expired exports.", "err", err)
+	}
+	for _, export := range exports {
+		if export.ObjectName != "" {
+			deleteObjects(ctx, []string{export.ObjectName})
+		}
+		err = model.DeleteDataExport(ctx, export.Id)
+		if err != nil {
+			logging.Error(ctx, "Could not delete export.", "export_id", export.Id, "err", err)
+		}
+	}
+}
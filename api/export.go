@@ -0,0 +1,237 @@
+package store
+
+import (
+	"io"
+	"fmt"
+	"path"
+	"time"
+	"context"
+	"net/http"
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	uuid "github.com/gofrs/uuid"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+	mail "github.com/dustinnewman98/twitter_clone/mail"
+)
+
+const (
+	// EXPORT_TTL is how long an archive can be downloaded before it is deleted.
+	EXPORT_TTL = 7 * 24 * time.Hour
+	// EXPORT_INTERVAL is how often a user may ask for a new archive.
+	EXPORT_INTERVAL = 24 * time.Hour
+)
+
+// RequestExportHandler starts building the signed-in user's archive in the
+// background. The account settings page shows its progress.
+func RequestExportHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	latest, err := model.GetLatestDataExport(r.Context(), uid)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Error(r.Context(), "Could not get export.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil && latest.Status != model.EXPORT_FAILED && time.Since(latest.CreatedAt) < EXPORT_INTERVAL {
+		http.Redirect(w, r, "/settings/account?error=export_recent", http.StatusFound)
+		return
+	}
+
+	exportId, err := model.CreateDataExport(r.Context(), uid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not create export.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go buildExport(logging.Detach(r.Context()), exportId, uid)
+
+	http.Redirect(w, r, "/settings/account?saved=export", http.StatusFound)
+}
+
+// buildExport writes the archive for exportId and emails the user, if they
+// have a verified address, once it can be downloaded.
+func buildExport(ctx context.Context, exportId, userId int64) {
+	archive, err := model.GetArchive(ctx, userId)
+	if err == nil {
+		var name string
+		name, err = writeExport(ctx, userId, archive)
+		if err == nil {
+			err = model.CompleteDataExport(ctx, exportId, name)
+		}
+	}
+	if err != nil {
+		logging.Error(ctx, "Could not build export.", "export_id", exportId, "err", err)
+		err = model.FailDataExport(ctx, exportId)
+		if err != nil {
+			logging.Error(ctx, "Could not mark export failed.", "export_id", exportId, "err", err)
+		}
+		return
+	}
+
+	site, err := SiteURL()
+	if err == nil && archive.Account.Email != "" && archive.Account.EmailVerified {
+		err = mail.Send(mail.Message{
+			To: archive.Account.Email,
+			Subject: "Your data is ready to download",
+			Body: fmt.Sprintf("Your archive is ready. Download it from:\n\n%s/settings/account\n\nIt will be available for 7 days.", site),
+		})
+		if err != nil {
+			logging.Error(ctx, "Could not send export email.", "export_id", exportId, "err", err)
+		}
+	}
+}
+
+// writeExport streams the archive to a private object and returns its name.
+func writeExport(ctx context.Context, userId int64, archive model.Archive) (string, error) {
+	name := fmt.Sprintf("exports/%d/%s.zip", userId, uuid.Must(uuid.NewV4()).String())
+	pr, pw := io.Pipe()
+	go func() {
+		// A failed archive fails the Put, which discards the partial object.
+		pw.CloseWithError(WriteArchive(ctx, pw, archive))
+	}()
+
+	err := putMedia(ctx, "exports", name, "application/zip", pr, false)
+	pr.Close()
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// WriteArchive writes a zip of the archive to w, one JSON file per section
+// plus the user's uploaded images under media/. Images are skipped when
+// storage is not configured.
+func WriteArchive(ctx context.Context, w io.Writer, archive model.Archive) error {
+	zw := zip.NewWriter(w)
+	sections := []struct {
+		file string
+		value interface{}
+	}{
+		{"account.json", archive.Account},
+		{"tweets.json", archive.Tweets},
+		{"likes.json", archive.Likes},
+		{"retweets.json", archive.Retweets},
+		{"bookmarks.json", archive.Bookmarks},
+		{"following.json", archive.Following},
+		{"followers.json", archive.Followers},
+		{"blocking.json", archive.Blocking},
+		{"muting.json", archive.Muting},
+		{"lists.json", archive.Lists},
+		{"messages.json", archive.Messages},
+	}
+	for _, section := range sections {
+		f, err := zw.Create(section.file)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(section.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	var media []string
+	for _, tweet := range archive.Tweets {
+		media = append(media, tweet.ImageURL)
+	}
+	if archive.Account.AvatarURL != "" {
+		media = append(media, AvatarURL(archive.Account.AvatarURL, AVATAR_SIZES[0]))
+	}
+	if archive.Account.BannerURL != "" {
+		media = append(media, BannerURL(archive.Account.BannerURL, BANNER_WIDTHS[0]))
+	}
+	for _, url := range media {
+		object, ok := objectName(url)
+		if !ok || Media == nil {
+			continue
+		}
+		err := copyObject(ctx, zw, "media/" + path.Base(object), object)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// copyObject adds a stored object to the zip. Objects that have gone missing
+// are skipped.
+func copyObject(ctx context.Context, zw *zip.Writer, file, object string) error {
+	r, err := openMedia(ctx, object)
+	if err == ErrMediaNotExist {
+		logging.Warn(ctx, "Export skipped missing object.", "object", object)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := zw.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// DownloadExportHandler streams the signed-in user's latest archive while it
+// has not expired.
+func DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	username, _ := session.Values["username"].(string)
+
+	export, err := model.GetLatestDataExport(r.Context(), uid)
+	if err == sql.ErrNoRows || (err == nil && (export.Status != model.EXPORT_READY || time.Since(export.CreatedAt) > EXPORT_TTL)) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not get export.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if Media == nil {
+		http.NotFound(w, r)
+		return
+	}
+	reader, err := openMedia(r.Context(), export.ObjectName)
+	if err == ErrMediaNotExist {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not read export.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.zip"`, username, export.CreatedAt.Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "private, no-store")
+	_, err = io.Copy(w, reader)
+	if err != nil {
+		logging.Error(r.Context(), "Could not send export.", "err", err)
+	}
+}
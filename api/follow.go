@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"net/http"
+	"database/sql"
+	"encoding/json"
+	mux "github.com/gorilla/mux"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+)
+
+const (
+	PAGE_SIZE = 20
+	// MAX_PAGE keeps (page - 1) * PAGE_SIZE well within an OFFSET.
+	MAX_PAGE = 10000
+)
+
+type UserListResponse struct {
+	Users []model.UserListItem `json:"users"`
+	Page int `json:"page"`
+	HasMore bool `json:"has_more"`
+}
+
+// ParsePage reads the 1-based "page" query parameter, defaulting to 1 and
+// clamped to MAX_PAGE.
+func ParsePage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	if page > MAX_PAGE {
+		return MAX_PAGE
+	}
+	return page
+}
+
+// GetConnections loads one page of username's followers or followings as
+// seen by currentUserId. It fetches one extra row to tell whether there is
+// another page, and reports whether the viewer may see them at all; when
+// they may not, there are no rows.
+func GetConnections(ctx context.Context, username string, currentUserId int64, followers bool, page int) (model.User, bool, []model.UserListItem, bool, error) {
+	user, err := Data.GetUserFromUsername(ctx, username)
+	if err != nil {
+		return model.User{}, false, nil, false, err
+	}
+	// Deactivated and suspended accounts are hidden as if they did not exist.
+	if user.Deactivated || user.Suspended {
+		return model.User{}, false, nil, false, sql.ErrNoRows
+	}
+
+	visible, err := Data.CanSeeTweets(ctx, user.Id, currentUserId)
+	if err != nil {
+		return model.User{}, false, nil, false, err
+	}
+	if !visible {
+		return user, false, nil, false, nil
+	}
+
+	var users []model.UserListItem
+	if followers {
+		users, err = Data.GetFollowers(ctx, user.Id, currentUserId, PAGE_SIZE + 1, (page - 1) * PAGE_SIZE)
+	} else {
+		users, err = Data.GetFollowing(ctx, user.Id, currentUserId, PAGE_SIZE + 1, (page - 1) * PAGE_SIZE)
+	}
+	if err != nil {
+		return model.User{}, false, nil, false, err
+	}
+
+	hasMore := len(users) > PAGE_SIZE
+	if hasMore {
+		users = users[:PAGE_SIZE]
+	}
+	return user, true, users, hasMore, nil
+}
+
+func connectionsJSONHandler(followers bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+		// Check if user is authenticated
+		uid, ok := session.Values["uid"].(int64)
+		if ok == false {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		page := ParsePage(r)
+		_, _, users, hasMore, err := GetConnections(r.Context(), mux.Vars(r)["username"], uid, followers, page)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Could not get connections.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := UserListResponse{
+			Users: users,
+			Page: page,
+			HasMore: hasMore,
+		}
+		if response.Users == nil {
+			response.Users = []model.UserListItem{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+var FollowersJSONHandler = connectionsJSONHandler(true)
+var FollowingJSONHandler = connectionsJSONHandler(false)
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"fmt"
+	"context"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"mime/multipart"
+	_ "image/gif"
+	_ "image/png"
+	uuid "github.com/gofrs/uuid"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+const (
+	MAX_PROFILE_IMAGE_BYTES = 5 << 20
+	// MAX_PROFILE_IMAGE_PIXELS guards against small files that decode to
+	// enormous images.
+	MAX_PROFILE_IMAGE_PIXELS = 25000000
+	PROFILE_IMAGE_QUALITY = 85
+	DEFAULT_AVATAR = "/static/bird.png"
+)
+
+// AVATAR_SIZES are the square avatar variants stored for every upload.
+var AVATAR_SIZES = []int{400, 96}
+
+// BANNER_WIDTHS are the 3:1 banner variants stored for every upload.
+var BANNER_WIDTHS = []int{1500, 600}
+
+var ErrInvalidImage = errors.New("profile images must be JPEG, PNG or GIF files under 5 MB")
+
+// AvatarURL returns the size×size variant of an uploaded avatar, or the
+// default avatar when there is none. base is the value stored on the user.
+func AvatarURL(base string, size int) string {
+	if base == "" {
+		return DEFAULT_AVATAR
+	}
+	return variant(base, size, size)
+}
+
+// BannerURL returns the variant of an uploaded banner with the given width,
+// or "" when there is none.
+func BannerURL(base string, width int) string {
+	if base == "" {
+		return ""
+	}
+	return variant(base, width, width / 3)
+}
+
+func decodeProfileImage(f multipart.File, fh *multipart.FileHeader) (image.Image, error) {
+	if fh.Size > MAX_PROFILE_IMAGE_BYTES {
+		return nil, ErrInvalidImage
+	}
+	config, _, err := image.DecodeConfig(f)
+	if err != nil || config.Width * config.Height > MAX_PROFILE_IMAGE_PIXELS {
+		return nil, ErrInvalidImage
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	return img, nil
+}
+
+// cropToAspect returns the largest centred region of img with the aspect
+// ratio width:height.
+func cropToAspect(img image.Image, width, height int) image.Rectangle {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w * height > h * width {
+		w = h * width / height
+	} else {
+		h = w * height / width
+	}
+	x := bounds.Min.X + (bounds.Dx() - w) / 2
+	y := bounds.Min.Y + (bounds.Dy() - h) / 2
+	return image.Rect(x, y, x + w, y + h)
+}
+
+// resize scales the src region of img to width×height, averaging the source
+// pixels behind each destination pixel so downscaled images do not alias.
+// The result is opaque.
+func resize(img image.Image, src image.Rectangle, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y * src.Dy() / height
+		y1 := src.Min.Y + (y + 1) * src.Dy() / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x * src.Dx() / width
+			x1 := src.Min.X + (x + 1) * src.Dx() / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			// JPEG has no alpha channel, so flatten onto white.
+			white := 0xffff - a / n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r / n + white) >> 8),
+				G: uint8((g / n + white) >> 8),
+				B: uint8((b / n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// variant names the width×height JPEG stored alongside base.
+func variant(base string, width, height int) string {
+	return fmt.Sprintf("%s_%dx%d.jpg", base, width, height)
+}
+
+// uploadProfileImage crops the uploaded image to width:height, stores a JPEG
+// for each entry in widths and returns the base URL the variants share.
+func uploadProfileImage(ctx context.Context, f multipart.File, fh *multipart.FileHeader, prefix string, width, height int, widths []int) (string, error) {
+	img, err := decodeProfileImage(f, fh)
+	if err != nil {
+		return "", err
+	}
+	crop := cropToAspect(img, width, height)
+
+	name := prefix + "/" + uuid.Must(uuid.NewV4()).String()
+	for _, w := range widths {
+		h := w * height / width
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, resize(img, crop, w, h), &jpeg.Options{Quality: PROFILE_IMAGE_QUALITY})
+		if err != nil {
+			return "", err
+		}
+		_, err = uploadObject(ctx, prefix, variant(name, w, h), "image/jpeg", &buf)
+		if err != nil {
+			return "", err
+		}
+	}
+	return objectURL(name), nil
+}
+
+func uploadAvatar(ctx context.Context, f multipart.File, fh *multipart.FileHeader) (string, error) {
+	return uploadProfileImage(ctx, f, fh, "avatars", 1, 1, AVATAR_SIZES)
+}
+
+func uploadBanner(ctx context.Context, f multipart.File, fh *multipart.FileHeader) (string, error) {
+	return uploadProfileImage(ctx, f, fh, "banners", 3, 1, BANNER_WIDTHS)
+}
+
+type profileImage struct {
+	field string
+	upload func(context.Context, multipart.File, *multipart.FileHeader) (string, error)
+	set func(ctx context.Context, userId int64, url string) error
+}
+
+var profileImages = []profileImage{
+	{"avatar", uploadAvatar, model.SetAvatar},
+	{"banner", uploadBanner, model.SetBanner},
+}
+
+// updateProfileImage applies the image's file input, or its remove_ checkbox,
+// from the edit form.
+func updateProfileImage(r *http.Request, userId int64, image profileImage) error {
+	f, fh, err := r.FormFile(image.field)
+	if err == http.ErrMissingFile || err == http.ErrNotMultipart {
+		if r.FormValue("remove_" + image.field) != "" {
+			return image.set(r.Context(), userId, "")
+		}
+		return nil
+	}
+	if err != nil {
+		return ErrInvalidImage
+	}
+	defer f.Close()
+
+	url, err := image.upload(r.Context(), f, fh)
+	if err != nil {
+		return err
+	}
+	return image.set(r.Context(), userId, url)
+}
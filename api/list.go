@@ -0,0 +1,146 @@
+package store
+
+import (
+	"fmt"
+	"context"
+	"strconv"
+	"strings"
+	"net/http"
+	"database/sql"
+	"unicode/utf8"
+	mux "github.com/gorilla/mux"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+)
+
+const (
+	MAX_LIST_NAME_LENGTH = 25
+	MAX_LIST_DESCRIPTION_LENGTH = 100
+)
+
+// listForm reads and validates the name, description and private fields of
+// a list form, returning an error code for the page when they are invalid.
+func listForm(r *http.Request) (string, string, bool, string) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	description := strings.TrimSpace(r.FormValue("description"))
+	private := r.FormValue("private") != ""
+
+	if name == "" || utf8.RuneCountInString(name) > MAX_LIST_NAME_LENGTH {
+		return "", "", false, "name"
+	}
+	if utf8.RuneCountInString(description) > MAX_LIST_DESCRIPTION_LENGTH {
+		return "", "", false, "description"
+	}
+	return name, description, private, ""
+}
+
+func CreateListHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	name, description, private, invalid := listForm(r)
+	if invalid != "" {
+		http.Redirect(w, r, "/lists?error=" + invalid, http.StatusFound)
+		return
+	}
+
+	listId, err := model.CreateList(r.Context(), uid, name, description, private)
+	if err != nil {
+		logging.Error(r.Context(), "Could not create list.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/lists/%d/members", listId), http.StatusFound)
+}
+
+// listHandler authenticates the request, parses the list_id route variable
+// and applies update to the list. Lists that do not exist, or that the user
+// may not see or change, are a 404.
+func listHandler(update func(r *http.Request, listId, currentUserId int64) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+		// Check if user is authenticated
+		uid, ok := session.Values["uid"].(int64)
+		if ok == false {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		listId, err := strconv.ParseInt(mux.Vars(r)["list_id"], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		err = update(r, listId, uid)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		if err == model.ErrBlocked {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Could not update list.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		redirectBack(w, r, fmt.Sprintf("/lists/%d", listId))
+	}
+}
+
+func EditListHandler(w http.ResponseWriter, r *http.Request) {
+	name, description, private, invalid := listForm(r)
+	if invalid != "" {
+		http.Redirect(w, r, fmt.Sprintf("/lists/%s/edit?error=%s", mux.Vars(r)["list_id"], invalid), http.StatusFound)
+		return
+	}
+
+	listHandler(func(r *http.Request, listId, currentUserId int64) error {
+		return model.UpdateList(r.Context(), listId, currentUserId, name, description, private)
+	})(w, r)
+}
+
+var DeleteListHandler = listHandler(func(r *http.Request, listId, currentUserId int64) error {
+	return model.DeleteList(r.Context(), listId, currentUserId)
+})
+
+// listMemberUpdate resolves the "username" form value before calling update.
+func listMemberUpdate(update func(ctx context.Context, listId, ownerId, userId int64) error) func(*http.Request, int64, int64) error {
+	return func(r *http.Request, listId, currentUserId int64) error {
+		userId, err := Data.GetUserIdFromUsername(r.Context(), strings.TrimPrefix(r.FormValue("username"), "@"))
+		if err != nil {
+			return err
+		}
+		return update(r.Context(), listId, currentUserId, userId)
+	}
+}
+
+func AddListMemberHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := Data.GetUserIdFromUsername(r.Context(), strings.TrimPrefix(r.FormValue("username"), "@"))
+	if err == sql.ErrNoRows {
+		http.Redirect(w, r, fmt.Sprintf("/lists/%s/members?error=username", mux.Vars(r)["list_id"]), http.StatusFound)
+		return
+	}
+
+	listHandler(listMemberUpdate(model.AddListMember))(w, r)
+}
+
+var RemoveListMemberHandler = listHandler(listMemberUpdate(model.RemoveListMember))
+
+var SubscribeListHandler = listHandler(func(r *http.Request, listId, currentUserId int64) error {
+	return model.SubscribeList(r.Context(), listId, currentUserId)
+})
+var UnsubscribeListHandler = listHandler(func(r *http.Request, listId, currentUserId int64) error {
+	return model.UnsubscribeList(r.Context(), listId, currentUserId)
+})
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"path"
+	"errors"
+	"context"
+	"net/http"
+	"path/filepath"
+	storage "cloud.google.com/go/storage"
+	metrics "github.com/dustinnewman98/twitter_clone/metrics"
+	tracing "github.com/dustinnewman98/twitter_clone/tracing"
+)
+
+// MediaStore holds uploaded images and data exports. Public objects are
+// served from URL; private ones are only read back through Open.
+type MediaStore interface {
+	Put(ctx context.Context, name, contentType string, r io.Reader, public bool) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+	URL(name string) string
+}
+
+// ErrMediaNotExist is returned by Open for objects that are missing.
+var ErrMediaNotExist = errors.New("media object does not exist")
+
+// GCSMedia keeps media in a Google Cloud Storage bucket.
+type GCSMedia struct {
+	Bucket *storage.BucketHandle
+	Name string
+}
+
+// LocalMedia keeps media in Dir and serves public objects under /media/. It
+// is meant for local development and tests.
+type LocalMedia struct {
+	Dir string
+}
+
+// Media is nil when storage is not configured, in which case uploads fail
+// and deletions are skipped.
+var Media MediaStore
+
+var (
+	uploadSize = metrics.NewHistogram("media_upload_size_bytes", "Size of stored uploads by kind.", metrics.SIZE_BUCKETS, "kind")
+	uploadFailures = metrics.NewCounter("media_upload_failures_total", "Uploads that could not be stored, by kind.", "kind")
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// putMedia stores r under name, recording its size or the failure under
+// kind, such as "tweets" or "exports".
+func putMedia(ctx context.Context, kind, name, contentType string, r io.Reader, public bool) error {
+	if Media == nil {
+		uploadFailures.Inc(kind)
+		return errors.New("media storage is not configured")
+	}
+	ctx, span := tracing.Start(ctx, "media.put", tracing.CLIENT,
+		"media.kind", kind,
+		"media.name", name,
+		"media.public", public,
+	)
+	defer span.End()
+
+	counter := &countingReader{Reader: r}
+	err := Media.Put(ctx, name, contentType, counter, public)
+	span.SetAttributes("media.size", counter.n)
+	if err != nil {
+		uploadFailures.Inc(kind)
+		span.SetError(err)
+		return err
+	}
+	uploadSize.Observe(float64(counter.n), kind)
+	return nil
+}
+
+// openMedia opens the object name for reading. Only opening is traced, not
+// the reads that follow.
+func openMedia(ctx context.Context, name string) (io.ReadCloser, error) {
+	ctx, span := tracing.Start(ctx, "media.open", tracing.CLIENT, "media.name", name)
+	defer span.End()
+	r, err := Media.Open(ctx, name)
+	if err != ErrMediaNotExist {
+		span.SetError(err)
+	}
+	return r, err
+}
+
+func deleteMedia(ctx context.Context, name string) error {
+	ctx, span := tracing.Start(ctx, "media.delete", tracing.CLIENT, "media.name", name)
+	defer span.End()
+	err := Media.Delete(ctx, name)
+	span.SetError(err)
+	return err
+}
+
+func (m GCSMedia) Put(ctx context.Context, name, contentType string, r io.Reader, public bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	// Cancelling before Close discards a partly written object.
+	defer cancel()
+
+	w := m.Bucket.Object(name).NewWriter(ctx)
+	if public {
+		w.ACL = []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}}
+	}
+	w.ContentType = contentType
+	_, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (m GCSMedia) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	r, err := m.Bucket.Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrMediaNotExist
+	}
+	return r, err
+}
+
+func (m GCSMedia) Delete(ctx context.Context, name string) error {
+	return m.Bucket.Object(name).Delete(ctx)
+}
+
+func (m GCSMedia) URL(name string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", m.Name, name)
+}
+
+// path returns where name is kept. Public and private objects live in
+// separate directories so that only public ones are ever served.
+func (m LocalMedia) path(name string, public bool) string {
+	dir := "private"
+	if public {
+		dir = "public"
+	}
+	// Cleaning from the root keeps names from escaping Dir.
+	return filepath.Join(m.Dir, dir, filepath.FromSlash(path.Clean("/" + name)))
+}
+
+func (m LocalMedia) Put(ctx context.Context, name, contentType string, r io.Reader, public bool) error {
+	file := m.path(name, public)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	return nil
+}
+
+func (m LocalMedia) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(m.path(name, true))
+	if os.IsNotExist(err) {
+		f, err = os.Open(m.path(name, false))
+	}
+	if os.IsNotExist(err) {
+		return nil, ErrMediaNotExist
+	}
+	return f, err
+}
+
+func (m LocalMedia) Delete(ctx context.Context, name string) error {
+	for _, public := range []bool{true, false} {
+		err := os.Remove(m.path(name, public))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m LocalMedia) URL(name string) string {
+	return "/media/" + name
+}
+
+func (m LocalMedia) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.StripPrefix("/media/", http.FileServer(http.Dir(filepath.Join(m.Dir, "public")))).ServeHTTP(w, r)
+}
+
+// MediaHandler serves uploads when they are stored locally.
+func MediaHandler(w http.ResponseWriter, r *http.Request) {
+	local, ok := Media.(LocalMedia)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	local.ServeHTTP(w, r)
+}
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"strconv"
+	"net/http"
+	"database/sql"
+	"unicode/utf8"
+	mux "github.com/gorilla/mux"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+)
+
+const (
+	MAX_REPORT_COMMENT_LENGTH = 500
+	MAX_SUSPENSION_DAYS = 3650
+)
+
+func validCategory(category string) bool {
+	for _, c := range model.REPORT_CATEGORIES {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// suspended reports whether userId is barred from posting. Errors count as
+// not suspended so a database hiccup doesn't lock everyone out.
+func suspended(ctx context.Context, userId int64) bool {
+	isSuspended, err := Data.IsSuspended(ctx, userId)
+	return err == nil && isSuspended
+}
+
+// SuspensionMiddleware refuses every signed-in request from a suspended
+// account, reads included, and ends its session, so a suspension takes
+// effect at once rather than at the next sign in.
+func SuspensionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+		uid, ok := session.Values["uid"].(int64)
+		if ok == false || !suspended(r.Context(), uid) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session.Values["uid"] = 0
+		session.Values["username"] = ""
+		session.Options.MaxAge = -1
+		err := session.Save(r, w)
+		if err != nil {
+			logging.Error(r.Context(), "Could not end session.", "err", err)
+		}
+		http.Error(w, "Your account is suspended", http.StatusForbidden)
+	})
+}
+
+// ReportHandler files a report about the Tweet, user or message named by the
+// form's tweet_id, username or message_id.
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	category := r.FormValue("category")
+	comment := r.FormValue("comment")
+	if !validCategory(category) {
+		http.Error(w, "Choose a reason for your report", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(comment) > MAX_REPORT_COMMENT_LENGTH {
+		http.Error(w, "Comment is too long", http.StatusBadRequest)
+		return
+	}
+
+	var kind string
+	var targetId int64
+	var err error
+	switch {
+	case r.FormValue("tweet_id") != "":
+		kind = model.REPORT_TWEET
+		targetId, err = strconv.ParseInt(r.FormValue("tweet_id"), 10, 64)
+		if err == nil {
+			// Only Tweets the reporter can see may be reported.
+			_, err = Data.GetTweet(r.Context(), targetId, uid)
+		}
+	case r.FormValue("message_id") != "":
+		kind = model.REPORT_MESSAGE
+		targetId, err = strconv.ParseInt(r.FormValue("message_id"), 10, 64)
+	default:
+		kind = model.REPORT_USER
+		var user model.User
+		user, err = Data.GetUserFromUsername(r.Context(), r.FormValue("username"))
+		targetId = user.Id
+	}
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = model.CreateReport(r.Context(), uid, kind, targetId, category, comment)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not create report.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/report?sent=1")
+}
+
+// authorize returns the signed in user if they hold role, answering 401 or
+// 403 otherwise.
+func authorize(w http.ResponseWriter, r *http.Request, role string) (int64, bool) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Error(w, "", http.StatusUnauthorized)
+		return 0, false
+	}
+	allowed, err := model.HasRole(r.Context(), uid, role)
+	if err != nil || !allowed {
+		http.Error(w, "", http.StatusForbidden)
+		return 0, false
+	}
+	return uid, true
+}
+
+// suspensionEnd reads the form's days field: a number of days from now, or
+// the zero time for an indefinite suspension when it is blank.
+func suspensionEnd(r *http.Request) (time.Time, bool) {
+	if r.FormValue("days") == "" {
+		return time.Time{}, true
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 1 || days > MAX_SUSPENSION_DAYS {
+		return time.Time{}, false
+	}
+	return time.Now().AddDate(0, 0, days), true
+}
+
+// ResolveReportHandler applies a moderator's decision to a report. Only
+// moderators and admins may use it.
+func ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authorize(w, r, model.ROLE_MODERATOR)
+	if !ok {
+		return
+	}
+
+	reportId, err := strconv.ParseInt(mux.Vars(r)["report_id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	action := r.FormValue("action")
+	reason := r.FormValue("reason")
+	if utf8.RuneCountInString(reason) > MAX_REPORT_COMMENT_LENGTH {
+		http.Error(w, "Reason is too long", http.StatusBadRequest)
+		return
+	}
+
+	var until time.Time
+	if action == model.ACTION_SUSPEND {
+		until, ok = suspensionEnd(r)
+		if !ok {
+			http.Error(w, "Invalid suspension length", http.StatusBadRequest)
+			return
+		}
+	}
+
+	imageURL, err := model.ResolveReport(r.Context(), reportId, uid, action, reason, until)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Report is already resolved or the action does not apply", http.StatusConflict)
+		return
+	}
+	if err == model.ErrOwnReport || err == model.ErrOutranked {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not resolve report.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	deleteImage(r.Context(), imageURL)
+
+	redirectBack(w, r, fmt.Sprintf("/moderation?resolved=%d", reportId))
+}
+
+// DeleteTweet removes a Tweet and its uploaded image.
+func DeleteTweet(ctx context.Context, tweetId int64) error {
+	imageURL, err := Data.DeleteTweet(ctx, tweetId)
+	if err != nil {
+		return err
+	}
+	deleteImage(ctx, imageURL)
+	return nil
+}
+
+// deleteImage removes a deleted Tweet's upload from media storage.
+func deleteImage(ctx context.Context, imageURL string) {
+	if name, ok := objectName(imageURL); ok {
+		deleteObjects(ctx, []string{name})
+	}
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"net/http"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+)
+
+func pinHandler(pin bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+		// Check if user is authenticated
+		uid, ok := session.Values["uid"].(int64)
+		if ok == false {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		username, _ := session.Values["username"].(string)
+		tweetId, err := strconv.ParseInt(r.FormValue("tweet_id"), 10, 64)
+		if err != nil {
+			logging.Warn(r.Context(), "Invalid tweet ID.", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if pin {
+			err = Data.PinTweet(r.Context(), uid, tweetId)
+		} else {
+			err = Data.UnpinTweet(r.Context(), uid, tweetId)
+		}
+		if err == sql.ErrNoRows {
+			http.Error(w, "You can only pin your own Tweets", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Could not update pinned tweet.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		redirectBack(w, r, fmt.Sprintf("/%s", username))
+	}
+}
+
+var PinHandler = pinHandler(true)
+var UnpinHandler = pinHandler(false)
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"net/url"
+	"unicode/utf8"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	relme "github.com/dustinnewman98/twitter_clone/relme"
+)
+
+// Profile field limits match the users table columns.
+const (
+	MAX_DISPLAY_NAME_LENGTH = 50
+	MAX_BIO_LENGTH = 160
+	MAX_LOCATION_LENGTH = 30
+	MAX_WEBSITE_LENGTH = 100
+)
+
+var ErrInvalidWebsite = errors.New("website must be an http or https URL")
+
+// normalizeWebsite turns what a user typed into an absolute http(s) URL.
+// Bare hosts such as "example.com" get https://. Anything with another
+// scheme, credentials or no host is rejected.
+func normalizeWebsite(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", ErrInvalidWebsite
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", ErrInvalidWebsite
+	}
+	if u.Host == "" || u.User != nil || strings.ContainsAny(u.Host, " <>\"'") {
+		return "", ErrInvalidWebsite
+	}
+	u.Host = strings.ToLower(u.Host)
+	website := u.String()
+	if utf8.RuneCountInString(website) > MAX_WEBSITE_LENGTH {
+		return "", ErrInvalidWebsite
+	}
+	return website, nil
+}
+
+// validBirthday accepts "" or a YYYY-MM-DD date between 1900 and today.
+func validBirthday(birthday string) bool {
+	if birthday == "" {
+		return true
+	}
+	date, err := time.Parse("2006-01-02", birthday)
+	return err == nil && date.Year() >= 1900 && date.Before(time.Now())
+}
+
+// profileForm reads the profile fields of the edit form, returning an error
+// code for the page when one is invalid.
+func profileForm(displayName, bio, location, website, birthday string) (string, string) {
+	if utf8.RuneCountInString(displayName) > MAX_DISPLAY_NAME_LENGTH {
+		return "", "display_name_long"
+	}
+	if utf8.RuneCountInString(bio) > MAX_BIO_LENGTH {
+		return "", "bio_long"
+	}
+	if utf8.RuneCountInString(location) > MAX_LOCATION_LENGTH {
+		return "", "location_long"
+	}
+	if !validBirthday(birthday) {
+		return "", "birthday_invalid"
+	}
+	website, err := normalizeWebsite(website)
+	if err != nil {
+		return "", "website_invalid"
+	}
+	return website, ""
+}
+
+// verifyWebsite checks in the background whether website links back to
+// username's profile with rel="me" and records the result.
+func verifyWebsite(ctx context.Context, userId int64, website, username string) {
+	site, err := SiteURL()
+	if err != nil {
+		logging.Error(ctx, "Could not verify website.", "err", err)
+		return
+	}
+	profileURL := fmt.Sprintf("%s/%s", site, username)
+	ctx = logging.Detach(ctx)
+	go func() {
+		verified, err := relme.Verify(website, profileURL)
+		if err != nil {
+			logging.Warn(ctx, "Could not verify website.", "website", website, "err", err)
+		}
+		err = model.SetWebsiteVerified(ctx, userId, website, verified)
+		if err != nil {
+			logging.Error(ctx, "Could not record website verification.", "err", err)
+		}
+	}()
+}
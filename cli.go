@@ -0,0 +1,393 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"flag"
+	"time"
+	"bufio"
+	"strconv"
+	"strings"
+	"context"
+	"database/sql"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	api "github.com/dustinnewman98/twitter_clone/api"
+	seed "github.com/dustinnewman98/twitter_clone/seed"
+	timeline "github.com/dustinnewman98/twitter_clone/timeline"
+)
+
+const usage = `Usage: twitter_clone [command]
+
+Commands:
+  serve                                         run the web server (the default)
+  init-db                                       create or update the database schema
+  user create [-password P] [-email E] [-role R] USERNAME
+  user reset-password [-password P] USERNAME
+  user set-role USERNAME ROLE
+  user delete -yes USERNAME
+  tweet delete TWEET_ID
+  follow-graph stats [-top N]
+  timeline backfill [USERNAME...]               rebuild materialized home timelines, all of them by default
+  export [-o FILE] USERNAME
+  seed [-seed N] [-users N] [-follows N] [-tweets N] ...
+                                                fill a development database with generated data
+
+Passwords are read from standard input when -password is not given.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		serve()
+		return
+	}
+	runCommand(os.Args[1], os.Args[2:])
+}
+
+// runCommand dispatches an administrative subcommand. Errors are fatal, so
+// scripts can rely on the exit status.
+func runCommand(name string, args []string) {
+	switch name {
+	case "serve":
+		serve()
+	case "init-db":
+		model.InitDB()
+		log.Println("Database schema is up to date.")
+	case "user", "tweet", "follow-graph", "timeline":
+		if len(args) == 0 {
+			usageError("%s needs a subcommand", name)
+		}
+		runCommand(name + " " + args[0], args[1:])
+	case "user create":
+		userCreate(args)
+	case "user reset-password":
+		userResetPassword(args)
+	case "user set-role":
+		userSetRole(args)
+	case "user delete":
+		userDelete(args)
+	case "tweet delete":
+		tweetDelete(args)
+	case "follow-graph stats":
+		followGraphStats(args)
+	case "timeline backfill":
+		timelineBackfill(args)
+	case "export":
+		export(args)
+	case "seed":
+		seedDatabase(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		usageError("unknown command %q", name)
+	}
+}
+
+func usageError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format + "\n\n", args...)
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(2)
+}
+
+// parseFlags parses a subcommand's flags and returns its positional
+// arguments, which must number exactly n unless n is negative.
+func parseFlags(fs *flag.FlagSet, args []string, n int) []string {
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fmt.Fprintf(os.Stderr, "\nFlags for %s:\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if n >= 0 && fs.NArg() != n {
+		usageError("%s takes %d argument(s)", fs.Name(), n)
+	}
+	return fs.Args()
+}
+
+// readPassword returns password, or a line read from standard input when it
+// is empty, so passwords needn't appear in shell history.
+func readPassword(password string) string {
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("Could not read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if !api.ValidPassword(password) {
+		log.Fatalf("Passwords must be 1 to %d characters.", api.MAX_PASSWORD_LENGTH)
+	}
+	return password
+}
+
+func lookupUser(ctx context.Context, username string) model.User {
+	user, err := model.GetUserFromUsername(ctx, strings.TrimPrefix(username, "@"))
+	if err == sql.ErrNoRows {
+		log.Fatalf("No user named %s.", username)
+	}
+	if err != nil {
+		log.Fatalf("Could not get user: %v", err)
+	}
+	return user
+}
+
+// initStorage connects to media storage for commands that remove uploads.
+// Without it they still update the database and leave files in place.
+func initStorage() {
+	err := api.Init()
+	if err != nil {
+		log.Println("Storage is not configured; uploaded files will be left in place.")
+	}
+}
+
+func userCreate(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	password := fs.String("password", "", "password for the account")
+	email := fs.String("email", "", "verified email address for the account")
+	role := fs.String("role", model.ROLE_USER, "one of " + strings.Join(model.ROLES, ", "))
+	username := parseFlags(fs, args, 1)[0]
+
+	if !api.ValidUsername(username) {
+		log.Fatalf("%q is not a valid username.", username)
+	}
+	if *email != "" && !api.ValidEmail(*email) {
+		log.Fatalf("%q is not a valid email address.", *email)
+	}
+	if !model.ValidRole(*role) {
+		log.Fatalf("Unknown role %q.", *role)
+	}
+	model.InitDB()
+	if _, err := model.GetUserIdFromUsername(ctx, username); err == nil {
+		log.Fatalf("%s is already taken.", username)
+	}
+
+	userId, err := model.CreateUser(ctx, username, readPassword(*password))
+	if err != nil {
+		log.Fatalf("Could not create user: %v", err)
+	}
+	if *email != "" {
+		// The operator vouches for the address, so it is verified at once.
+		err = model.SetEmail(ctx, userId, *email)
+		if err == nil {
+			err = model.VerifyEmail(ctx, userId, *email)
+		}
+		if err != nil {
+			log.Fatalf("Could not set email: %v", err)
+		}
+	}
+	if *role != model.ROLE_USER {
+		err = model.SetRole(ctx, 0, userId, *role)
+		if err != nil {
+			log.Fatalf("Could not set role: %v", err)
+		}
+	}
+	log.Printf("Created %s (id %d).", username, userId)
+}
+
+func userResetPassword(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	password := fs.String("password", "", "new password")
+	username := parseFlags(fs, args, 1)[0]
+
+	model.InitDB()
+	user := lookupUser(ctx, username)
+	err := model.ChangePassword(ctx, user.Id, readPassword(*password))
+	if err != nil {
+		log.Fatalf("Could not change password: %v", err)
+	}
+	log.Printf("Changed the password for %s.", user.Username)
+}
+
+func userSetRole(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("user set-role", flag.ExitOnError)
+	positional := parseFlags(fs, args, 2)
+	username, role := positional[0], positional[1]
+
+	if !model.ValidRole(role) {
+		log.Fatalf("Unknown role %q.", role)
+	}
+	model.InitDB()
+	user := lookupUser(ctx, username)
+	err := model.SetRole(ctx, 0, user.Id, role)
+	if err != nil {
+		log.Fatalf("Could not set role: %v", err)
+	}
+	log.Printf("%s is now %s.", user.Username, role)
+}
+
+func userDelete(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("user delete", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "confirm permanent deletion")
+	username := parseFlags(fs, args, 1)[0]
+
+	if !*yes {
+		log.Fatalf("This permanently deletes %s and everything they posted. Pass -yes to confirm.", username)
+	}
+	model.InitDB()
+	user := lookupUser(ctx, username)
+	initStorage()
+	err := api.DeleteAccount(ctx, user.Id)
+	if err != nil {
+		log.Fatalf("Could not delete user: %v", err)
+	}
+	log.Printf("Deleted %s.", user.Username)
+}
+
+func tweetDelete(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("tweet delete", flag.ExitOnError)
+	positional := parseFlags(fs, args, 1)
+	tweetId, err := strconv.ParseInt(positional[0], 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid tweet ID %q", positional[0])
+	}
+
+	model.InitDB()
+	initStorage()
+	err = api.DeleteTweet(ctx, tweetId)
+	if err == sql.ErrNoRows {
+		log.Fatalf("No tweet with ID %d.", tweetId)
+	}
+	if err != nil {
+		log.Fatalf("Could not delete tweet: %v", err)
+	}
+	log.Printf("Deleted tweet %d.", tweetId)
+}
+
+func followGraphStats(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("follow-graph stats", flag.ExitOnError)
+	top := fs.Int("top", 10, "how many of the most followed accounts to list")
+	parseFlags(fs, args, 0)
+
+	model.InitDB()
+	stats, err := model.GetFollowGraphStats(ctx, *top)
+	if err != nil {
+		log.Fatalf("Could not get follow graph stats: %v", err)
+	}
+
+	fmt.Printf("Users:             %d\n", stats.Users)
+	fmt.Printf("Follows:           %d\n", stats.Follows)
+	if stats.Users > 0 {
+		fmt.Printf("Mean followers:    %.2f\n", float64(stats.Follows) / float64(stats.Users))
+	}
+	fmt.Printf("Median followers:  %.1f\n", stats.MedianFollowers)
+	fmt.Printf("Mutual pairs:      %d\n", stats.MutualPairs)
+	fmt.Printf("No followers:      %d\n", stats.NoFollowers)
+	fmt.Printf("Following nobody:  %d\n", stats.NoFollowing)
+	if len(stats.TopFollowed) > 0 {
+		fmt.Println("\nMost followed:")
+		for _, count := range stats.TopFollowed {
+			fmt.Printf("  %-30s %d\n", count.Username, count.Followers)
+		}
+	}
+}
+
+func export(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("o", "", "file to write, or - for standard output (default USERNAME-DATE.zip)")
+	username := parseFlags(fs, args, 1)[0]
+
+	model.InitDB()
+	user := lookupUser(ctx, username)
+	initStorage()
+	archive, err := model.GetArchive(ctx, user.Id)
+	if err != nil {
+		log.Fatalf("Could not get archive: %v", err)
+	}
+
+	path := *out
+	if path == "" {
+		path = fmt.Sprintf("%s-%s.zip", user.Username, time.Now().Format("2006-01-02"))
+	}
+	f := os.Stdout
+	if path != "-" {
+		f, err = os.Create(path)
+		if err != nil {
+			log.Fatalf("Could not create %s: %v", path, err)
+		}
+	}
+	err = api.WriteArchive(context.Background(), f, archive)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Fatalf("Could not write archive: %v", err)
+	}
+	if path != "-" {
+		log.Printf("Wrote %s.", path)
+	}
+}
+
+// timelineBackfill rebuilds the home_timelines table from follows, Tweets and
+// retweets. Run it after switching to TIMELINE_STORE=postgres, or to repair
+// drifted timelines.
+func timelineBackfill(args []string) {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("timeline backfill", flag.ExitOnError)
+	usernames := parseFlags(fs, args, -1)
+
+	model.InitDB()
+	timeline.SetStore(timeline.PostgresStore{})
+	if len(usernames) == 0 {
+		err := timeline.BackfillAll(ctx)
+		if err != nil {
+			log.Fatalf("Could not backfill timelines: %v", err)
+		}
+		log.Println("Backfilled all timelines.")
+		return
+	}
+	for _, username := range usernames {
+		user := lookupUser(ctx, username)
+		err := timeline.Backfill(ctx, user.Id)
+		if err != nil {
+			log.Fatalf("Could not backfill the timeline for %s: %v", user.Username, err)
+		}
+		log.Printf("Backfilled the timeline for %s.", user.Username)
+	}
+}
+
+func seedDatabase(args []string) {
+	ctx := context.Background()
+	config := seed.DefaultConfig
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	fs.Int64Var(&config.Seed, "seed", config.Seed, "random seed; the same seed generates the same data")
+	fs.StringVar(&config.Prefix, "prefix", config.Prefix, "prefix for generated usernames")
+	fs.StringVar(&config.Password, "password", config.Password, "password for every generated account")
+	fs.IntVar(&config.Users, "users", config.Users, "number of accounts")
+	fs.Float64Var(&config.Popularity, "popularity", config.Popularity, "power-law exponent for who gets followed")
+	fs.IntVar(&config.MeanFollows, "follows", config.MeanFollows, "mean accounts followed per user")
+	fs.IntVar(&config.TweetsPerUser, "tweets", config.TweetsPerUser, "mean Tweets per user")
+	fs.Float64Var(&config.ReplyRate, "replies", config.ReplyRate, "fraction of Tweets that are replies")
+	fs.IntVar(&config.MeanLikes, "likes", config.MeanLikes, "mean likes per Tweet")
+	fs.IntVar(&config.MeanRetweets, "retweets", config.MeanRetweets, "mean retweets per Tweet")
+	fs.IntVar(&config.Conversations, "conversations", config.Conversations, "number of DM conversations")
+	fs.IntVar(&config.MessagesPerConversation, "messages", config.MessagesPerConversation, "mean messages per conversation")
+	fs.Float64Var(&config.ProtectedRate, "protected", config.ProtectedRate, "fraction of protected accounts")
+	parseFlags(fs, args, 0)
+
+	model.InitDB()
+	summary, err := seed.Generate(ctx, config)
+	if err != nil {
+		log.Fatalf("Could not seed database: %v", err)
+	}
+	log.Printf("Created %d users, %d follows, %d Tweets (%d replies), %d likes, %d retweets, %d conversations and %d messages.",
+		summary.Users, summary.Follows, summary.Tweets, summary.Replies, summary.Likes, summary.Retweets,
+		summary.Conversations, summary.Messages)
+
+	// The in-memory timeline store rebuilds itself at startup; the
+	// materialized one has to be backfilled.
+	if os.Getenv("TIMELINE_STORE") == "postgres" {
+		timeline.SetStore(timeline.PostgresStore{})
+		err = timeline.BackfillAll(ctx)
+		if err != nil {
+			log.Fatalf("Could not backfill timelines: %v", err)
+		}
+	}
+}
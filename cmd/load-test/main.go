@@ -0,0 +1,275 @@
+// Command load-test signs in as many accounts created by `twitter_clone seed`
+// and replays a mix of home feed reads, profile views, Tweets, likes and
+// direct messages against a running server, then prints latency
+// percentiles per route.
+//
+// The server rate limits by IP address, so a single machine quickly gets
+// throttled. Start the server with TRUST_PROXY=1 and pass -forwarded to
+// give every simulated user its own address. Per-user limits still apply,
+// and throttled requests are counted separately from errors.
+//
+//	twitter_clone seed
+//	TRUST_PROXY=1 PORT=8080 twitter_clone serve
+//	go run ./cmd/load-test -users 100 -duration 2m -forwarded
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"log"
+	"flag"
+	"sync"
+	"time"
+	"regexp"
+	"strings"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	"math/rand"
+	"net/http/cookiejar"
+	seed "github.com/dustinnewman98/twitter_clone/seed"
+)
+
+// action is one thing a simulated user does, chosen in proportion to
+// weight.
+type action struct {
+	weight int
+	run func(u *user) error
+}
+
+// The mix is roughly what a timeline product sees: mostly reading, with
+// likes far more common than Tweets.
+var actions = []action{
+	{50, (*user).readHome},
+	{20, (*user).viewProfile},
+	{15, (*user).like},
+	{10, (*user).message},
+	{5, (*user).tweet},
+}
+
+var (
+	tweetLink = regexp.MustCompile(`href="/tweet/(\d+)"`)
+	conversationLink = regexp.MustCompile(`href="/messages/(\d+)"`)
+)
+
+type config struct {
+	url string
+	users int
+	seededUsers int
+	prefix string
+	password string
+	duration time.Duration
+	think time.Duration
+	seed int64
+	forwarded bool
+}
+
+// user is one simulated account with its own session.
+type user struct {
+	config *config
+	stats *stats
+	client *http.Client
+	rand *rand.Rand
+	username string
+	ip string
+	tweetIds []string
+	conversationIds []string
+}
+
+func main() {
+	var c config
+	flag.StringVar(&c.url, "url", "http://localhost:8080", "base URL of the server")
+	flag.IntVar(&c.users, "users", 50, "number of simultaneous users")
+	flag.IntVar(&c.seededUsers, "seeded-users", seed.DefaultConfig.Users, "number of accounts the database was seeded with")
+	flag.StringVar(&c.prefix, "prefix", seed.DefaultConfig.Prefix, "username prefix the database was seeded with")
+	flag.StringVar(&c.password, "password", seed.DefaultConfig.Password, "password the database was seeded with")
+	flag.DurationVar(&c.duration, "duration", time.Minute, "how long to generate load for")
+	flag.DurationVar(&c.think, "think", time.Second, "mean pause between a user's requests")
+	flag.Int64Var(&c.seed, "seed", 1, "random seed for choosing users and actions")
+	flag.BoolVar(&c.forwarded, "forwarded", false, "send a distinct X-Forwarded-For per user; needs TRUST_PROXY on the server")
+	flag.Parse()
+	c.url = strings.TrimRight(c.url, "/")
+
+	if c.users > c.seededUsers {
+		log.Fatalf("Can't simulate %d users with only %d seeded accounts", c.users, c.seededUsers)
+	}
+
+	s := newStats()
+	source := rand.New(rand.NewSource(c.seed))
+	accounts := source.Perm(c.seededUsers)[:c.users]
+
+	log.Printf("Running %d users against %s for %s...", c.users, c.url, c.duration)
+	start := time.Now()
+	deadline := start.Add(c.duration)
+	var wg sync.WaitGroup
+	for i, account := range accounts {
+		jar, _ := cookiejar.New(nil)
+		u := &user{
+			config: &c,
+			stats: s,
+			client: &http.Client{
+				Jar: jar,
+				Timeout: 30 * time.Second,
+				// Redirects are part of the response, not another request.
+				CheckRedirect: func(*http.Request, []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			},
+			rand: rand.New(rand.NewSource(c.seed + int64(i) + 1)),
+			username: seed.Username(c.prefix, account),
+			ip: fmt.Sprintf("10.%d.%d.%d", i >> 16 & 255, i >> 8 & 255, i & 255),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := u.run(deadline)
+			if err != nil {
+				log.Printf("%s stopped: %v", u.username, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.report(os.Stdout, time.Since(start))
+}
+
+func (u *user) run(deadline time.Time) error {
+	// Spread sign-ins out so they don't all land in the first instant.
+	time.Sleep(time.Duration(u.rand.Int63n(int64(u.config.think) + 1)))
+	err := u.login()
+	if err != nil {
+		return err
+	}
+	// The inbox is read once to learn which conversations to reply to.
+	body, err := u.get("GET /messages", "/messages")
+	if err != nil {
+		return err
+	}
+	u.conversationIds = ids(conversationLink, body)
+
+	total := 0
+	for _, a := range actions {
+		total += a.weight
+	}
+	for time.Now().Before(deadline) {
+		pick := u.rand.Intn(total)
+		for _, a := range actions {
+			if pick < a.weight {
+				err = a.run(u)
+				break
+			}
+			pick -= a.weight
+		}
+		if err != nil {
+			log.Printf("%s: %v", u.username, err)
+		}
+		time.Sleep(time.Duration(u.rand.ExpFloat64() * float64(u.config.think)))
+	}
+	return nil
+}
+
+// do sends a request and records its latency under route, returning the
+// response body.
+func (u *user) do(route, method, path string, form url.Values) (string, int, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	request, err := http.NewRequest(method, u.config.url + path, body)
+	if err != nil {
+		return "", 0, err
+	}
+	if form != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if u.config.forwarded {
+		request.Header.Set("X-Forwarded-For", u.ip)
+	}
+
+	start := time.Now()
+	response, err := u.client.Do(request)
+	if err != nil {
+		u.stats.record(route, time.Since(start), 0)
+		return "", 0, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	u.stats.record(route, time.Since(start), response.StatusCode)
+	return string(content), response.StatusCode, err
+}
+
+func (u *user) get(route, path string) (string, error) {
+	body, _, err := u.do(route, "GET", path, nil)
+	return body, err
+}
+
+func (u *user) post(route, path string, form url.Values) error {
+	_, _, err := u.do(route, "POST", path, form)
+	return err
+}
+
+func (u *user) login() error {
+	form := url.Values{"username": {u.username}, "password": {u.config.password}}
+	_, status, err := u.do("POST /login", "POST", "/login", form)
+	if err != nil {
+		return err
+	}
+	// A successful sign in redirects; anything else renders the form again.
+	if status < 300 || status >= 400 {
+		return fmt.Errorf("could not sign in (status %d); check -prefix and -password", status)
+	}
+	return nil
+}
+
+func (u *user) readHome() error {
+	body, err := u.get("GET /", "/")
+	if err != nil {
+		return err
+	}
+	if tweetIds := ids(tweetLink, body); len(tweetIds) > 0 {
+		u.tweetIds = tweetIds
+	}
+	return nil
+}
+
+func (u *user) viewProfile() error {
+	username := seed.Username(u.config.prefix, u.rand.Intn(u.config.seededUsers))
+	_, err := u.get("GET /{username}", "/" + username)
+	return err
+}
+
+func (u *user) like() error {
+	if len(u.tweetIds) == 0 {
+		return u.readHome()
+	}
+	form := url.Values{"tweet_id": {u.tweetIds[u.rand.Intn(len(u.tweetIds))]}}
+	return u.post("POST /api/like", "/api/like", form)
+}
+
+func (u *user) message() error {
+	if len(u.conversationIds) == 0 {
+		return u.readHome()
+	}
+	conversationId := u.conversationIds[u.rand.Intn(len(u.conversationIds))]
+	form := url.Values{"message": {"load test message"}}
+	return u.post("POST /api/messages/{id}", "/api/messages/" + conversationId, form)
+}
+
+func (u *user) tweet() error {
+	form := url.Values{"tweet": {fmt.Sprintf("Load testing at %s #loadtest", time.Now().Format(time.Kitchen))}}
+	return u.post("POST /api/tweet", "/api/tweet", form)
+}
+
+// ids returns the distinct first submatches of pattern in body.
+func ids(pattern *regexp.Regexp, body string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, match := range pattern.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			result = append(result, match[1])
+		}
+	}
+	return result
+}
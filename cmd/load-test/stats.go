@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"net/http"
+)
+
+// stats collects the latency of every request, grouped by route.
+type stats struct {
+	mu sync.Mutex
+	routes map[string]*routeStats
+}
+
+type routeStats struct {
+	latencies []time.Duration
+	errors int
+	throttled int
+}
+
+func newStats() *stats {
+	return &stats{routes: map[string]*routeStats{}}
+}
+
+// record adds one request. Failed requests still count towards latency,
+// since slow failures are what a load test is looking for.
+func (s *stats) record(route string, latency time.Duration, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs, ok := s.routes[route]
+	if !ok {
+		rs = &routeStats{}
+		s.routes[route] = rs
+	}
+	rs.latencies = append(rs.latencies, latency)
+	switch {
+	case status == http.StatusTooManyRequests:
+		rs.throttled++
+	case status == 0 || status >= 400:
+		rs.errors++
+	}
+}
+
+// percentile returns the latency below which p of the sorted latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// report writes a table of request counts and latency percentiles per route.
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%-28s %8s %8s %8s %8s %9s %9s %9s %9s\n",
+		"ROUTE", "REQS", "REQ/S", "ERRORS", "429S", "P50", "P90", "P99", "MAX")
+	for _, name := range names {
+		rs := s.routes[name]
+		sorted := append([]time.Duration(nil), rs.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		fmt.Fprintf(w, "%-28s %8d %8.1f %8d %8d %9s %9s %9s %9s\n",
+			name, len(sorted), float64(len(sorted)) / elapsed.Seconds(), rs.errors, rs.throttled,
+			round(percentile(sorted, 0.5)), round(percentile(sorted, 0.9)),
+			round(percentile(sorted, 0.99)), round(percentile(sorted, 1)))
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
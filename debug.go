@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"log"
+	"strings"
+	"net/http"
+	"net/http/pprof"
+	"crypto/subtle"
+	metrics "github.com/dustinnewman98/twitter_clone/metrics"
+)
+
+// secretMatches compares in constant time so the secret can't be guessed
+// from response times.
+func secretMatches(given, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+// metricsHandler serves /metrics to requests with "Authorization: Bearer
+// <token>". The site only serves it when METRICS_TOKEN is set; otherwise the
+// metrics are on the debug listener alone.
+func metricsHandler(token string) http.Handler {
+	handler := metrics.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(authorization, "Bearer ") ||
+			!secretMatches(strings.TrimPrefix(authorization, "Bearer "), token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// debugHandler serves pprof and the metrics behind HTTP basic auth with the
+// given password.
+func debugHandler(password string) http.Handler {
+	debug := http.NewServeMux()
+	debug.HandleFunc("/debug/pprof/", pprof.Index)
+	debug.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debug.Handle("/metrics", metrics.Handler())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, given, ok := r.BasicAuth()
+		if !ok || !secretMatches(given, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="debug"`)
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		debug.ServeHTTP(w, r)
+	})
+}
+
+// serveDebug starts the debug listener on DEBUG_ADDR, such as
+// "localhost:6060". It stays off unless DEBUG_PASSWORD is set too.
+func serveDebug() {
+	addr := os.Getenv("DEBUG_ADDR")
+	if addr == "" {
+		return
+	}
+	password := os.Getenv("DEBUG_PASSWORD")
+	if password == "" {
+		log.Println("Could not start debug listener: DEBUG_PASSWORD is not set.")
+		return
+	}
+	go func() {
+		log.Println("Debug listener stopped.", http.ListenAndServe(addr, debugHandler(password)))
+	}()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	mux "github.com/gorilla/mux"
+)
+
+func hasRoute(router *mux.Router, template string) bool {
+	found := false
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if path, err := route.GetPathTemplate(); err == nil && path == template {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+func TestMetricsRoute(t *testing.T) {
+	previous := os.Getenv("METRICS_TOKEN")
+	defer os.Setenv("METRICS_TOKEN", previous)
+
+	// Without a token the metrics stay on the debug listener.
+	os.Unsetenv("METRICS_TOKEN")
+	if hasRoute(newRouter(), "/metrics") {
+		t.Error("Registered /metrics without METRICS_TOKEN")
+	}
+
+	os.Setenv("METRICS_TOKEN", "secret")
+	if !hasRoute(newRouter(), "/metrics") {
+		t.Error("Did not register /metrics with METRICS_TOKEN")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	tests := []struct {
+		token string
+		authorization string
+		want int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		metricsHandler(test.token).ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("Got status %d for token %q and %q, want %d", w.Code, test.token, test.authorization, test.want)
+		}
+	}
+}
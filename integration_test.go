@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"os"
+	"fmt"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	"database/sql"
+	"mime/multipart"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	_ "github.com/lib/pq"
+	api "github.com/dustinnewman98/twitter_clone/api"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	ratelimit "github.com/dustinnewman98/twitter_clone/ratelimit"
+	timeline "github.com/dustinnewman98/twitter_clone/timeline"
+)
+
+// The integration tests drive the real router over HTTP against Postgres.
+// They are skipped unless TEST_DATABASE_URL names a database the tests may
+// create schemas in. Every test gets a schema of its own, dropped when the
+// test ends, and keeps uploads in a temporary directory.
+const TEST_DATABASE_ENV = "TEST_DATABASE_URL"
+
+type testServer struct {
+	*httptest.Server
+	t *testing.T
+}
+
+// setenv sets key until the returned function is called.
+func setenv(key, value string, unset bool) func() {
+	previous, ok := os.LookupEnv(key)
+	if unset {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// withSearchPath points dsn at schema. lib/pq passes unknown settings on to
+// the server, so search_path applies to every connection in the pool.
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// startServer creates a fresh schema and serves newRouter against it until
+// the returned function is called.
+func startServer(t *testing.T) (*testServer, func()) {
+	t.Helper()
+	dsn := os.Getenv(TEST_DATABASE_ENV)
+	if dsn == "" {
+		t.Skipf("%s is not set", TEST_DATABASE_ENV)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d_%d", os.Getpid(), time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	restoreURL := setenv("DATABASE_URL", withSearchPath(dsn, schema), false)
+	restoreUser := setenv("POSTGRES_USER", "", true)
+	model.InitDB()
+	ratelimit.Init()
+
+	mediaDir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousMedia := api.Media
+	api.Media = api.LocalMedia{Dir: mediaDir}
+
+	server := &testServer{Server: httptest.NewServer(newHandler()), t: t}
+	restoreBaseURL := setenv("BASE_URL", server.URL, false)
+	err = api.InitSiteURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, func() {
+		server.Close()
+		restoreBaseURL()
+		model.CloseDB()
+		api.Media = previousMedia
+		os.RemoveAll(mediaDir)
+		restoreUser()
+		restoreURL()
+
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Errorf("Could not drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	}
+}
+
+// testClient is one browser: it keeps its session cookie and reports
+// redirects instead of following them.
+type testClient struct {
+	t *testing.T
+	server *testServer
+	client *http.Client
+}
+
+type testResponse struct {
+	Code int
+	Location string
+	Body string
+}
+
+func (s *testServer) client() *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return &testClient{
+		t: s.t,
+		server: s,
+		client: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(r *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (c *testClient) do(r *http.Request) testResponse {
+	c.t.Helper()
+	resp, err := c.client.Do(r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return testResponse{Code: resp.StatusCode, Location: resp.Header.Get("Location"), Body: string(body)}
+}
+
+func (c *testClient) get(path string) testResponse {
+	c.t.Helper()
+	r, err := http.NewRequest("GET", c.server.URL + path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.do(r)
+}
+
+func (c *testClient) post(path string, form url.Values) testResponse {
+	c.t.Helper()
+	r, err := http.NewRequest("POST", c.server.URL + path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(r)
+}
+
+// login signs in as username, creating the account if it is new.
+func (c *testClient) login(username, password string) testResponse {
+	c.t.Helper()
+	return c.post("/login", url.Values{"username": {username}, "password": {password}})
+}
+
+// signUp returns a client signed in to a new account.
+func (s *testServer) signUp(username string) (*testClient, int64) {
+	s.t.Helper()
+	c := s.client()
+	expectRedirect(s.t, c.login(username, "password"), http.StatusMovedPermanently, "/welcome")
+	userId, err := api.Data.GetUserIdFromUsername(context.Background(), username)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return c, userId
+}
+
+func expectStatus(t *testing.T, resp testResponse, code int) {
+	t.Helper()
+	if resp.Code != code {
+		t.Fatalf("Got status %d, want %d: %s", resp.Code, code, resp.Body)
+	}
+}
+
+func expectRedirect(t *testing.T, resp testResponse, code int, location string) {
+	t.Helper()
+	expectStatus(t, resp, code)
+	if resp.Location != location {
+		t.Fatalf("Redirected to %q, want %q", resp.Location, location)
+	}
+}
+
+// latestTweet returns the newest Tweet in userId's history.
+func latestTweet(t *testing.T, userId int64) model.Tweet {
+	t.Helper()
+	history, err := api.Data.GetHistory(context.Background(), userId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) == 0 {
+		t.Fatal("No Tweets in history")
+	}
+	return history[0]
+}
+
+func TestIntegrationLogin(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+
+	ada := server.client()
+	expectRedirect(t, ada.login("ada", "secret"), http.StatusMovedPermanently, "/welcome")
+	expectStatus(t, ada.get("/notifications"), http.StatusOK)
+
+	// Signing in again goes home rather than creating an account.
+	again := server.client()
+	expectRedirect(t, again.login("ada", "secret"), http.StatusMovedPermanently, "/")
+	expectStatus(t, again.get("/notifications"), http.StatusOK)
+
+	// A wrong password shows the form again without a session.
+	wrong := server.client()
+	expectStatus(t, wrong.login("ada", "guess"), http.StatusOK)
+	expectRedirect(t, wrong.get("/notifications"), http.StatusMovedPermanently, "/login")
+
+	// Names that are invalid or shadow a page can't be signed up for.
+	for _, username := range []string{"", "no spaces", "Admin"} {
+		invalid := server.client()
+		expectStatus(t, invalid.login(username, "secret"), http.StatusOK)
+		expectRedirect(t, invalid.get("/notifications"), http.StatusMovedPermanently, "/login")
+	}
+
+	// Nor can a username someone has given up.
+	expectStatus(t, ada.post("/api/ada/username", url.Values{"username": {"ada2"}}), http.StatusFound)
+	// Ada's other session still works under the new name.
+	expectRedirect(t, again.post("/api/ada2/edit", url.Values{"display_name": {"Ada"}}), http.StatusMovedPermanently, "/ada2")
+	renamed := server.client()
+	expectStatus(t, renamed.login("ada", "secret"), http.StatusOK)
+	expectRedirect(t, renamed.get("/notifications"), http.StatusMovedPermanently, "/login")
+
+	expectRedirect(t, ada.get("/logout"), http.StatusFound, "/login")
+	expectRedirect(t, ada.get("/notifications"), http.StatusMovedPermanently, "/login")
+}
+
+func TestIntegrationPasswordChange(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+
+	ada := server.client()
+	expectRedirect(t, ada.login("ada", "secret"), http.StatusMovedPermanently, "/welcome")
+	other := server.client()
+	expectRedirect(t, other.login("ada", "secret"), http.StatusMovedPermanently, "/")
+
+	expectRedirect(t, ada.post("/api/ada/password", url.Values{
+		"current_password": {"secret"},
+		"new_password": {"changed"},
+		"confirm_password": {"changed"},
+	}), http.StatusFound, "/ada/edit?saved=password")
+
+	// The session that changed the password stays signed in; the other one
+	// is signed out.
+	expectStatus(t, ada.get("/notifications"), http.StatusOK)
+	expectRedirect(t, other.get("/notifications"), http.StatusMovedPermanently, "/login")
+	expectRedirect(t, other.login("ada", "changed"), http.StatusMovedPermanently, "/")
+	expectStatus(t, other.get("/notifications"), http.StatusOK)
+}
+
+func TestIntegrationTweetImage(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	ada, adaId := server.signUp("ada")
+
+	image := []byte("\x89PNG\r\n\x1a\nnot really an image")
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("tweet", "Look at this")
+	part, err := form.CreateFormFile("image", "cat.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(image)
+	form.Close()
+
+	r, err := http.NewRequest("POST", server.URL + "/api/tweet", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	expectRedirect(t, ada.do(r), http.StatusMovedPermanently, "/")
+
+	tweet := latestTweet(t, adaId)
+	if tweet.Text != "Look at this" || !strings.HasPrefix(tweet.ImageURL, "/media/") || !strings.HasSuffix(tweet.ImageURL, ".png") {
+		t.Fatalf("Got Tweet %+v", tweet)
+	}
+	page := ada.get(fmt.Sprintf("/tweet/%d", tweet.Id))
+	expectStatus(t, page, http.StatusOK)
+	if !strings.Contains(page.Body, tweet.ImageURL) {
+		t.Errorf("Tweet page does not show %s", tweet.ImageURL)
+	}
+
+	// Images are public.
+	served := server.client().get(tweet.ImageURL)
+	expectStatus(t, served, http.StatusOK)
+	if served.Body != string(image) {
+		t.Errorf("Got image %q", served.Body)
+	}
+	expectStatus(t, server.client().get("/media/missing.png"), http.StatusNotFound)
+}
+
+func TestIntegrationTweetSignedOut(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	_, adaId := server.signUp("ada")
+
+	anonymous := server.client()
+	expectRedirect(t, anonymous.post("/api/tweet", url.Values{"tweet": {"Hello"}}), http.StatusMovedPermanently, "/login")
+	history, err := api.Data.GetHistory(context.Background(), adaId, adaId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("Got history %+v", history)
+	}
+}
+
+func TestIntegrationFollowAndLike(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	ada, adaId := server.signUp("ada")
+	ben, benId := server.signUp("ben")
+
+	expectRedirect(t, ben.post("/api/follow", url.Values{"username": {"ada"}}), http.StatusFound, "/ada")
+	relationship, err := api.Data.GetUsersRelationship(context.Background(), adaId, benId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !relationship.SecondFollowsFirst {
+		t.Fatal("Follow was not created")
+	}
+
+	expectRedirect(t, ada.post("/api/tweet", url.Values{"tweet": {"Hello followers"}}), http.StatusMovedPermanently, "/")
+	tweet := latestTweet(t, adaId)
+	feed := ben.get("/")
+	expectStatus(t, feed, http.StatusOK)
+	if !strings.Contains(feed.Body, "Hello followers") {
+		t.Error("Followed Tweet is missing from the feed")
+	}
+
+	tweetId := fmt.Sprint(tweet.Id)
+	expectRedirect(t, ben.post("/api/like", url.Values{"tweet_id": {tweetId}}), http.StatusFound, "/")
+	notifications := ada.get("/notifications")
+	expectStatus(t, notifications, http.StatusOK)
+	if !strings.Contains(notifications.Body, "/ben") || !strings.Contains(notifications.Body, "liked") {
+		t.Error("Like notification is missing")
+	}
+
+	// Signed out and blocked users can't like or follow.
+	anonymous := server.client()
+	if resp := anonymous.post("/api/like", url.Values{"tweet_id": {tweetId}}); resp.Code < 400 {
+		t.Errorf("Got status %d liking while signed out", resp.Code)
+	}
+	expectRedirect(t, ada.post("/api/block", url.Values{"username": {"ben"}}), http.StatusFound, "/ben")
+	expectStatus(t, ben.post("/api/like", url.Values{"tweet_id": {tweetId}}), http.StatusForbidden)
+	expectStatus(t, ben.post("/api/follow", url.Values{"username": {"ada"}}), http.StatusForbidden)
+	expectStatus(t, ben.post("/api/tweet", url.Values{"tweet": {"Reply"}, "parent": {tweetId}}), http.StatusForbidden)
+}
+
+func TestIntegrationMessages(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	ada, adaId := server.signUp("ada")
+	ben, benId := server.signUp("ben")
+	cat, _ := server.signUp("cat")
+
+	resp := ada.get(fmt.Sprintf("/messages/%d-%d", adaId, benId))
+	expectStatus(t, resp, http.StatusMovedPermanently)
+	if !strings.HasPrefix(resp.Location, "/messages/") {
+		t.Fatalf("Redirected to %q", resp.Location)
+	}
+	conversation := resp.Location
+
+	expectRedirect(t, ada.post("/api" + conversation, url.Values{"message": {"Hi Ben"}}), http.StatusMovedPermanently, conversation)
+	page := ben.get(conversation)
+	expectStatus(t, page, http.StatusOK)
+	if !strings.Contains(page.Body, "Hi Ben") {
+		t.Error("Message is missing from the conversation")
+	}
+	inbox := ben.get("/messages")
+	expectStatus(t, inbox, http.StatusOK)
+	if !strings.Contains(inbox.Body, conversation) {
+		t.Error("Conversation is missing from the inbox")
+	}
+
+	// Only members can write to a conversation.
+	if resp := cat.post("/api" + conversation, url.Values{"message": {"Intruder"}}); resp.Code < 400 {
+		t.Errorf("Got status %d writing to someone else's conversation", resp.Code)
+	}
+	if resp := server.client().post("/api" + conversation, url.Values{"message": {"Anonymous"}}); resp.Code < 400 {
+		t.Errorf("Got status %d writing while signed out", resp.Code)
+	}
+	page = ben.get(conversation)
+	if strings.Contains(page.Body, "Intruder") || strings.Contains(page.Body, "Anonymous") {
+		t.Error("Outsider's message was stored")
+	}
+}
+
+func TestIntegrationTimelineFanOut(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	timeline.SetStore(timeline.NewMemoryStore())
+	defer timeline.SetStore(nil)
+	ada, adaId := server.signUp("ada")
+	_, benId := server.signUp("ben")
+	expectRedirect(t, ada.post("/api/follow", url.Values{"username": {"ben"}}), http.StatusFound, "/ben")
+
+	// Tweets saved outside the handlers, as the seed command does, still
+	// reach followers.
+	tweetId, err := model.CreateTweet(context.Background(), model.TweetRequest{UserId: benId, Text: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		tweets, err := timeline.GetHomeFeed(context.Background(), adaId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tweets) == 1 && tweets[0].Id == tweetId {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("Tweet was not fanned out")
+}
+
+func TestIntegrationAdmin(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	ada, adaId := server.signUp("ada")
+	_, benId := server.signUp("ben")
+
+	roleForm := url.Values{"role": {model.ROLE_MODERATOR}}
+	expectRedirect(t, server.client().get("/admin"), http.StatusMovedPermanently, "/login")
+	expectStatus(t, server.client().post("/api/admin/users/ben/role", roleForm), http.StatusUnauthorized)
+	expectStatus(t, ada.get("/admin"), http.StatusNotFound)
+	expectStatus(t, ada.post("/api/admin/users/ben/role", roleForm), http.StatusForbidden)
+
+	err := model.SetRole(context.Background(), 0, adaId, model.ROLE_ADMIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, ada.get("/admin"), http.StatusOK)
+	expectStatus(t, ada.post("/api/admin/users/ben/role", roleForm), http.StatusFound)
+	role, err := model.GetRole(context.Background(), benId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != model.ROLE_MODERATOR {
+		t.Errorf("Got role %q", role)
+	}
+}
+
+func TestIntegrationReportFromDeletedAccount(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	ada, adaId := server.signUp("ada")
+	_, benId := server.signUp("ben")
+	_, cyId := server.signUp("cy")
+
+	ctx := context.Background()
+	err := model.SetRole(ctx, 0, adaId, model.ROLE_MODERATOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportId, err := model.CreateReport(ctx, benId, model.REPORT_USER, cyId, "spam", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.DeleteUser(ctx, benId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The report outlives its reporter and can still be closed.
+	path := fmt.Sprintf("/api/moderation/reports/%d", reportId)
+	expectStatus(t, ada.post(path, url.Values{"action": {model.ACTION_DISMISS}}), http.StatusFound)
+}
+
+func TestIntegrationSuspension(t *testing.T) {
+	server, stop := startServer(t)
+	defer stop()
+	ada, adaId := server.signUp("ada")
+	ben, benId := server.signUp("ben")
+	cy, cyId := server.signUp("cy")
+	expectRedirect(t, cy.post("/api/follow", url.Values{"username": {"ben"}}), http.StatusFound, "/ben")
+
+	err := model.SetRole(context.Background(), 0, adaId, model.ROLE_ADMIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.SetRole(context.Background(), 0, benId, model.ROLE_ADMIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Admins can't suspend each other.
+	expectStatus(t, ada.post("/api/admin/users/ben/suspend", url.Values{"reason": {"spam"}}), http.StatusForbidden)
+
+	err = model.SetRole(context.Background(), 0, benId, model.ROLE_USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, ada.post("/api/admin/users/ben/suspend", url.Values{"reason": {"spam"}}), http.StatusFound)
+
+	// Ben's session still exists, but his next request, even a read, is
+	// refused and ends it.
+	expectStatus(t, ben.get("/notifications"), http.StatusForbidden)
+	expectRedirect(t, ben.get("/notifications"), http.StatusMovedPermanently, "/login")
+
+	// Cy's following count leaves ben out while he is away.
+	relationship, err := model.GetUsersRelationship(context.Background(), cyId, adaId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relationship.Follows != 0 {
+		t.Errorf("Got %d follows", relationship.Follows)
+	}
+}
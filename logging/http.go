@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"time"
+	"regexp"
+	"strings"
+	"net/http"
+	uuid "github.com/gofrs/uuid"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// validRequestID accepts IDs from a proxy in front of the server. Anything
+// else is replaced so clients can't inject text into the logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// secretPaths end in a one-time token that must not reach the access log.
+var secretPaths = []string{"/reset/", "/verify/", "/api/reset/"}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+// Middleware gives each request an ID, sent back in the X-Request-ID header
+// and carried by the request's context, and writes an access log line once
+// the response is done.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID.MatchString(id) {
+			id = uuid.Must(uuid.NewV4()).String()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		r = r.WithContext(WithRequestID(r.Context(), id))
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		level := INFO
+		if sw.status >= 500 {
+			level = ERROR
+		}
+		Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", RedactPath(r.URL.Path),
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"duration_ms", float64(time.Since(start)) / float64(time.Millisecond),
+		)
+	})
+}
+
+// RedactPath hides the token in password reset and email verification links.
+func RedactPath(path string) string {
+	for _, prefix := range secretPaths {
+		if strings.HasPrefix(path, prefix) && len(path) > len(prefix) {
+			return prefix + REDACTED
+		}
+	}
+	return path
+}
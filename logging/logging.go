@@ -0,0 +1,259 @@
+// Package logging writes leveled, structured log lines. Lines are JSON when
+// LOG_FORMAT is "json", as in the Docker image, and key=value text otherwise.
+// Lines logged with a request's context carry its request ID.
+package logging
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"bytes"
+	"context"
+	"strings"
+	"strconv"
+	"encoding/json"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DEBUG || l > ERROR {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads a level name such as "warn", returning false for
+// unknown names.
+func ParseLevel(name string) (Level, bool) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), true
+		}
+	}
+	return INFO, false
+}
+
+// REDACTED replaces the values of sensitive keys.
+const REDACTED = "[REDACTED]"
+
+// Values of keys containing a credential word, or ending in a word for
+// private text such as "message" or "tweet_text", are never written, so they
+// can't leak into logs even if a caller passes them.
+var credentialWords = []string{"password", "token", "secret", "cookie", "authorization", "session"}
+var contentWords = []string{"message", "text", "body", "tweet"}
+
+var (
+	mu sync.Mutex
+	output io.Writer = os.Stderr
+	jsonFormat = false
+	minLevel = INFO
+)
+
+// Init configures the format and level from LOG_FORMAT and LOG_LEVEL, and
+// routes the standard log package through the structured logger.
+func Init() {
+	SetFormat(os.Getenv("LOG_FORMAT") == "json")
+	if level, ok := ParseLevel(os.Getenv("LOG_LEVEL")); ok {
+		SetLevel(level)
+	}
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+}
+
+func SetFormat(json bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	jsonFormat = json
+}
+
+func SetLevel(level Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	minLevel = level
+}
+
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// WithRequestID returns a copy of ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID stored by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// detached keeps a context's values, such as its request ID and trace, but
+// never ends.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+// Detach returns a context for background work started by a request. It
+// keeps the request ID but is not cancelled when the request ends.
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+func Debug(ctx context.Context, msg string, keyvals ...interface{}) {
+	Log(ctx, DEBUG, msg, keyvals...)
+}
+
+func Info(ctx context.Context, msg string, keyvals ...interface{}) {
+	Log(ctx, INFO, msg, keyvals...)
+}
+
+func Warn(ctx context.Context, msg string, keyvals ...interface{}) {
+	Log(ctx, WARN, msg, keyvals...)
+}
+
+func Error(ctx context.Context, msg string, keyvals ...interface{}) {
+	Log(ctx, ERROR, msg, keyvals...)
+}
+
+// Log writes msg with keyvals, which alternate between string keys and
+// values. Values of sensitive keys are replaced with REDACTED.
+func Log(ctx context.Context, level Level, msg string, keyvals ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if level < minLevel {
+		return
+	}
+
+	fields := []interface{}{"time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg}
+	if id := RequestID(ctx); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+	if len(keyvals) % 2 == 1 {
+		keyvals = append(keyvals, "")
+	}
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		value := keyvals[i + 1]
+		if sensitive(key) {
+			value = REDACTED
+		}
+		fields = append(fields, key, value)
+	}
+
+	var line []byte
+	if jsonFormat {
+		line = formatJSON(fields)
+	} else {
+		line = formatText(fields)
+	}
+	output.Write(line)
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range credentialWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	for _, word := range contentWords {
+		if key == word || strings.HasSuffix(key, "_" + word) {
+			return true
+		}
+	}
+	return false
+}
+
+// plain turns errors and Stringers into strings so that both formats show
+// what %v would.
+func plain(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+func formatJSON(fields []interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fields[i])
+		value, err := json.Marshal(plain(fields[i + 1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(fields[i + 1]))
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func formatText(fields []interface{}) []byte {
+	var buf bytes.Buffer
+	// Time, level and message lead without keys to keep lines readable.
+	fmt.Fprintf(&buf, "%s %-5s %s", fields[1], strings.ToUpper(fmt.Sprint(fields[3])), fields[5])
+	for i := 6; i < len(fields); i += 2 {
+		value := fmt.Sprint(plain(fields[i + 1]))
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&buf, " %s=%s", fields[i], value)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// stdWriter turns lines from the standard log package, such as the model's
+// query errors, into structured lines. Those that report a failure are
+// errors.
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	msg := strings.Join(strings.Fields(string(p)), " ")
+	level := INFO
+	lower := strings.ToLower(msg)
+	if strings.Contains(lower, "error") || strings.HasPrefix(lower, "could not") {
+		level = ERROR
+	}
+	Log(context.Background(), level, msg)
+	return len(p), nil
+}
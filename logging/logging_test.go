@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"context"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+)
+
+// capture sends log lines to a buffer in the given format until the returned
+// function is called.
+func capture(json bool) (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	previous := output
+	SetOutput(&buf)
+	SetFormat(json)
+	SetLevel(DEBUG)
+	return &buf, func() {
+		SetOutput(previous)
+		SetFormat(false)
+		SetLevel(INFO)
+	}
+}
+
+func decode(t *testing.T, line string) map[string]interface{} {
+	t.Helper()
+	var fields map[string]interface{}
+	err := json.Unmarshal([]byte(line), &fields)
+	if err != nil {
+		t.Fatalf("Could not decode %q: %v", line, err)
+	}
+	return fields
+}
+
+func TestJSON(t *testing.T) {
+	buf, restore := capture(true)
+	defer restore()
+
+	ctx := WithRequestID(context.Background(), "abc")
+	Error(ctx, "Could not get user.", "err", errors.New("no rows"), "user_id", 7)
+	fields := decode(t, buf.String())
+	want := map[string]interface{}{"level": "error", "msg": "Could not get user.", "request_id": "abc", "err": "no rows", "user_id": float64(7)}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("Got %s = %v, want %v", key, fields[key], value)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	buf, restore := capture(false)
+	defer restore()
+
+	Warn(context.Background(), "Invalid tweet ID.", "err", errors.New("bad input"), "id", "x")
+	line := buf.String()
+	if !strings.Contains(line, `WARN  Invalid tweet ID. err="bad input" id=x`) {
+		t.Errorf("Got %q", line)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	buf, restore := capture(false)
+	defer restore()
+
+	SetLevel(WARN)
+	Info(context.Background(), "hidden")
+	if buf.Len() != 0 {
+		t.Errorf("Got %q below the minimum level", buf.String())
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	buf, restore := capture(true)
+	defer restore()
+
+	Info(context.Background(), "login", "username", "ada", "password", "hunter2", "reset_token", "t0k3n", "message", "hi", "message_id", 3)
+	fields := decode(t, buf.String())
+	for _, key := range []string{"password", "reset_token", "message"} {
+		if fields[key] != REDACTED {
+			t.Errorf("Got %s = %v, want it redacted", key, fields[key])
+		}
+	}
+	if fields["username"] != "ada" || fields["message_id"] != float64(3) {
+		t.Errorf("Got %v", fields)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf, restore := capture(true)
+	defer restore()
+
+	var logged string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logged = RequestID(r.Context())
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/reset/secret-token?x=1", nil))
+	id := w.Header().Get(REQUEST_ID_HEADER)
+	if id == "" || id != logged {
+		t.Fatalf("Got response ID %q, handler ID %q", id, logged)
+	}
+	fields := decode(t, buf.String())
+	if fields["request_id"] != id || fields["status"] != float64(http.StatusNotFound) || fields["path"] != "/reset/" + REDACTED {
+		t.Errorf("Got access log %v", fields)
+	}
+
+	// A proxy's ID is kept, anything else is replaced.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(REQUEST_ID_HEADER, "from-proxy.1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Get(REQUEST_ID_HEADER); got != "from-proxy.1" {
+		t.Errorf("Got ID %q", got)
+	}
+	r.Header.Set(REQUEST_ID_HEADER, "bad id\nlevel=error")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Get(REQUEST_ID_HEADER); !validRequestID.MatchString(got) || strings.Contains(got, "bad") {
+		t.Errorf("Got ID %q", got)
+	}
+}
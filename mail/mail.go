@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+type Message struct {
+	To string
+	Subject string
+	Body string
+}
+
+// Mailer delivers outgoing account emails such as verification links and
+// password resets.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// SMTPMailer sends messages through an SMTP relay using PLAIN auth when a
+// username is configured.
+type SMTPMailer struct {
+	Host string
+	Port string
+	Username string
+	Password string
+	From string
+}
+
+// FileMailer writes each message to its own file in Dir, or to the log when
+// Dir is empty. It is meant for local development and tests.
+type FileMailer struct {
+	Dir string
+}
+
+var Sender Mailer = FileMailer{}
+
+func Init() {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		Sender = FileMailer{Dir: os.Getenv("MAIL_DIR")}
+		return
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	Sender = SMTPMailer{
+		Host: host,
+		Port: port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From: os.Getenv("MAIL_FROM"),
+	}
+}
+
+func Send(message Message) error {
+	return Sender.Send(message)
+}
+
+func format(from string, message Message) []byte {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.Replace(message.Body, "\n", "\r\n", -1))
+	return []byte(b.String())
+}
+
+func (m SMTPMailer) Send(message Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	err := smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{message.To}, format(m.From, message))
+	if err != nil {
+		log.Println("Could not send mail.\n", err)
+		return err
+	}
+	return nil
+}
+
+func (m FileMailer) Send(message Message) error {
+	if m.Dir == "" {
+		// Bodies hold reset and verification links, so they are only shown
+		// at debug level.
+		ctx := context.Background()
+		logging.Info(ctx, "Mail not delivered; set MAIL_DIR or SMTP_HOST to keep it.", "to", message.To, "subject", message.Subject)
+		logging.Debug(ctx, "Mail contents.", "to", message.To, "contents", message.Body)
+		return nil
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	err := ioutil.WriteFile(filepath.Join(m.Dir, name), format("", message), 0644)
+	if err != nil {
+		log.Println("Could not write mail.\n", err)
+		return err
+	}
+	return nil
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"html/template"
 	"log"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 	"database/sql"
 	mux "github.com/gorilla/mux"
 	sessions "github.com/gorilla/sessions"
 	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	metrics "github.com/dustinnewman98/twitter_clone/metrics"
+	tracing "github.com/dustinnewman98/twitter_clone/tracing"
 	api "github.com/dustinnewman98/twitter_clone/api"
 	session "github.com/dustinnewman98/twitter_clone/session"
+	mail "github.com/dustinnewman98/twitter_clone/mail"
+	ratelimit "github.com/dustinnewman98/twitter_clone/ratelimit"
+	recommend "github.com/dustinnewman98/twitter_clone/recommend"
+	timeline "github.com/dustinnewman98/twitter_clone/timeline"
 )
 
 type LoginCreds struct {
@@ -22,10 +32,21 @@ type LoginCreds struct {
 
 type LoginPage struct {
 	PasswordFail bool
+	UsernameInvalid bool
+	// RenamedTo is the current username of an account that gave up the one
+	// entered.
+	RenamedTo string
+	LockedOut bool
+	// Account is "deactivated" or "deleted" after leaving the service.
+	Account string
+	Suspended bool
+	SuspensionReason string
+	SuspendedUntil string
 }
 
 type IndexPage struct {
 	Tweets []model.Tweet
+	Ranked bool
 	CurrentUsername string
 	CurrentUserId int64
 	Title string
@@ -34,12 +55,34 @@ type IndexPage struct {
 type UserPage struct {
 	Username string
 	UserId int64
+	Protected bool
+	PinnedTweet model.Tweet
 	Tweets []model.Tweet
 	CrossUsers model.CrossUsers
+	MutualFollowers []model.User
+	MutualFollowersCount int64
 	Bio string
 	Website string
+	WebsiteVerified bool
 	Location string
 	DisplayName string
+	AvatarURL string
+	BannerURL string
+	Joined string
+	Birthday string
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type FollowListPage struct {
+	Username string
+	DisplayName string
+	Followers bool
+	Users []model.UserListItem
+	Hidden bool
+	PrevPage int
+	NextPage int
 	CurrentUsername string
 	CurrentUserId int64
 	Title string
@@ -53,11 +96,113 @@ type TweetPage struct {
 	Title string
 }
 
+type AccountSettingsPage struct {
+	Export model.DataExport
+	HasExport bool
+	ExportExpired bool
+	Error string
+	Saved string
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
 type UserEditPage struct {
 	DisplayName string
 	Bio string
 	Website string
+	WebsiteVerified bool
+	ProfileURL string
 	Location string
+	Birthday string
+	Email string
+	EmailVerified bool
+	Protected bool
+	AvatarURL string
+	BannerURL string
+	Error string
+	Saved string
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ForgotPasswordPage struct {
+	Sent bool
+}
+
+type ResetPasswordPage struct {
+	Token string
+	Valid bool
+	Error string
+}
+
+type ManageUsersPage struct {
+	Users []model.User
+	Action string
+	ActionLabel string
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type FollowRequestsPage struct {
+	Users []model.User
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type BookmarksPage struct {
+	Tweets []model.Tweet
+	Query string
+	PrevPage int
+	NextPage int
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ListsPage struct {
+	Username string
+	Lists []model.List
+	Subscribed []model.List
+	Memberships bool
+	Error string
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ListPage struct {
+	List model.List
+	Tweets []model.Tweet
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ListMembersPage struct {
+	List model.List
+	Users []model.UserListItem
+	PrevPage int
+	NextPage int
+	Error string
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ListEditPage struct {
+	List model.List
+	Error string
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type WelcomePage struct {
+	Suggestions []model.FollowCandidate
 	CurrentUsername string
 	CurrentUserId int64
 	Title string
@@ -80,6 +225,56 @@ type MessagePage struct {
 
 type NotificationsPage struct {
 	Notifications []model.Notification
+	FollowRequests int64
+	Reports []model.Report
+	Warnings []model.ModerationAction
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ReportPage struct {
+	Kind string
+	TweetId int64
+	MessageId int64
+	Username string
+	Content string
+	Categories []string
+	Sent bool
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ModerationPage struct {
+	Reports []model.Report
+	Resolved string
+	PrevPage int
+	NextPage int
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type ModerationLogPage struct {
+	Actions []model.ModerationAction
+	PrevPage int
+	NextPage int
+	CurrentUsername string
+	CurrentUserId int64
+	Title string
+}
+
+type AdminPage struct {
+	Stats model.Stats
+	Daily []model.DailyCount
+	Reports []model.Report
+	Actions []model.ModerationAction
+	Username string
+	User model.User
+	Found bool
+	Roles []string
+	Saved string
 	CurrentUsername string
 	CurrentUserId int64
 	Title string
@@ -87,9 +282,47 @@ type NotificationsPage struct {
 
 const (
 	LOGIN_COOKIE_NAME = "login"
+	TIMELINE_RANKED = "for_you"
+	TIMELINE_FOLLOWING = "following"
+	MUTUAL_FOLLOWERS_SHOWN = 3
+	// Resolved reports and warnings stay in notifications this long.
+	MODERATION_NOTICE_PERIOD = 30 * 24 * time.Hour
+	ADMIN_DAYS_SHOWN = 14
+	ADMIN_ITEMS_SHOWN = 5
 )
 
-var templates = template.Must(template.ParseGlob("templates/*.html"))
+// templateFuncs binds the functions templates call to ctx, so their queries
+// are part of the request's trace and logs.
+func templateFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"whoToFollow": func(userId int64) []model.FollowCandidate {
+			return recommend.Sidebar(ctx, userId)
+		},
+		"avatar": api.AvatarURL,
+		"banner": api.BannerURL,
+		"hasRole": func(userId int64, role string) bool {
+			return hasRole(ctx, userId, role)
+		},
+	}
+}
+
+// templates is never executed itself: html/template can't be cloned once it
+// has run, and executeTemplate renders a clone per request.
+var templates = template.Must(template.New("").Funcs(templateFuncs(context.Background())).ParseGlob("templates/*.html"))
+
+// executeTemplate renders the named template as part of r's trace.
+func executeTemplate(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	ctx, span := tracing.Start(r.Context(), "template " + name, tracing.INTERNAL, "template.name", name)
+	defer span.End()
+	t, err := templates.Clone()
+	if err != nil {
+		span.SetError(err)
+		logging.Error(ctx, "Could not clone templates.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	span.SetError(t.Funcs(templateFuncs(ctx)).ExecuteTemplate(w, name, data))
+}
 
 func stringToNullString(maybeString string) sql.NullString {
 	nullString := sql.NullString{String: "", Valid: false}
@@ -99,6 +332,11 @@ func stringToNullString(maybeString string) sql.NullString {
 	return nullString
 }
 
+func hasRole(ctx context.Context, userId int64, role string) bool {
+	allowed, err := model.HasRole(ctx, userId, role)
+	return err == nil && allowed
+}
+
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		// Check if user is authenticated
@@ -109,32 +347,92 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		templates.ExecuteTemplate(w, "login.html", nil)
+		data := LoginPage{
+			Account: r.URL.Query().Get("account"),
+		}
+		executeTemplate(w, r, "login.html", data)
 	} else {
 		login := LoginCreds{
 			Username: r.FormValue("username"),
 			Password: r.FormValue("password"),
 		}
-		fmt.Println(login)
-		user, err := model.GetUserFromUsername(login.Username)
+
+		if wait := ratelimit.LoginLockedFor(r.Context(), login.Username); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+			w.WriteHeader(http.StatusTooManyRequests)
+			data := LoginPage{
+				LockedOut: true,
+			}
+			executeTemplate(w, r, "login.html", data)
+			return
+		}
+
+		user, err := api.Data.GetUserFromUsername(r.Context(), login.Username)
 		var uid int64
+		home := "/"
 		if err != nil {
 			// New user
-			uid, err = model.CreateUser(login.Username, login.Password)
+			if !api.ValidUsername(login.Username) {
+				data := LoginPage{
+					UsernameInvalid: true,
+				}
+				executeTemplate(w, r, "login.html", data)
+				return
+			}
+			// Signing in with a retired username must not quietly create a
+			// second account under it.
+			newUsername, err := model.GetRenamedUsername(r.Context(), login.Username)
+			if err == nil {
+				data := LoginPage{
+					RenamedTo: newUsername,
+				}
+				executeTemplate(w, r, "login.html", data)
+				return
+			}
+			uid, err = api.Data.CreateUser(r.Context(), login.Username, login.Password)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			home = "/welcome"
 		} else {
 			// Existing user
 			// Check password
 			if login.Password != user.Password {
+				ratelimit.LoginFailed(r.Context(), login.Username)
 				data := LoginPage{
 					PasswordFail: true,
 				}
-				templates.ExecuteTemplate(w, "login.html", data)
+				executeTemplate(w, r, "login.html", data)
+				return
 			} else {
+				ratelimit.LoginSucceeded(r.Context(), login.Username)
 				uid = user.Id
+				if user.Suspended {
+					w.WriteHeader(http.StatusForbidden)
+					data := LoginPage{
+						Suspended: true,
+						SuspensionReason: user.SuspensionReason,
+						SuspendedUntil: user.SuspendedUntil,
+					}
+					executeTemplate(w, r, "login.html", data)
+					return
+				}
+				if user.Deactivated {
+					err = model.ReactivateUser(r.Context(), uid)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
 			}
 		}
 
-		log.Println("User id: ", uid, "user.Id: ", user.Id)
+		sessionVersion, err := api.Data.GetSessionVersion(r.Context(), uid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		session, err := session.Store.Get(r, LOGIN_COOKIE_NAME)
 		if err != nil {
@@ -144,6 +442,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 		session.Values["uid"] = uid
 		session.Values["username"] = login.Username
+		session.Values["session_version"] = sessionVersion
 		session.Options = &sessions.Options{
 			Path:     "/",
 			HttpOnly: true,
@@ -155,7 +454,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		http.Redirect(w, r, home, http.StatusMovedPermanently)
 		return
 	}
 }
@@ -183,23 +482,66 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
         return
 	}
 	username, _ := session.Values["username"]
-	
-	tweets, err := model.GetFeed(uid.(int64))
+
+	// Remember which timeline the user picked last.
+	if mode := r.URL.Query().Get("timeline"); mode == TIMELINE_RANKED || mode == TIMELINE_FOLLOWING {
+		session.Values["timeline"] = mode
+		err := session.Save(r, w)
+		if err != nil {
+			logging.Error(r.Context(), "Could not save timeline preference.", "err", err)
+		}
+	}
+	ranked := session.Values["timeline"] == TIMELINE_RANKED
+
+	var tweets []model.Tweet
+	var err error
+	if ranked {
+		tweets, err = timeline.GetRankedFeed(r.Context(), uid.(int64), timeline.DefaultRanker)
+	} else {
+		tweets, err = timeline.GetHomeFeed(r.Context(), uid.(int64))
+	}
 	if err != nil {
-		log.Println("Could not get feed.\n", err)
+		logging.Error(r.Context(), "Could not get feed.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	data := IndexPage{
 		Tweets: tweets,
+		Ranked: ranked,
 		CurrentUsername: username.(string),
 		CurrentUserId: uid.(int64),
 		Title: "Home",
 	}
-	fmt.Println(data)
+	executeTemplate(w, r, "index.html", data)
+}
+
+func WelcomeHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	suggestions, err := recommend.WhoToFollow(r.Context(), currentUid, recommend.ONBOARDING_SIZE)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get suggestions.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	templates.ExecuteTemplate(w, "index.html", data)
+	data := WelcomePage{
+		Suggestions: suggestions,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Welcome",
+	}
+
+	executeTemplate(w, r, "welcome.html", data)
 }
 
 func TweetHandler(w http.ResponseWriter, r *http.Request) {
@@ -216,20 +558,24 @@ func TweetHandler(w http.ResponseWriter, r *http.Request) {
 	// Render tweet.html with tweet replies
 	tweetId, err := strconv.ParseInt(mux.Vars(r)["tweet_id"], 10, 64)
 	if err != nil {
-		log.Println("Invalid tweet ID: ", err)
+		logging.Warn(r.Context(), "Invalid tweet ID.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	tweet, err := model.GetTweet(tweetId, uid.(int64))
+	tweet, err := api.Data.GetTweet(r.Context(), tweetId, uid.(int64))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		log.Println("Could not get tweet.\n", err)
+		logging.Error(r.Context(), "Could not get tweet.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	replies, err := model.GetReplies(tweetId, uid.(int64))
+	replies, err := api.Data.GetReplies(r.Context(), tweetId, uid.(int64))
 	if err != nil {
-		log.Println("Could not get replies.")
+		logging.Error(r.Context(), "Could not get replies.", "err", err)
 	}
 
 	title := fmt.Sprintf("%v on Gwitter: %q", tweet.Username, tweet.Text)
@@ -240,17 +586,58 @@ func TweetHandler(w http.ResponseWriter, r *http.Request) {
 		CurrentUserId: uid.(int64),
 		Title: title,
 	}
-	templates.ExecuteTemplate(w, "tweet.html", data)
+	executeTemplate(w, r, "tweet.html", data)
+}
+
+// joinedDate formats users.created_at for a profile, e.g. "March 2020".
+func joinedDate(createdAt string) string {
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return ""
+	}
+	return t.Format("January 2006")
+}
+
+// birthdayDate formats a YYYY-MM-DD birthday for a profile.
+func birthdayDate(birthday string) string {
+	t, err := time.Parse("2006-01-02", birthday)
+	if err != nil {
+		return ""
+	}
+	return t.Format("January 2, 2006")
+}
+
+// redirectRenamed sends a request for a username that has since changed to
+// the same page under the new one, returning false if there is no such
+// redirect.
+func redirectRenamed(w http.ResponseWriter, r *http.Request, username string) bool {
+	newUsername, err := model.GetRenamedUsername(r.Context(), username)
+	if err != nil {
+		return false
+	}
+	url := "/" + newUsername + strings.TrimPrefix(r.URL.Path, "/" + username)
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, url, http.StatusMovedPermanently)
+	return true
 }
 
 func UserHandler(w http.ResponseWriter, r *http.Request) {
 	username := mux.Vars(r)["username"]
-	user, err := model.GetUserFromUsername(username)
+	user, err := api.Data.GetUserFromUsername(r.Context(), username)
+	if err == sql.ErrNoRows && redirectRenamed(w, r, username) {
+		return
+	}
 	if err != nil {
-		log.Println("Could not get user ID.\n", err)
+		logging.Error(r.Context(), "Could not get user ID.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if user.Deactivated || user.Suspended {
+		http.NotFound(w, r)
+		return
+	}
 
 	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
 
@@ -262,20 +649,34 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	currentUsername, _ := session.Values["username"].(string)
 
-	tweets, err := model.GetHistory(user.Id, currentUid)
+	tweets, err := api.Data.GetHistory(r.Context(), user.Id, currentUid)
 	if err != nil {
-		log.Println("Could not get tweets.\n", err)
+		logging.Error(r.Context(), "Could not get tweets.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	crossUsers, err := model.GetUsersRelationship(user.Id, currentUid)
+	// A pinned Tweet the viewer cannot see is left out like any other.
+	var pinnedTweet model.Tweet
+	if user.PinnedTweetId != 0 {
+		pinnedTweet, err = api.Data.GetTweet(r.Context(), user.PinnedTweetId, currentUid)
+		if err != nil && err != sql.ErrNoRows {
+			logging.Error(r.Context(), "Could not get pinned tweet.", "err", err)
+		}
+	}
+
+	crossUsers, err := api.Data.GetUsersRelationship(r.Context(), user.Id, currentUid)
 	if err != nil {
-		log.Println("Could not get user relationship.\n", err)
+		logging.Error(r.Context(), "Could not get user relationship.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	mutualFollowers, mutualFollowersCount, err := api.Data.GetMutualFollowers(r.Context(), user.Id, currentUid, MUTUAL_FOLLOWERS_SHOWN)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get mutual followers.", "err", err)
+	}
+
 	title := username
 	if user.DisplayName != "" {
 		title = fmt.Sprintf("%s (@%s)", user.DisplayName, username)
@@ -284,28 +685,44 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 	data := UserPage{
 		Username: username,
 		UserId: user.Id,
+		Protected: user.Protected,
+		PinnedTweet: pinnedTweet,
 		Tweets: tweets,
 		CrossUsers: crossUsers,
+		MutualFollowers: mutualFollowers,
+		MutualFollowersCount: mutualFollowersCount,
 		Bio: user.Bio,
 		DisplayName: user.DisplayName,
 		Location: user.Location,
 		Website: user.Website,
+		WebsiteVerified: user.WebsiteVerified,
+		AvatarURL: user.AvatarURL,
+		BannerURL: user.BannerURL,
+		Joined: joinedDate(user.CreatedAt),
+		Birthday: birthdayDate(user.Birthday),
 		CurrentUsername: currentUsername,
 		CurrentUserId: currentUid,
 		Title: title,
 	}
 
-	templates.ExecuteTemplate(w, "user_tweets.html", data)
+	executeTemplate(w, r, "user_tweets.html", data)
 }
 
 func UserLikesHandler(w http.ResponseWriter, r *http.Request) {
 	username := mux.Vars(r)["username"]
-	user, err := model.GetUserFromUsername(username)
+	user, err := api.Data.GetUserFromUsername(r.Context(), username)
+	if err == sql.ErrNoRows && redirectRenamed(w, r, username) {
+		return
+	}
 	if err != nil {
-		log.Println("Could not get user ID.\n", err)
+		logging.Error(r.Context(), "Could not get user ID.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if user.Deactivated || user.Suspended {
+		http.NotFound(w, r)
+		return
+	}
 
 	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
 
@@ -317,20 +734,25 @@ func UserLikesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	currentUsername, _ := session.Values["username"]
 
-	tweets, err := model.GetLikes(user.Id, currentUid.(int64))
+	tweets, err := api.Data.GetLikes(r.Context(), user.Id, currentUid.(int64))
 	if err != nil {
-		log.Println("Could not get tweets.\n", err)
+		logging.Error(r.Context(), "Could not get tweets.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	crossUsers, err := model.GetUsersRelationship(user.Id, currentUid.(int64))
+	crossUsers, err := api.Data.GetUsersRelationship(r.Context(), user.Id, currentUid.(int64))
 	if err != nil {
-		log.Println("Could not get user relationship.\n", err)
+		logging.Error(r.Context(), "Could not get user relationship.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	mutualFollowers, mutualFollowersCount, err := api.Data.GetMutualFollowers(r.Context(), user.Id, currentUid.(int64), MUTUAL_FOLLOWERS_SHOWN)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get mutual followers.", "err", err)
+	}
+
 	title := username
 	if user.DisplayName != "" {
 		title = fmt.Sprintf("%s (@%s)", user.DisplayName, username)
@@ -339,41 +761,114 @@ func UserLikesHandler(w http.ResponseWriter, r *http.Request) {
 	data := UserPage{
 		Username: username,
 		UserId: user.Id,
+		Protected: user.Protected,
 		Tweets: tweets,
 		CrossUsers: crossUsers,
+		MutualFollowers: mutualFollowers,
+		MutualFollowersCount: mutualFollowersCount,
 		Bio: user.Bio,
 		DisplayName: user.DisplayName,
 		Location: user.Location,
 		Website: user.Website,
+		WebsiteVerified: user.WebsiteVerified,
+		AvatarURL: user.AvatarURL,
+		BannerURL: user.BannerURL,
+		Joined: joinedDate(user.CreatedAt),
+		Birthday: birthdayDate(user.Birthday),
 		CurrentUsername: currentUsername.(string),
 		CurrentUserId: currentUid.(int64),
 		Title: title,
 	}
 
-	templates.ExecuteTemplate(w, "user_likes.html", data)
+	executeTemplate(w, r, "user_likes.html", data)
+}
+
+func followListHandler(followers bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+		// Check if user is authenticated
+		currentUid, ok := session.Values["uid"].(int64)
+		if ok == false {
+			http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+			return
+		}
+		currentUsername, _ := session.Values["username"].(string)
+
+		username := mux.Vars(r)["username"]
+		page := api.ParsePage(r)
+		user, visible, users, hasMore, err := api.GetConnections(r.Context(), username, currentUid, followers, page)
+		if err == sql.ErrNoRows && redirectRenamed(w, r, username) {
+			return
+		}
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logging.Error(r.Context(), "Could not get connections.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		name := username
+		if user.DisplayName != "" {
+			name = user.DisplayName
+		}
+		title := fmt.Sprintf("People following %s (@%s)", name, username)
+		if !followers {
+			title = fmt.Sprintf("People followed by %s (@%s)", name, username)
+		}
+
+		data := FollowListPage{
+			Username: username,
+			DisplayName: user.DisplayName,
+			Followers: followers,
+			Users: users,
+			Hidden: !visible,
+			PrevPage: page - 1,
+			CurrentUsername: currentUsername,
+			CurrentUserId: currentUid,
+			Title: title,
+		}
+
+		if hasMore {
+			data.NextPage = page + 1
+		}
+
+		executeTemplate(w, r, "follow_list.html", data)
+	}
+}
+
+var UserFollowersHandler = followListHandler(true)
+var UserFollowingHandler = followListHandler(false)
+
+// profileURL is username's public profile, which the edit page asks users to
+// link to from their website.
+func profileURL(username string) string {
+	site, _ := api.SiteURL()
+	return fmt.Sprintf("%s/%s", site, username)
 }
 
 func UserEditHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
 	// Check if user is authenticated
-	username, ok := session.Values["username"]
+	uid, ok := session.Values["uid"].(int64)
 	if ok == false {
-		log.Println("Not valid username")
 		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
 		return
 	}
-	fmt.Println(mux.Vars(r)["username"])
 
-	if mux.Vars(r)["username"] != username {
-		fmt.Printf("%s not equal to %s\n", mux.Vars(r)["username"], username)
-		http.Redirect(w, r, fmt.Sprintf("/%s", username), http.StatusMovedPermanently)
+	// Look the user up by ID: the session's username is stale if the
+	// account was renamed from another session.
+	user, err := api.Data.GetUser(r.Context(), uid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get user.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	user, err := model.GetUserFromUsername(username.(string))
-	if err != nil {
-		log.Println("Could not get user.\n", err)
-		http.Error(w, err.Error(), http.StatusMovedPermanently)
+	if mux.Vars(r)["username"] != user.Username {
+		http.Redirect(w, r, fmt.Sprintf("/%s", user.Username), http.StatusMovedPermanently)
 		return
 	}
 
@@ -382,44 +877,62 @@ func UserEditHandler(w http.ResponseWriter, r *http.Request) {
 		Bio: user.Bio,
 		Location: user.Location,
 		Website: user.Website,
+		WebsiteVerified: user.WebsiteVerified,
+		ProfileURL: profileURL(user.Username),
+		Birthday: user.Birthday,
+		Email: user.Email,
+		EmailVerified: user.EmailVerified,
+		Protected: user.Protected,
+		AvatarURL: user.AvatarURL,
+		BannerURL: user.BannerURL,
+		Error: r.URL.Query().Get("error"),
+		Saved: r.URL.Query().Get("saved"),
 		CurrentUsername: user.Username,
 		CurrentUserId: user.Id,
 		Title: "Edit your profile",
 	}
 
-	templates.ExecuteTemplate(w, "user_edit.html", data)
+	executeTemplate(w, r, "user_edit.html", data)
 }
 
-func MessagesHandler(w http.ResponseWriter, r *http.Request) {
+func AccountSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
 
 	// Check if user is authenticated
-	uid, ok := session.Values["uid"]
+	currentUid, ok := session.Values["uid"].(int64)
 	if ok == false {
 		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
 		return
 	}
-	username, _ := session.Values["username"]
-	
-	conversations, err := model.GetConversations(uid.(int64))
+	user, err := api.Data.GetUser(r.Context(), currentUid)
 	if err != nil {
-		log.Println("Could not get conversations.\n", err)
+		logging.Error(r.Context(), "Could not get user.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	data := MessagesPage{
-		Conversations: conversations,
-		CurrentUserId: uid.(int64),
-		CurrentUsername: username.(string),
-		Title: "Messages",
+	export, err := model.GetLatestDataExport(r.Context(), currentUid)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Error(r.Context(), "Could not get export.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	templates.ExecuteTemplate(w, "messages.html", data)
-	return
+	data := AccountSettingsPage{
+		Export: export,
+		HasExport: err == nil,
+		ExportExpired: time.Since(export.CreatedAt) > api.EXPORT_TTL,
+		Error: r.URL.Query().Get("error"),
+		Saved: r.URL.Query().Get("saved"),
+		CurrentUsername: user.Username,
+		CurrentUserId: currentUid,
+		Title: "Your account",
+	}
+
+	executeTemplate(w, r, "account_settings.html", data)
 }
 
-func DMHandler(w http.ResponseWriter, r *http.Request) {
+func BlockedUsersHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
 
 	// Check if user is authenticated
@@ -430,45 +943,477 @@ func DMHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	currentUsername, _ := session.Values["username"].(string)
 
-	otherUsername := mux.Vars(r)["user_b"]
-	if otherUsername == currentUsername {
-		otherUsername = mux.Vars(r)["user_a"]
+	users, err := api.Data.GetBlockedUsers(r.Context(), currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get blocked users.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if otherUsername == "" {
-		http.Redirect(w, r, "/", http.StatusMovedPermanently)
-		return
+	data := ManageUsersPage{
+		Users: users,
+		Action: "unblock",
+		ActionLabel: "Unblock",
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Blocked accounts",
 	}
 
-	otherUserId, err := strconv.ParseInt(otherUsername, 10, 64)
-	if err != nil {
-		log.Println("Invalid user ID ", otherUsername)
-		http.Redirect(w, r, "/", http.StatusMovedPermanently)
+	executeTemplate(w, r, "manage_users.html", data)
+}
+
+func MutedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
 		return
 	}
+	currentUsername, _ := session.Values["username"].(string)
 
-	conversationId, err := model.GetTwoUsersConversation(otherUserId, currentUid)
+	users, err := api.Data.GetMutedUsers(r.Context(), currentUid)
 	if err != nil {
-		log.Println("Could not get conversation.\n", err)
+		logging.Error(r.Context(), "Could not get muted users.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if conversationId == 0 {
-		conversationId, err = model.CreateTwoUsersConversation(otherUserId, currentUid)
-		if err != nil {
-			log.Println("Could not create conversation.\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	data := ManageUsersPage{
+		Users: users,
+		Action: "unmute",
+		ActionLabel: "Unmute",
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Muted accounts",
 	}
 
-	conversationURL := fmt.Sprintf("/messages/%d", conversationId)
-	http.Redirect(w, r, conversationURL, http.StatusMovedPermanently)
-	return
+	executeTemplate(w, r, "manage_users.html", data)
 }
 
-func ConversationHandler(w http.ResponseWriter, r *http.Request) {
+func FollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	users, err := api.Data.GetFollowRequests(r.Context(), currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get follow requests.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := FollowRequestsPage{
+		Users: users,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Follower requests",
+	}
+
+	executeTemplate(w, r, "follow_requests.html", data)
+}
+
+func BookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	page := api.ParsePage(r)
+	query := r.URL.Query().Get("q")
+	tweets, hasMore, err := api.GetBookmarks(r.Context(), currentUid, query, page)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get bookmarks.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := BookmarksPage{
+		Tweets: tweets,
+		Query: query,
+		PrevPage: page - 1,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Bookmarks",
+	}
+
+	if hasMore {
+		data.NextPage = page + 1
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	executeTemplate(w, r, "bookmarks.html", data)
+}
+
+func ListsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	lists, err := model.GetOwnedLists(r.Context(), currentUid, currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get lists.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	subscribed, err := model.GetSubscribedLists(r.Context(), currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get subscribed lists.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ListsPage{
+		Username: currentUsername,
+		Lists: lists,
+		Subscribed: subscribed,
+		Error: r.URL.Query().Get("error"),
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Lists",
+	}
+
+	executeTemplate(w, r, "lists.html", data)
+}
+
+func ListMembershipsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	lists, err := model.GetListMemberships(r.Context(), currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get list memberships.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ListsPage{
+		Username: currentUsername,
+		Lists: lists,
+		Memberships: true,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Lists you're on",
+	}
+
+	executeTemplate(w, r, "lists.html", data)
+}
+
+func UserListsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	username := mux.Vars(r)["username"]
+	if username == currentUsername {
+		http.Redirect(w, r, "/lists", http.StatusFound)
+		return
+	}
+	user, err := api.Data.GetUserFromUsername(r.Context(), username)
+	if err == sql.ErrNoRows && redirectRenamed(w, r, username) {
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not get user.", "err", err)
+		http.NotFound(w, r)
+		return
+	}
+	if user.Deactivated || user.Suspended {
+		http.NotFound(w, r)
+		return
+	}
+
+	lists, err := model.GetOwnedLists(r.Context(), user.Id, currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get lists.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ListsPage{
+		Username: username,
+		Lists: lists,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: fmt.Sprintf("Lists by @%s", username),
+	}
+
+	executeTemplate(w, r, "lists.html", data)
+}
+
+// getList loads the list in the list_id route variable for the signed-in
+// user, writing a redirect or 404 and returning false when it cannot.
+func getList(w http.ResponseWriter, r *http.Request) (model.List, int64, string, bool) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return model.List{}, 0, "", false
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	listId, err := strconv.ParseInt(mux.Vars(r)["list_id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return model.List{}, 0, "", false
+	}
+
+	list, err := model.GetList(r.Context(), listId, currentUid)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return model.List{}, 0, "", false
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not get list.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return model.List{}, 0, "", false
+	}
+	return list, currentUid, currentUsername, true
+}
+
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	list, currentUid, currentUsername, ok := getList(w, r)
+	if !ok {
+		return
+	}
+
+	tweets, err := model.GetListFeed(r.Context(), list.Id, currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get list timeline.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ListPage{
+		List: list,
+		Tweets: tweets,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: fmt.Sprintf("%s (@%s)", list.Name, list.OwnerUsername),
+	}
+
+	executeTemplate(w, r, "list.html", data)
+}
+
+func ListMembersHandler(w http.ResponseWriter, r *http.Request) {
+	list, currentUid, currentUsername, ok := getList(w, r)
+	if !ok {
+		return
+	}
+
+	page := api.ParsePage(r)
+	users, err := model.GetListMembers(r.Context(), list.Id, currentUid, api.PAGE_SIZE + 1, (page - 1) * api.PAGE_SIZE)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get list members.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ListMembersPage{
+		List: list,
+		Users: users,
+		PrevPage: page - 1,
+		Error: r.URL.Query().Get("error"),
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: fmt.Sprintf("Members of %s", list.Name),
+	}
+
+	if len(users) > api.PAGE_SIZE {
+		data.Users = users[:api.PAGE_SIZE]
+		data.NextPage = page + 1
+	}
+
+	executeTemplate(w, r, "list_members.html", data)
+}
+
+func ListEditHandler(w http.ResponseWriter, r *http.Request) {
+	list, currentUid, currentUsername, ok := getList(w, r)
+	if !ok {
+		return
+	}
+	if list.OwnerId != currentUid {
+		http.Redirect(w, r, fmt.Sprintf("/lists/%d", list.Id), http.StatusFound)
+		return
+	}
+
+	data := ListEditPage{
+		List: list,
+		Error: r.URL.Query().Get("error"),
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: fmt.Sprintf("Edit %s", list.Name),
+	}
+
+	executeTemplate(w, r, "list_edit.html", data)
+}
+
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	data := ForgotPasswordPage{
+		Sent: r.URL.Query().Get("sent") != "",
+	}
+	executeTemplate(w, r, "forgot.html", data)
+}
+
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	_, err := model.PeekUserToken(r.Context(), token, model.TOKEN_RESET_PASSWORD)
+	if err != nil && err != model.ErrInvalidToken {
+		logging.Error(r.Context(), "Could not check reset token.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ResetPasswordPage{
+		Token: token,
+		Valid: err == nil,
+		Error: r.URL.Query().Get("error"),
+	}
+	executeTemplate(w, r, "reset.html", data)
+}
+
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userId, email, err := model.ConsumeUserToken(r.Context(), mux.Vars(r)["token"], model.TOKEN_VERIFY_EMAIL)
+	if err == nil {
+		err = model.VerifyEmail(r.Context(), userId, email)
+	}
+	if err == model.ErrInvalidToken {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.Error(r.Context(), "Could not verify email.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	uid, ok := session.Values["uid"]
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	username, _ := session.Values["username"]
+	
+	conversations, err := api.Data.GetConversations(r.Context(), uid.(int64))
+	if err != nil {
+		logging.Error(r.Context(), "Could not get conversations.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := MessagesPage{
+		Conversations: conversations,
+		CurrentUserId: uid.(int64),
+		CurrentUsername: username.(string),
+		Title: "Messages",
+	}
+
+	executeTemplate(w, r, "messages.html", data)
+	return
+}
+
+func DMHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	otherUsername := mux.Vars(r)["user_b"]
+	if otherUsername == currentUsername {
+		otherUsername = mux.Vars(r)["user_a"]
+	}
+
+	if otherUsername == "" {
+		http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		return
+	}
+
+	otherUserId, err := strconv.ParseInt(otherUsername, 10, 64)
+	if err != nil {
+		logging.Warn(r.Context(), "Invalid user ID.", "user_id", otherUsername)
+		http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		return
+	}
+
+	crossUsers, err := api.Data.GetUsersRelationship(r.Context(), otherUserId, currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get user relationship.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if crossUsers.FirstBlocksSecond || crossUsers.SecondBlocksFirst {
+		http.Error(w, model.ErrBlocked.Error(), http.StatusForbidden)
+		return
+	}
+
+	conversationId, err := api.Data.GetTwoUsersConversation(r.Context(), otherUserId, currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get conversation.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if conversationId == 0 {
+		conversationId, err = api.Data.CreateTwoUsersConversation(r.Context(), otherUserId, currentUid)
+		if err != nil {
+			logging.Error(r.Context(), "Could not create conversation.", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	conversationURL := fmt.Sprintf("/messages/%d", conversationId)
+	http.Redirect(w, r, conversationURL, http.StatusMovedPermanently)
+	return
+}
+
+func ConversationHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
 
 	// Check if user is authenticated
@@ -487,14 +1432,14 @@ func ConversationHandler(w http.ResponseWriter, r *http.Request) {
 
 	conversationId, err := strconv.ParseInt(conversationVariable, 10, 64)
 	if err != nil {
-		log.Println("Invalid conversation ID.")
+		logging.Warn(r.Context(), "Invalid conversation ID.")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	messages, err := model.GetConversation(conversationId)
+	messages, err := api.Data.GetConversation(r.Context(), conversationId)
 	if err != nil {
-		log.Println("Could not get conversation.\n", err)
+		logging.Error(r.Context(), "Could not get conversation.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -519,7 +1464,7 @@ func ConversationHandler(w http.ResponseWriter, r *http.Request) {
 		Title: title,
 	}
 
-	templates.ExecuteTemplate(w, "message.html", data)
+	executeTemplate(w, r, "message.html", data)
 	return
 }
 
@@ -534,49 +1479,346 @@ func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	currentUsername, _ := session.Values["username"].(string)
 
-	notifications, err := model.GetNotifications(currentUid)
+	notifications, err := api.Data.GetNotifications(r.Context(), currentUid)
 	if err != nil {
-		log.Println("Could not get notifications")
+		logging.Error(r.Context(), "Could not get notifications.", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
+	followRequests, err := api.Data.CountFollowRequests(r.Context(), currentUid)
+	if err != nil {
+		logging.Error(r.Context(), "Could not count follow requests.", "err", err)
+	}
+
+	since := time.Now().Add(-MODERATION_NOTICE_PERIOD)
+	reports, err := model.GetResolvedReports(r.Context(), currentUid, since)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get resolved reports.", "err", err)
+	}
+	warnings, err := model.GetWarnings(r.Context(), currentUid, since)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get warnings.", "err", err)
+	}
+
 	data := NotificationsPage{
 		Notifications: notifications,
+		FollowRequests: followRequests,
+		Reports: reports,
+		Warnings: warnings,
 		CurrentUserId: currentUid,
 		CurrentUsername: currentUsername,
 		Title: "Notifications",
 	}
 
-	templates.ExecuteTemplate(w, "notifications.html", data)
+	executeTemplate(w, r, "notifications.html", data)
 	return
 }
 
-func main() {
+func ReportFormHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return
+	}
+	currentUsername, _ := session.Values["username"].(string)
+
+	query := r.URL.Query()
+	data := ReportPage{
+		Categories: model.REPORT_CATEGORIES,
+		Sent: query.Get("sent") != "",
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Report",
+	}
+
+	switch {
+	case data.Sent:
+	case query.Get("tweet_id") != "":
+		tweetId, err := strconv.ParseInt(query.Get("tweet_id"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		tweet, err := api.Data.GetTweet(r.Context(), tweetId, currentUid)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data.Kind = model.REPORT_TWEET
+		data.TweetId = tweet.Id
+		data.Username = tweet.Username
+		data.Content = tweet.Text
+		data.Title = "Report Tweet"
+	case query.Get("message_id") != "":
+		messageId, err := strconv.ParseInt(query.Get("message_id"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data.Kind = model.REPORT_MESSAGE
+		data.MessageId = messageId
+		data.Title = "Report message"
+	default:
+		user, err := api.Data.GetUserFromUsername(r.Context(), query.Get("username"))
+		if err != nil || user.Deactivated {
+			http.NotFound(w, r)
+			return
+		}
+		data.Kind = model.REPORT_USER
+		data.Username = user.Username
+		data.Title = fmt.Sprintf("Report @%s", user.Username)
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	executeTemplate(w, r, "report.html", data)
+}
+
+// roleSession returns the signed in user if they hold role, answering 404 to
+// anyone else so the pages' existence isn't advertised.
+func roleSession(w http.ResponseWriter, r *http.Request, role string) (int64, string, bool) {
+	session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+
+	// Check if user is authenticated
+	currentUid, ok := session.Values["uid"].(int64)
+	if ok == false {
+		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
+		return 0, "", false
+	}
+	currentUsername, _ := session.Values["username"].(string)
+	if !hasRole(r.Context(), currentUid, role) {
+		http.NotFound(w, r)
+		return 0, "", false
+	}
+	return currentUid, currentUsername, true
+}
+
+func ModerationHandler(w http.ResponseWriter, r *http.Request) {
+	currentUid, currentUsername, ok := roleSession(w, r, model.ROLE_MODERATOR)
+	if !ok {
+		return
+	}
+
+	page := api.ParsePage(r)
+	reports, err := model.GetOpenReports(r.Context(), api.PAGE_SIZE + 1, (page - 1) * api.PAGE_SIZE)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get reports.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ModerationPage{
+		Resolved: r.URL.Query().Get("resolved"),
+		PrevPage: page - 1,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Moderation",
+	}
+	if len(reports) > api.PAGE_SIZE {
+		reports = reports[:api.PAGE_SIZE]
+		data.NextPage = page + 1
+	}
+	data.Reports = reports
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	executeTemplate(w, r, "moderation.html", data)
+}
+
+func ModerationLogHandler(w http.ResponseWriter, r *http.Request) {
+	currentUid, currentUsername, ok := roleSession(w, r, model.ROLE_MODERATOR)
+	if !ok {
+		return
+	}
+
+	page := api.ParsePage(r)
+	actions, err := model.GetModerationLog(r.Context(), api.PAGE_SIZE + 1, (page - 1) * api.PAGE_SIZE)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get moderation log.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := ModerationLogPage{
+		PrevPage: page - 1,
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Moderation log",
+	}
+	if len(actions) > api.PAGE_SIZE {
+		actions = actions[:api.PAGE_SIZE]
+		data.NextPage = page + 1
+	}
+	data.Actions = actions
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	executeTemplate(w, r, "moderation_log.html", data)
+}
+
+func AdminHandler(w http.ResponseWriter, r *http.Request) {
+	currentUid, currentUsername, ok := roleSession(w, r, model.ROLE_ADMIN)
+	if !ok {
+		return
+	}
+
+	stats, err := model.GetStats(r.Context())
+	if err != nil {
+		logging.Error(r.Context(), "Could not get stats.", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	daily, err := model.GetDailyCounts(r.Context(), ADMIN_DAYS_SHOWN)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get daily counts.", "err", err)
+	}
+	reports, err := model.GetOpenReports(r.Context(), ADMIN_ITEMS_SHOWN, 0)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get reports.", "err", err)
+	}
+	actions, err := model.GetModerationLog(r.Context(), ADMIN_ITEMS_SHOWN, 0)
+	if err != nil {
+		logging.Error(r.Context(), "Could not get moderation log.", "err", err)
+	}
+
+	query := r.URL.Query()
+	data := AdminPage{
+		Stats: stats,
+		Daily: daily,
+		Reports: reports,
+		Actions: actions,
+		Username: strings.TrimPrefix(query.Get("username"), "@"),
+		Roles: model.ROLES,
+		Saved: query.Get("saved"),
+		CurrentUsername: currentUsername,
+		CurrentUserId: currentUid,
+		Title: "Admin",
+	}
+	if data.Username != "" {
+		data.User, err = api.Data.GetUserFromUsername(r.Context(), data.Username)
+		data.Found = err == nil
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	executeTemplate(w, r, "admin.html", data)
+}
+
+// serve runs the web server on $PORT.
+func serve() {
+	logging.Init()
+	err := api.InitSiteURL()
+	if err != nil {
+		log.Fatal("Could not read the site URL: ", err)
+	}
 	model.InitDB()
 	api.Init()
+	mail.Init()
+	ratelimit.Init()
+	timeline.Init()
+
+	err = tracing.Init()
+	if err != nil {
+		log.Println("Could not start tracing.\n", err)
+	}
+
+	serveDebug()
 
 	port := ":" + os.Getenv("PORT")
+	log.Fatal(http.ListenAndServe(port, newHandler()))
+}
 
+// newHandler wraps newRouter in the logging, tracing and metrics middleware.
+func newHandler() http.Handler {
+	router := newRouter()
+	return logging.Middleware(tracing.Middleware(router, metrics.Middleware(router)))
+}
+
+// newRouter routes every page and API endpoint. It is shared by serve and
+// the integration tests.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(api.SuspensionMiddleware)
+	r.Use(api.SessionVersionMiddleware)
 	s := r.PathPrefix("/api").Subrouter()
-	s.HandleFunc("/tweet", api.TweetHandler).Methods("POST")
-	s.HandleFunc("/follow", api.FollowHandler).Methods("POST")
-	s.HandleFunc("/retweet", api.RetweetHandler).Methods("POST")
-	s.HandleFunc("/like", api.LikeHandler).Methods("POST")
-	s.HandleFunc("/messages/{conversation_id}", api.MessageHandler).Methods("POST")
+	s.HandleFunc("/tweet", ratelimit.Handler(ratelimit.TweetPolicy, api.TweetHandler)).Methods("POST")
+	s.HandleFunc("/follow", ratelimit.Handler(ratelimit.FollowPolicy, api.FollowHandler)).Methods("POST")
+	s.HandleFunc("/unfollow", ratelimit.Handler(ratelimit.FollowPolicy, api.UnfollowHandler)).Methods("POST")
+	s.HandleFunc("/retweet", ratelimit.Handler(ratelimit.RetweetPolicy, api.RetweetHandler)).Methods("POST")
+	s.HandleFunc("/like", ratelimit.Handler(ratelimit.LikePolicy, api.LikeHandler)).Methods("POST")
+	s.HandleFunc("/pin", api.PinHandler).Methods("POST")
+	s.HandleFunc("/unpin", api.UnpinHandler).Methods("POST")
+	s.HandleFunc("/bookmark", ratelimit.Handler(ratelimit.LikePolicy, api.BookmarkHandler)).Methods("POST")
+	s.HandleFunc("/unbookmark", ratelimit.Handler(ratelimit.LikePolicy, api.UnbookmarkHandler)).Methods("POST")
+	s.HandleFunc("/bookmarks", api.BookmarksJSONHandler).Methods("GET")
+	s.HandleFunc("/lists", api.CreateListHandler).Methods("POST")
+	s.HandleFunc("/lists/{list_id:[0-9]+}/edit", api.EditListHandler).Methods("POST")
+	s.HandleFunc("/lists/{list_id:[0-9]+}/delete", api.DeleteListHandler).Methods("POST")
+	s.HandleFunc("/lists/{list_id:[0-9]+}/members", ratelimit.Handler(ratelimit.FollowPolicy, api.AddListMemberHandler)).Methods("POST")
+	s.HandleFunc("/lists/{list_id:[0-9]+}/members/remove", api.RemoveListMemberHandler).Methods("POST")
+	s.HandleFunc("/lists/{list_id:[0-9]+}/subscribe", ratelimit.Handler(ratelimit.FollowPolicy, api.SubscribeListHandler)).Methods("POST")
+	s.HandleFunc("/lists/{list_id:[0-9]+}/unsubscribe", api.UnsubscribeListHandler).Methods("POST")
+	s.HandleFunc("/follow_requests/approve", ratelimit.Handler(ratelimit.FollowPolicy, api.ApproveFollowRequestHandler)).Methods("POST")
+	s.HandleFunc("/follow_requests/deny", ratelimit.Handler(ratelimit.FollowPolicy, api.DenyFollowRequestHandler)).Methods("POST")
+	s.HandleFunc("/follow_requests/cancel", ratelimit.Handler(ratelimit.FollowPolicy, api.CancelFollowRequestHandler)).Methods("POST")
+	s.HandleFunc("/block", ratelimit.Handler(ratelimit.FollowPolicy, api.BlockHandler)).Methods("POST")
+	s.HandleFunc("/unblock", ratelimit.Handler(ratelimit.FollowPolicy, api.UnblockHandler)).Methods("POST")
+	s.HandleFunc("/mute", ratelimit.Handler(ratelimit.FollowPolicy, api.MuteHandler)).Methods("POST")
+	s.HandleFunc("/unmute", ratelimit.Handler(ratelimit.FollowPolicy, api.UnmuteHandler)).Methods("POST")
+	s.HandleFunc("/messages/{conversation_id}", ratelimit.Handler(ratelimit.MessagePolicy, api.MessageHandler)).Methods("POST")
+	s.HandleFunc("/forgot", ratelimit.Handler(ratelimit.AccountPolicy, api.ForgotPasswordHandler)).Methods("POST")
+	s.HandleFunc("/reset/{token}", ratelimit.Handler(ratelimit.AccountPolicy, api.ResetPasswordHandler)).Methods("POST")
+	s.HandleFunc("/export", ratelimit.Handler(ratelimit.AccountPolicy, api.RequestExportHandler)).Methods("POST")
+	s.HandleFunc("/export", api.DownloadExportHandler).Methods("GET")
+	s.HandleFunc("/report", ratelimit.Handler(ratelimit.ReportPolicy, api.ReportHandler)).Methods("POST")
+	s.HandleFunc("/moderation/reports/{report_id:[0-9]+}", api.ResolveReportHandler).Methods("POST")
+	s.HandleFunc("/admin/users/{username}/role", api.SetRoleHandler).Methods("POST")
+	s.HandleFunc("/admin/users/{username}/suspend", api.SuspendUserHandler).Methods("POST")
+	s.HandleFunc("/admin/users/{username}/unsuspend", api.UnsuspendUserHandler).Methods("POST")
 	s.HandleFunc("/{username}/edit", api.UserEditHandler).Methods("POST")
+	s.HandleFunc("/{username}/followers", api.FollowersJSONHandler).Methods("GET")
+	s.HandleFunc("/{username}/following", api.FollowingJSONHandler).Methods("GET")
+	s.HandleFunc("/{username}/username", ratelimit.Handler(ratelimit.AccountPolicy, api.UsernameHandler)).Methods("POST")
+	s.HandleFunc("/{username}/deactivate", ratelimit.Handler(ratelimit.AccountPolicy, api.DeactivateHandler)).Methods("POST")
+	s.HandleFunc("/{username}/delete", ratelimit.Handler(ratelimit.AccountPolicy, api.DeleteAccountHandler)).Methods("POST")
+	s.HandleFunc("/{username}/password", ratelimit.Handler(ratelimit.AccountPolicy, api.PasswordHandler)).Methods("POST")
+	s.HandleFunc("/{username}/verify", ratelimit.Handler(ratelimit.AccountPolicy, api.ResendVerificationHandler)).Methods("POST")
 
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	r.HandleFunc("/login", LoginHandler)
+	r.PathPrefix("/media/").HandlerFunc(api.MediaHandler).Methods("GET")
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		r.Handle("/metrics", metricsHandler(token)).Methods("GET")
+	}
+	r.HandleFunc("/login", ratelimit.Handler(ratelimit.LoginPolicy, LoginHandler))
 	r.HandleFunc("/logout", LogoutHandler)
+	r.HandleFunc("/forgot", ForgotPasswordHandler).Methods("GET")
+	r.HandleFunc("/reset/{token}", ResetPasswordHandler).Methods("GET")
+	r.HandleFunc("/verify/{token}", VerifyEmailHandler).Methods("GET")
 	r.HandleFunc("/tweet/{tweet_id}", TweetHandler).Methods("GET")
 	r.HandleFunc("/notifications", NotificationsHandler).Methods("GET")
+	r.HandleFunc("/welcome", WelcomeHandler).Methods("GET")
 	r.HandleFunc("/messages", MessagesHandler).Methods("GET")
+	r.HandleFunc("/follow_requests", FollowRequestsHandler).Methods("GET")
+	r.HandleFunc("/bookmarks", BookmarksHandler).Methods("GET")
+	r.HandleFunc("/lists", ListsHandler).Methods("GET")
+	r.HandleFunc("/lists/memberships", ListMembershipsHandler).Methods("GET")
+	r.HandleFunc("/lists/{list_id:[0-9]+}", ListHandler).Methods("GET")
+	r.HandleFunc("/lists/{list_id:[0-9]+}/members", ListMembersHandler).Methods("GET")
+	r.HandleFunc("/lists/{list_id:[0-9]+}/edit", ListEditHandler).Methods("GET")
+	r.HandleFunc("/settings/account", AccountSettingsHandler).Methods("GET")
+	r.HandleFunc("/report", ReportFormHandler).Methods("GET")
+	r.HandleFunc("/moderation", ModerationHandler).Methods("GET")
+	r.HandleFunc("/moderation/log", ModerationLogHandler).Methods("GET")
+	r.HandleFunc("/admin", AdminHandler).Methods("GET")
+	r.HandleFunc("/settings/blocked", BlockedUsersHandler).Methods("GET")
+	r.HandleFunc("/settings/muted", MutedUsersHandler).Methods("GET")
 	r.HandleFunc("/messages/{user_a}-{user_b}", DMHandler).Methods("GET")
 	r.HandleFunc("/messages/{conversation_id}", ConversationHandler).Methods("GET")
 	r.HandleFunc("/{username}", UserHandler).Methods("GET")
 	r.HandleFunc("/{username}/likes", UserLikesHandler).Methods("GET")
+	r.HandleFunc("/{username}/followers", UserFollowersHandler).Methods("GET")
+	r.HandleFunc("/{username}/following", UserFollowingHandler).Methods("GET")
+	r.HandleFunc("/{username}/lists", UserListsHandler).Methods("GET")
 	r.HandleFunc("/{username}/edit", UserEditHandler).Methods("GET")
 	r.HandleFunc("/", IndexHandler).Methods("GET")
-	log.Fatal(http.ListenAndServe(port, r))
+	return r
 }
\ No newline at end of file
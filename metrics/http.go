@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"time"
+	"strconv"
+	"net/http"
+	mux "github.com/gorilla/mux"
+)
+
+var (
+	requests = NewCounter("http_requests_total", "HTTP requests by route template, method and status.", "route", "method", "status")
+	requestDuration = NewHistogram("http_request_duration_seconds", "HTTP request latency by route template and method.", DURATION_BUCKETS, "route", "method")
+)
+
+// UNMATCHED labels requests that match no route, so that probes for random
+// paths can't create unbounded label values.
+const UNMATCHED = "unmatched"
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Middleware counts and times the router's requests, labelled with the
+// template of the route they match, such as /{username}/followers.
+func Middleware(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := UNMATCHED
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		router.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		method := methodLabel(r.Method)
+		requests.Inc(route, method, strconv.Itoa(sw.status))
+		requestDuration.Observe(time.Since(start).Seconds(), route, method)
+	})
+}
+
+// methodLabel keeps made-up methods from creating label values.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		return method
+	}
+	return "other"
+}
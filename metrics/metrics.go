@@ -0,0 +1,250 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text format. Metrics are created once, usually as package
+// variables, and registered for the life of the process.
+package metrics
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"math"
+	"strings"
+	"strconv"
+	"net/http"
+)
+
+// DURATION_BUCKETS suit request and query latencies, in seconds.
+var DURATION_BUCKETS = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SIZE_BUCKETS suit uploads, in bytes, from 1KB to 64MB.
+var SIZE_BUCKETS = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+
+type metric interface {
+	metricName() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry = make(map[string]metric)
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[m.metricName()]; ok {
+		panic("metrics: " + m.metricName() + " is already registered")
+	}
+	registry[m.metricName()] = m
+}
+
+// desc is what every metric has: a name, help text and label names.
+type desc struct {
+	name string
+	help string
+	kind string
+	labels []string
+}
+
+func (d desc) metricName() string {
+	return d.name
+}
+
+func (d desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.Replace(d.help, "\n", " ", -1), d.name, d.kind)
+}
+
+// key joins label values into a map key, checking there is one per label.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelPairs formats names and values as {a="x",b="y"}.
+func labelPairs(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter counts events for each combination of label values.
+type Counter struct {
+	desc
+	mu sync.Mutex
+	values map[string]float64
+	labelValues map[string][]string
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc: desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+		labelValues: make(map[string][]string),
+	}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.labelValues[key]; !ok {
+		c.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range sortedKeys(c.labelValues) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelPairs(c.labels, c.labelValues[key]), formatFloat(c.values[key]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets for each
+// combination of label values.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts []uint64
+	count uint64
+	sum float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc: desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series: make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		values := append(append([]string(nil), s.labelValues...), "")
+		for i, bound := range h.buckets {
+			values[len(values) - 1] = formatFloat(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(labels, values), s.counts[i])
+		}
+		values[len(values) - 1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(labels, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, s.labelValues), s.count)
+	}
+}
+
+// funcMetric reads its value when the metrics are scraped, for numbers that
+// are kept elsewhere such as the database pool's.
+type funcMetric struct {
+	desc
+	value func() float64
+}
+
+func NewGaugeFunc(name, help string, value func() float64) {
+	register(&funcMetric{desc: desc{name: name, help: help, kind: "gauge"}, value: value})
+}
+
+// NewCounterFunc is NewGaugeFunc for values that only go up.
+func NewCounterFunc(name, help string, value func() float64) {
+	register(&funcMetric{desc: desc{name: name, help: help, kind: "counter"}, value: value})
+}
+
+func (m *funcMetric) write(w io.Writer) {
+	m.header(w)
+	fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.value()))
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteTo writes every registered metric, sorted by name.
+func WriteTo(w io.Writer) {
+	registryMu.Lock()
+	metrics := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].metricName() < metrics[j].metricName()
+	})
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics for Prometheus to scrape.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	mux "github.com/gorilla/mux"
+)
+
+func written(m metric) string {
+	var buf bytes.Buffer
+	m.write(&buf)
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_events_total", "Events.", "kind")
+	c.Inc("a")
+	c.Add(2, "a")
+	c.Inc(`b"\`)
+
+	want := `# HELP test_events_total Events.
+# TYPE test_events_total counter
+test_events_total{kind="a"} 3
+test_events_total{kind="b\"\\"} 1
+`
+	if got := written(c); got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{1, 5}, "route")
+	h.Observe(0.5, "/")
+	h.Observe(3, "/")
+	h.Observe(10, "/")
+
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/",le="1"} 1
+test_duration_seconds_bucket{route="/",le="5"} 2
+test_duration_seconds_bucket{route="/",le="+Inf"} 3
+test_duration_seconds_sum{route="/"} 13.5
+test_duration_seconds_count{route="/"} 3
+`
+	if got := written(h); got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	NewGaugeFunc("test_duplicate", "Duplicate.", func() float64 { return 1 })
+	defer func() {
+		if recover() == nil {
+			t.Error("Registering a name twice did not panic")
+		}
+	}()
+	NewGaugeFunc("test_duplicate", "Duplicate.", func() float64 { return 1 })
+}
+
+func TestMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/{username}/followers", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}).Methods("GET")
+	handler := Middleware(router)
+
+	for _, path := range []string{"/ada/followers", "/ben/followers", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/ada/followers", nil))
+
+	got := written(requests)
+	for _, line := range []string{
+		`http_requests_total{route="/{username}/followers",method="GET",status="202"} 2`,
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`http_requests_total{route="unmatched",method="other",status="405"} 1`,
+	} {
+		if !strings.Contains(got, line + "\n") {
+			t.Errorf("Missing %s in\n%s", line, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "# TYPE http_request_duration_seconds histogram") {
+		t.Errorf("Got %s", w.Body)
+	}
+}
@@ -0,0 +1,256 @@
+package model
+
+import (
+	"context"
+	"time"
+	"errors"
+	"encoding/hex"
+	"crypto/rand"
+	"database/sql"
+	pq "github.com/lib/pq"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+const (
+	TOKEN_VERIFY_EMAIL = "verify_email"
+	TOKEN_RESET_PASSWORD = "reset_password"
+)
+
+var ErrInvalidToken = errors.New("token is invalid or has expired")
+var ErrEmailTaken = errors.New("that email address is already in use")
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateUserToken stores a single-use token of the given kind for userId.
+// Email is recorded alongside verification tokens so that a link sent to an
+// address the user has since replaced cannot verify the new one.
+func CreateUserToken(ctx context.Context, userId int64, kind, email string, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		logging.Error(ctx, "Could not generate token.", "err", err)
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO user_tokens (token, user_id, kind, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		token, userId, kind, email, time.Now().Add(ttl))
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// PeekUserToken returns the owner of an unexpired token without consuming it.
+func PeekUserToken(ctx context.Context, token, kind string) (int64, error) {
+	var userId int64
+	err := db.QueryRowContext(ctx, `SELECT user_id FROM user_tokens
+		WHERE token = $1 AND kind = $2 AND expires_at > now()`, token, kind).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, err
+	}
+	return userId, nil
+}
+
+// ConsumeUserToken deletes the token and returns the user and email it was
+// issued for. Expired tokens are rejected with ErrInvalidToken.
+func ConsumeUserToken(ctx context.Context, token, kind string) (int64, string, error) {
+	var userId int64
+	var email sql.NullString
+	err := db.QueryRowContext(ctx, `DELETE FROM user_tokens
+		WHERE token = $1 AND kind = $2 AND expires_at > now()
+		RETURNING user_id, email`, token, kind).Scan(&userId, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrInvalidToken
+	}
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, "", err
+	}
+	return userId, nullStringToString(email), nil
+}
+
+func GetUsernameFromVerifiedEmail(ctx context.Context, email string) (string, error) {
+	var username string
+	err := db.QueryRowContext(ctx, `SELECT username FROM users
+		WHERE lower(email) = lower($1) AND email_verified`, email).Scan(&username)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+	return username, nil
+}
+
+// SetEmail replaces the user's address and marks it unverified. It returns
+// ErrEmailTaken if another account has the address in any case.
+func SetEmail(ctx context.Context, userId int64, email string) error {
+	var maybeEmail sql.NullString
+	if email != "" {
+		maybeEmail = sql.NullString{String: email, Valid: true}
+	}
+	_, err := db.ExecContext(ctx, `UPDATE users SET email = $1, email_verified = false WHERE id = $2`, maybeEmail, userId)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func VerifyEmail(ctx context.Context, userId int64, email string) error {
+	result, err := db.ExecContext(ctx, `UPDATE users SET email_verified = true
+		WHERE id = $1 AND email = $2`, userId, email)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	if rows != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// ChangePassword sets a new password and revokes any outstanding reset tokens.
+// It also bumps the session version, which signs out every existing session.
+func ChangePassword(ctx context.Context, userId int64, password string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET password = $1, session_version = session_version + 1
+		WHERE id = $2`, password, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM user_tokens WHERE user_id = $1 AND kind = $2`, userId, TOKEN_RESET_PASSWORD)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetSessionVersion returns the version sessions for userId were signed in
+// under. Sessions saved with an older one predate a password change.
+func GetSessionVersion(ctx context.Context, userId int64) (int64, error) {
+	var version int64
+	err := db.QueryRowContext(ctx, `SELECT session_version FROM users WHERE id = $1`, userId).Scan(&version)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, err
+	}
+	return version, nil
+}
+
+// DeactivateUser hides userId's profile, Tweets and follows until they sign
+// in again. Accounts left deactivated are deleted by the purge.
+func DeactivateUser(ctx context.Context, userId int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET deactivated_at = now() WHERE id = $1`, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func ReactivateUser(ctx context.Context, userId int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET deactivated_at = NULL WHERE id = $1`, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetExpiredDeactivations returns the accounts deactivated before the given
+// time.
+func GetExpiredDeactivations(ctx context.Context, before time.Time) ([]int64, error) {
+	return queryIds(ctx, `SELECT id FROM users WHERE deactivated_at < $1`, before)
+}
+
+// UserFiles are the uploaded objects that belong to a user. Avatar and banner
+// URLs are the bases their variants share; exports are object names.
+type UserFiles struct {
+	TweetImages []string
+	AvatarURL string
+	BannerURL string
+	Exports []string
+}
+
+func GetUserFiles(ctx context.Context, userId int64) (UserFiles, error) {
+	var files UserFiles
+	var avatarURL, bannerURL sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT avatar_url, banner_url FROM users WHERE id = $1`, userId).Scan(&avatarURL, &bannerURL)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return files, err
+	}
+	files.AvatarURL = nullStringToString(avatarURL)
+	files.BannerURL = nullStringToString(bannerURL)
+
+	files.TweetImages, err = queryStrings(ctx, `SELECT image_url FROM tweets
+		WHERE user_id = $1 AND COALESCE(image_url, '') != ''`, userId)
+	if err != nil {
+		return files, err
+	}
+	files.Exports, err = queryStrings(ctx, `SELECT object_name FROM data_exports
+		WHERE user_id = $1 AND object_name IS NOT NULL`, userId)
+	return files, err
+}
+
+// DeleteUser permanently removes userId and everything they made: Tweets,
+// with the likes, retweets and bookmarks they received, plus the user's own
+// likes, retweets, follows, messages, lists and settings. Replies other people
+// made to their Tweets are kept but detached. Conversations nobody is left in
+// are removed. Uploaded files are the caller's to delete, see GetUserFiles.
+func DeleteUser(ctx context.Context, userId int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`UPDATE tweets SET parent_id = NULL
+			WHERE parent_id IN (SELECT id FROM tweets WHERE user_id = $1)`,
+		`DELETE FROM tweets WHERE user_id = $1`,
+		`DELETE FROM follows WHERE follower = $1 OR followed = $1`,
+		`DELETE FROM messages WHERE sender_id = $1`,
+		`DELETE FROM users WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement, userId)
+		if err != nil {
+			logging.Error(ctx, "Query failed.", "err", err)
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM conversations c
+		WHERE NOT EXISTS (SELECT 1 FROM conversations_users cu WHERE cu.conversation_id = c.id)`)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"context"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// Stats summarises the instance for the admin dashboard.
+type Stats struct {
+	Users int64
+	ActiveUsers int64
+	DeactivatedUsers int64
+	SuspendedUsers int64
+	Moderators int64
+	Admins int64
+	Tweets int64
+	SignupsToday int64
+	SignupsWeek int64
+	TweetsToday int64
+	TweetsWeek int64
+	OpenReports int64
+}
+
+// DailyCount is the number of signups and Tweets on one day.
+type DailyCount struct {
+	Day string
+	Signups int64
+	Tweets int64
+}
+
+func GetStats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := db.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM users WHERE deactivated_at IS NULL
+			AND (suspended_until IS NULL OR suspended_until <= now())),
+		(SELECT COUNT(*) FROM users WHERE deactivated_at IS NOT NULL),
+		(SELECT COUNT(*) FROM users WHERE suspended_until > now()),
+		(SELECT COUNT(*) FROM users WHERE role = $1),
+		(SELECT COUNT(*) FROM users WHERE role = $2),
+		(SELECT COUNT(*) FROM tweets),
+		(SELECT COUNT(*) FROM users WHERE created_at > now() - interval '1 day'),
+		(SELECT COUNT(*) FROM users WHERE created_at > now() - interval '7 days'),
+		(SELECT COUNT(*) FROM tweets WHERE created_at > now() - interval '1 day'),
+		(SELECT COUNT(*) FROM tweets WHERE created_at > now() - interval '7 days'),
+		(SELECT COUNT(*) FROM reports WHERE status = $3)`,
+		ROLE_MODERATOR, ROLE_ADMIN, REPORT_OPEN).Scan(&stats.Users, &stats.ActiveUsers, &stats.DeactivatedUsers,
+		&stats.SuspendedUsers, &stats.Moderators, &stats.Admins, &stats.Tweets, &stats.SignupsToday,
+		&stats.SignupsWeek, &stats.TweetsToday, &stats.TweetsWeek, &stats.OpenReports)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+// GetDailyCounts returns signups and Tweets for each of the last days days,
+// most recent first, including days with neither.
+func GetDailyCounts(ctx context.Context, days int) ([]DailyCount, error) {
+	result, err := db.QueryContext(ctx, `SELECT to_char(d.day, 'YYYY-MM-DD'),
+		(SELECT COUNT(*) FROM users WHERE created_at >= d.day AND created_at < d.day + interval '1 day'),
+		(SELECT COUNT(*) FROM tweets WHERE created_at >= d.day AND created_at < d.day + interval '1 day')
+		FROM generate_series(date_trunc('day', now()) - ($1 - 1) * interval '1 day', date_trunc('day', now()), interval '1 day') AS d(day)
+		ORDER BY d.day DESC`, days)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var counts []DailyCount
+	for result.Next() {
+		var count DailyCount
+		err := result.Scan(&count.Day, &count.Signups, &count.Tweets)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
@@ -0,0 +1,578 @@
+package model_test
+
+import (
+	"fmt"
+	"time"
+	"testing"
+	"database/sql"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	timeline "github.com/dustinnewman98/twitter_clone/timeline"
+	recommend "github.com/dustinnewman98/twitter_clone/recommend"
+	seed "github.com/dustinnewman98/twitter_clone/seed"
+)
+
+// The benchmarks run in the schema TestMain creates when TEST_DATABASE_URL
+// is set, which is filled with seed.DefaultConfig first. Seeding takes a
+// few minutes, so it only happens when benchmarks are asked for:
+//
+//	TEST_DATABASE_URL=... go test -run XXX -bench . ./model
+
+// fixture holds the accounts and content the benchmarks query: the most
+// followed user, one of their followers viewing them, and things the
+// viewer can see.
+var fixture struct {
+	skip string
+	userId int64
+	username string
+	viewerId int64
+	followingIds []int64
+	tweetId int64
+	tweetIds []int64
+	conversationId int64
+	otherUserId int64
+	listId int64
+}
+
+// setUp seeds the schema and picks the fixture out of it.
+func setUp() error {
+	_, err := seed.Generate(ctx, seed.DefaultConfig)
+	if err != nil {
+		return err
+	}
+	stats, err := model.GetFollowGraphStats(ctx, 1)
+	if err != nil {
+		return err
+	}
+	if len(stats.TopFollowed) == 0 {
+		return fmt.Errorf("seeded database has no follows")
+	}
+	fixture.username = stats.TopFollowed[0].Username
+	fixture.userId, err = model.GetUserIdFromUsername(ctx, fixture.username)
+	if err != nil {
+		return err
+	}
+
+	followers, err := model.GetFollowerIds(ctx, fixture.userId)
+	if err != nil {
+		return err
+	}
+	// Prefer a follower with direct messages, so the conversation
+	// benchmarks have something to read.
+	for i, followerId := range followers {
+		conversations, err := model.GetConversations(ctx, followerId)
+		if err != nil {
+			return err
+		}
+		if i == 0 || len(conversations) > 0 {
+			fixture.viewerId = followerId
+		}
+		if len(conversations) > 0 {
+			fixture.conversationId = conversations[0].Id
+			fixture.otherUserId, err = model.GetUserIdFromUsername(ctx, conversations[0].OtherUserName)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	fixture.followingIds, err = model.GetFollowingIds(ctx, fixture.viewerId)
+	if err != nil {
+		return err
+	}
+
+	tweets, err := model.GetHistory(ctx, fixture.userId, fixture.viewerId)
+	if err != nil {
+		return err
+	}
+	if len(tweets) == 0 {
+		return fmt.Errorf("%s has no Tweets", fixture.username)
+	}
+	fixture.tweetId = tweets[0].Id
+	for _, tweet := range tweets {
+		fixture.tweetIds = append(fixture.tweetIds, tweet.Id)
+	}
+
+	// Seeded data has no lists, so the viewer gets one for the duration.
+	fixture.listId, err = model.CreateList(ctx, fixture.viewerId, "Benchmark", "", false)
+	if err != nil {
+		return err
+	}
+	for _, userId := range fixture.followingIds {
+		err = model.AddListMember(ctx, fixture.listId, fixture.viewerId, userId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchmark times query, which must succeed. Lookups that find nothing
+// still cost a round trip, so sql.ErrNoRows counts as success.
+func benchmark(b *testing.B, query func() error) {
+	if fixture.skip != "" {
+		b.Skip(fixture.skip)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := query()
+		if err != nil && err != sql.ErrNoRows {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Users
+
+func BenchmarkGetUserFromUsername(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetUserFromUsername(ctx, fixture.username)
+		return err
+	})
+}
+
+func BenchmarkGetUserIdFromUsername(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetUserIdFromUsername(ctx, fixture.username)
+		return err
+	})
+}
+
+func BenchmarkGetRenamedUsername(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetRenamedUsername(ctx, fixture.username)
+		return err
+	})
+}
+
+func BenchmarkGetUsersRelationship(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetUsersRelationship(ctx, fixture.userId, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetUsernameFromVerifiedEmail(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetUsernameFromVerifiedEmail(ctx, "nobody@example.com")
+		return err
+	})
+}
+
+func BenchmarkPeekUserToken(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.PeekUserToken(ctx, "no-such-token", model.TOKEN_RESET_PASSWORD)
+		return err
+	})
+}
+
+func BenchmarkGetUserFiles(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetUserFiles(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkGetArchive(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetArchive(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkGetLatestDataExport(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetLatestDataExport(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkGetExpiredDataExports(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetExpiredDataExports(ctx, time.Now())
+		return err
+	})
+}
+
+func BenchmarkGetExpiredDeactivations(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetExpiredDeactivations(ctx, time.Now())
+		return err
+	})
+}
+
+// Tweets
+
+func BenchmarkGetTweet(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetTweet(ctx, fixture.tweetId, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetReplies(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetReplies(ctx, fixture.tweetId, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetTweetsByIds(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetTweetsByIds(ctx, fixture.tweetIds, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetFeed(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFeed(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetHistory(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetHistory(ctx, fixture.userId, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetLikes(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetLikes(ctx, fixture.userId, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetBookmarks(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetBookmarks(ctx, fixture.viewerId, "", 20, 0)
+		return err
+	})
+}
+
+func BenchmarkGetBookmarksSearch(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetBookmarks(ctx, fixture.viewerId, "golang", 20, 0)
+		return err
+	})
+}
+
+func BenchmarkGetNotifications(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetNotifications(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkCreateTweet(b *testing.B) {
+	benchmark(b, func() error {
+		tweetId, err := model.CreateTweet(ctx, model.TweetRequest{UserId: fixture.viewerId, Text: "Benchmarking #golang"})
+		if err != nil {
+			return err
+		}
+		_, err = model.DeleteTweet(ctx, tweetId)
+		return err
+	})
+}
+
+func BenchmarkPinTweet(b *testing.B) {
+	benchmark(b, func() error {
+		err := model.PinTweet(ctx, fixture.userId, fixture.tweetId)
+		if err != nil {
+			return err
+		}
+		return model.UnpinTweet(ctx, fixture.userId, fixture.tweetId)
+	})
+}
+
+func BenchmarkCreateBookmark(b *testing.B) {
+	benchmark(b, func() error {
+		err := model.CreateBookmark(ctx, fixture.viewerId, fixture.tweetId)
+		if err != nil {
+			return err
+		}
+		return model.DeleteBookmark(ctx, fixture.viewerId, fixture.tweetId)
+	})
+}
+
+// Messages
+
+func BenchmarkGetConversations(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetConversations(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetConversation(b *testing.B) {
+	if fixture.skip == "" && fixture.conversationId == 0 {
+		b.Skip("no conversations seeded")
+	}
+	benchmark(b, func() error {
+		_, err := model.GetConversation(ctx, fixture.conversationId)
+		return err
+	})
+}
+
+func BenchmarkGetTwoUsersConversation(b *testing.B) {
+	if fixture.skip == "" && fixture.conversationId == 0 {
+		b.Skip("no conversations seeded")
+	}
+	benchmark(b, func() error {
+		_, err := model.GetTwoUsersConversation(ctx, fixture.otherUserId, fixture.viewerId)
+		return err
+	})
+}
+
+// Follows
+
+func BenchmarkGetFollowers(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowers(ctx, fixture.userId, fixture.viewerId, 20, 0)
+		return err
+	})
+}
+
+func BenchmarkGetFollowing(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowing(ctx, fixture.viewerId, fixture.userId, 20, 0)
+		return err
+	})
+}
+
+func BenchmarkGetMutualFollowers(b *testing.B) {
+	benchmark(b, func() error {
+		_, _, err := model.GetMutualFollowers(ctx, fixture.userId, fixture.viewerId, 3)
+		return err
+	})
+}
+
+func BenchmarkGetFollowRequests(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowRequests(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkCountFollowRequests(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.CountFollowRequests(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkGetFollowGraphStats(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowGraphStats(ctx, 10)
+		return err
+	})
+}
+
+func BenchmarkGetBlockedUsers(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetBlockedUsers(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetMutedUsers(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetMutedUsers(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkCreateMute(b *testing.B) {
+	benchmark(b, func() error {
+		err := model.CreateMute(ctx, fixture.viewerId, fixture.userId)
+		if err != nil {
+			return err
+		}
+		return model.DeleteMute(ctx, fixture.viewerId, fixture.userId)
+	})
+}
+
+// Lists
+
+func BenchmarkGetList(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetList(ctx, fixture.listId, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetOwnedLists(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetOwnedLists(ctx, fixture.viewerId, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetSubscribedLists(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetSubscribedLists(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetListMemberships(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetListMemberships(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkGetListMembers(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetListMembers(ctx, fixture.listId, fixture.viewerId, 20, 0)
+		return err
+	})
+}
+
+func BenchmarkGetListFeed(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetListFeed(ctx, fixture.listId, fixture.viewerId)
+		return err
+	})
+}
+
+// Timelines and recommendations
+
+func BenchmarkGetTimelineCandidates(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetTimelineCandidates(ctx, fixture.viewerId, timeline.CANDIDATE_WINDOW, timeline.CANDIDATE_LIMIT)
+		return err
+	})
+}
+
+func BenchmarkGetFollowerIds(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowerIds(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkGetFollowingIds(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowingIds(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetAllUserIds(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetAllUserIds(ctx)
+		return err
+	})
+}
+
+func BenchmarkGetFollowerCount(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowerCount(ctx, fixture.userId)
+		return err
+	})
+}
+
+func BenchmarkGetHeavyFollowedIds(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetHeavyFollowedIds(ctx, fixture.viewerId, timeline.DEFAULT_FANOUT_LIMIT)
+		return err
+	})
+}
+
+func BenchmarkGetActivity(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetActivity(ctx, fixture.followingIds, timeline.MAX_ENTRIES)
+		return err
+	})
+}
+
+func BenchmarkGetTimelineEntries(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetTimelineEntries(ctx, fixture.viewerId, timeline.FEED_SIZE)
+		return err
+	})
+}
+
+func BenchmarkGetFollowCandidates(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetFollowCandidates(ctx, fixture.viewerId, recommend.CANDIDATE_POOL)
+		return err
+	})
+}
+
+func BenchmarkGetPopularUsers(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetPopularUsers(ctx, fixture.viewerId, recommend.CANDIDATE_POOL)
+		return err
+	})
+}
+
+func BenchmarkTakeRateLimitToken(b *testing.B) {
+	key := fmt.Sprintf("benchmark:%d", fixture.viewerId)
+	benchmark(b, func() error {
+		_, _, err := model.TakeRateLimitToken(ctx, key, 1, 10, true)
+		return err
+	})
+	model.DeleteRateLimit(ctx, key)
+}
+
+// Moderation and administration
+
+func BenchmarkGetRole(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetRole(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkIsSuspended(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.IsSuspended(ctx, fixture.viewerId)
+		return err
+	})
+}
+
+func BenchmarkGetOpenReports(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetOpenReports(ctx, 20, 0)
+		return err
+	})
+}
+
+func BenchmarkGetResolvedReports(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetResolvedReports(ctx, fixture.viewerId, time.Now().AddDate(0, 0, -30))
+		return err
+	})
+}
+
+func BenchmarkGetModerationLog(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetModerationLog(ctx, 20, 0)
+		return err
+	})
+}
+
+func BenchmarkGetWarnings(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetWarnings(ctx, fixture.viewerId, time.Now().AddDate(0, 0, -30))
+		return err
+	})
+}
+
+func BenchmarkGetStats(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetStats(ctx)
+		return err
+	})
+}
+
+func BenchmarkGetDailyCounts(b *testing.B) {
+	benchmark(b, func() error {
+		_, err := model.GetDailyCounts(ctx, 14)
+		return err
+	})
+}
@@ -0,0 +1,179 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+var ErrBlocked = errors.New("one of these users has blocked the other")
+
+// blockedBetween reports whether either user has blocked the other.
+func blockedBetween(ctx context.Context, userId, otherUserId int64) (bool, error) {
+	var blocked bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM blocks b
+		WHERE (b.blocker = $1 AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = $1)
+		)`, userId, otherUserId).Scan(&blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return false, err
+	}
+	return blocked, nil
+}
+
+// blockedFromTweet reports whether userId and the author of tweetId have
+// blocked one another.
+func blockedFromTweet(ctx context.Context, userId, tweetId int64) (bool, error) {
+	var blocked bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM blocks b
+		INNER JOIN tweets t
+		ON t.id = $2
+		WHERE (b.blocker = $1 AND b.blocked = t.user_id) OR (b.blocker = t.user_id AND b.blocked = $1)
+		)`, userId, tweetId).Scan(&blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return false, err
+	}
+	return blocked, nil
+}
+
+// CreateBlock blocks blocked on behalf of blocker and removes any follows or
+// follow requests between the two in either direction.
+func CreateBlock(ctx context.Context, blocker, blocked int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO blocks (blocker, blocked) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, blocker, blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM follows
+		WHERE (followed = $1 AND follower = $2) OR (followed = $2 AND follower = $1)`, blocker, blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM follow_requests
+		WHERE (requested = $1 AND requester = $2) OR (requested = $2 AND requester = $1)`, blocker, blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	// Neither user may keep the other on a List or subscribe to their Lists.
+	_, err = tx.ExecContext(ctx, `DELETE FROM list_members lm
+		USING lists l
+		WHERE l.id = lm.list_id
+		AND ((l.owner_id = $1 AND lm.user_id = $2) OR (l.owner_id = $2 AND lm.user_id = $1))`, blocker, blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM list_subscriptions ls
+		USING lists l
+		WHERE l.id = ls.list_id
+		AND ((l.owner_id = $1 AND ls.user_id = $2) OR (l.owner_id = $2 AND ls.user_id = $1))`, blocker, blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteBlock(ctx context.Context, blocker, blocked int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM blocks WHERE blocker = $1 AND blocked = $2`, blocker, blocked)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func CreateMute(ctx context.Context, muter, muted int64) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO mutes (muter, muted) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, muter, muted)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteMute(ctx context.Context, muter, muted int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM mutes WHERE muter = $1 AND muted = $2`, muter, muted)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func scanUserList(ctx context.Context, result *sql.Rows) []User {
+	var users []User
+	for result.Next() {
+		var id int64
+		var username string
+		var displayName, bio sql.NullString
+		err := result.Scan(&id, &username, &displayName, &bio)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		user := User{
+			Id: id,
+			Username: username,
+			DisplayName: nullStringToString(displayName),
+			Bio: nullStringToString(bio),
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+func GetBlockedUsers(ctx context.Context, userId int64) ([]User, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio
+		FROM blocks b
+		INNER JOIN users u
+		ON u.id = b.blocked
+		WHERE b.blocker = $1
+		ORDER BY b.created_at DESC`, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanUserList(ctx, result), nil
+}
+
+func GetMutedUsers(ctx context.Context, userId int64) ([]User, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio
+		FROM mutes m
+		INNER JOIN users u
+		ON u.id = m.muted
+		WHERE m.muter = $1
+		ORDER BY m.created_at DESC`, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanUserList(ctx, result), nil
+}
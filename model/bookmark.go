@@ -0,0 +1,98 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// CreateBookmark saves tweetId for userId. Bookmarks are private, so unlike
+// likes they are not subject to blocks: a Tweet you can no longer see simply
+// drops out of GetBookmarks.
+func CreateBookmark(ctx context.Context, userId, tweetId int64) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO bookmarks (user_id, tweet_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, userId, tweetId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteBookmark(ctx context.Context, userId, tweetId int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM bookmarks WHERE user_id = $1 AND tweet_id = $2`, userId, tweetId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// likePattern turns a search query into an ILIKE pattern that matches it
+// anywhere, treating % and _ in the query literally.
+func likePattern(query string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(query) + "%"
+}
+
+// GetBookmarks returns userId's bookmarks, most recently saved first,
+// optionally filtered to Tweets whose text contains query.
+func GetBookmarks(ctx context.Context, userId int64, query string, limit, offset int) ([]Tweet, error) {
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text, u.username, t.created_at,
+		(l.tweet_id IS NOT NULL) AS liked,
+		(e.tweet_id IS NOT NULL) AS retweeted,
+		u.avatar_url
+		FROM bookmarks bm
+			INNER JOIN tweets t
+			ON bm.tweet_id = t.id
+			INNER JOIN users u
+			ON t.user_id = u.id
+			LEFT JOIN likes l
+			ON l.tweet_id = t.id AND l.user_id = $1
+			LEFT JOIN retweets e
+			ON e.user_id = $1 AND e.tweet_id = t.id
+		WHERE bm.user_id = $1
+		AND t.text ILIKE $2
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR u.id = $1 OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $1
+		))
+		ORDER BY bm.created_at DESC, t.id DESC
+		LIMIT $3 OFFSET $4`, userId, likePattern(query), limit, offset)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var tweets []Tweet
+	for result.Next() {
+		var id int64
+		var text, username, createdAt string
+		var liked, retweeted bool
+		var avatarURL sql.NullString
+		err := result.Scan(&id, &text, &username, &createdAt, &liked, &retweeted, &avatarURL)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		tweet := Tweet{
+			Id: id,
+			Text: text,
+			Username: username,
+			Date: createdAt,
+			Liked: liked,
+			Retweeted: retweeted,
+			Bookmarked: true,
+			AvatarURL: nullStringToString(avatarURL),
+		}
+		tweets = append(tweets, tweet)
+	}
+	return tweets, nil
+}
@@ -0,0 +1,327 @@
+package model
+
+import (
+	"context"
+	"time"
+	"database/sql"
+	pq "github.com/lib/pq"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+const (
+	EXPORT_PENDING = "pending"
+	EXPORT_READY = "ready"
+	EXPORT_FAILED = "failed"
+)
+
+// DataExport is a "download your data" archive. ObjectName is where the zip
+// is stored once it is ready.
+type DataExport struct {
+	Id int64
+	UserId int64
+	Status string
+	ObjectName string
+	CreatedAt time.Time
+	CompletedAt time.Time
+}
+
+// Archive is everything a user has put into the service, in the shape it is
+// written to their export.
+type Archive struct {
+	Account ArchiveAccount `json:"account"`
+	Tweets []ArchiveTweet `json:"tweets"`
+	Likes []ArchiveTweet `json:"likes"`
+	Retweets []ArchiveTweet `json:"retweets"`
+	Bookmarks []ArchiveTweet `json:"bookmarks"`
+	Following []string `json:"following"`
+	Followers []string `json:"followers"`
+	Blocking []string `json:"blocking"`
+	Muting []string `json:"muting"`
+	Lists []ArchiveList `json:"lists"`
+	Messages []ArchiveMessage `json:"messages"`
+}
+
+type ArchiveAccount struct {
+	Id int64 `json:"id"`
+	Username string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio string `json:"bio,omitempty"`
+	Website string `json:"website,omitempty"`
+	Location string `json:"location,omitempty"`
+	Email string `json:"email,omitempty"`
+	EmailVerified bool `json:"email_verified"`
+	Protected bool `json:"protected"`
+	CreatedAt string `json:"created_at"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	BannerURL string `json:"banner_url,omitempty"`
+}
+
+// ArchiveTweet is a Tweet the user wrote, or one they liked, retweeted or
+// bookmarked. Username is its author and Date when the user acted on it.
+type ArchiveTweet struct {
+	Id int64 `json:"id"`
+	Username string `json:"username"`
+	Text string `json:"text"`
+	ImageURL string `json:"image_url,omitempty"`
+	ParentId int64 `json:"in_reply_to,omitempty"`
+	Date string `json:"date"`
+}
+
+type ArchiveList struct {
+	Name string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Private bool `json:"private"`
+	Members []string `json:"members"`
+}
+
+type ArchiveMessage struct {
+	ConversationId int64 `json:"conversation_id"`
+	Sender string `json:"sender"`
+	Text string `json:"text"`
+	Date string `json:"date"`
+}
+
+func CreateDataExport(ctx context.Context, userId int64) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, `INSERT INTO data_exports (user_id) VALUES ($1) RETURNING id`, userId).Scan(&id)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, err
+	}
+	return id, nil
+}
+
+func CompleteDataExport(ctx context.Context, exportId int64, objectName string) error {
+	_, err := db.ExecContext(ctx, `UPDATE data_exports SET status = $2, object_name = $3, completed_at = now()
+		WHERE id = $1`, exportId, EXPORT_READY, objectName)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func FailDataExport(ctx context.Context, exportId int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE data_exports SET status = $2, completed_at = now()
+		WHERE id = $1`, exportId, EXPORT_FAILED)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func scanDataExports(ctx context.Context, result *sql.Rows) []DataExport {
+	var exports []DataExport
+	for result.Next() {
+		var export DataExport
+		var objectName sql.NullString
+		var completedAt pq.NullTime
+		err := result.Scan(&export.Id, &export.UserId, &export.Status, &objectName, &export.CreatedAt, &completedAt)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		export.ObjectName = nullStringToString(objectName)
+		export.CompletedAt = completedAt.Time
+		exports = append(exports, export)
+	}
+	return exports
+}
+
+func queryDataExports(ctx context.Context, query string, args ...interface{}) ([]DataExport, error) {
+	result, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanDataExports(ctx, result), nil
+}
+
+// GetLatestDataExport returns userId's most recent export, or sql.ErrNoRows
+// if they have never asked for one.
+func GetLatestDataExport(ctx context.Context, userId int64) (DataExport, error) {
+	exports, err := queryDataExports(ctx, `SELECT id, user_id, status, object_name, created_at, completed_at
+		FROM data_exports WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1`, userId)
+	if err != nil {
+		return DataExport{}, err
+	}
+	if len(exports) == 0 {
+		return DataExport{}, sql.ErrNoRows
+	}
+	return exports[0], nil
+}
+
+// GetExpiredDataExports returns exports requested before the given time.
+func GetExpiredDataExports(ctx context.Context, before time.Time) ([]DataExport, error) {
+	return queryDataExports(ctx, `SELECT id, user_id, status, object_name, created_at, completed_at
+		FROM data_exports WHERE created_at < $1`, before)
+}
+
+func DeleteDataExport(ctx context.Context, exportId int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM data_exports WHERE id = $1`, exportId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	result, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var values []string
+	for result.Next() {
+		var value string
+		err := result.Scan(&value)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func queryArchiveTweets(ctx context.Context, query string, userId int64) ([]ArchiveTweet, error) {
+	result, err := db.QueryContext(ctx, query, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var tweets []ArchiveTweet
+	for result.Next() {
+		var tweet ArchiveTweet
+		var imageURL sql.NullString
+		var parentId sql.NullInt64
+		err := result.Scan(&tweet.Id, &tweet.Username, &tweet.Text, &imageURL, &parentId, &tweet.Date)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		tweet.ImageURL = nullStringToString(imageURL)
+		tweet.ParentId = nullInt64ToInt64(parentId)
+		tweets = append(tweets, tweet)
+	}
+	return tweets, nil
+}
+
+// GetArchive collects everything userId has put into the service for their
+// data export. Unlike the timelines it applies no block or mute filters.
+func GetArchive(ctx context.Context, userId int64) (Archive, error) {
+	var archive Archive
+	var account ArchiveAccount
+	var displayName, bio, website, location, email, avatarURL, bannerURL sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT id, username, display_name, bio, website, location, email, email_verified, protected,
+		created_at, avatar_url, banner_url
+		FROM users WHERE id = $1`, userId).Scan(&account.Id, &account.Username, &displayName, &bio, &website,
+		&location, &email, &account.EmailVerified, &account.Protected, &account.CreatedAt, &avatarURL, &bannerURL)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return archive, err
+	}
+	account.DisplayName = nullStringToString(displayName)
+	account.Bio = nullStringToString(bio)
+	account.Website = nullStringToString(website)
+	account.Location = nullStringToString(location)
+	account.Email = nullStringToString(email)
+	account.AvatarURL = nullStringToString(avatarURL)
+	account.BannerURL = nullStringToString(bannerURL)
+	archive.Account = account
+
+	tweetQueries := []struct {
+		dest *[]ArchiveTweet
+		query string
+	}{
+		{&archive.Tweets, `SELECT t.id, u.username, t.text, t.image_url, t.parent_id, t.created_at
+			FROM tweets t INNER JOIN users u ON u.id = t.user_id
+			WHERE t.user_id = $1 ORDER BY t.created_at`},
+		{&archive.Likes, `SELECT t.id, u.username, t.text, t.image_url, t.parent_id, l.created_at
+			FROM likes l INNER JOIN tweets t ON t.id = l.tweet_id INNER JOIN users u ON u.id = t.user_id
+			WHERE l.user_id = $1 ORDER BY l.created_at`},
+		{&archive.Retweets, `SELECT t.id, u.username, t.text, t.image_url, t.parent_id, r.created_at
+			FROM retweets r INNER JOIN tweets t ON t.id = r.tweet_id INNER JOIN users u ON u.id = t.user_id
+			WHERE r.user_id = $1 ORDER BY r.created_at`},
+		{&archive.Bookmarks, `SELECT t.id, u.username, t.text, t.image_url, t.parent_id, bm.created_at
+			FROM bookmarks bm INNER JOIN tweets t ON t.id = bm.tweet_id INNER JOIN users u ON u.id = t.user_id
+			WHERE bm.user_id = $1 ORDER BY bm.created_at`},
+	}
+	for _, q := range tweetQueries {
+		*q.dest, err = queryArchiveTweets(ctx, q.query, userId)
+		if err != nil {
+			return archive, err
+		}
+	}
+
+	usernameQueries := []struct {
+		dest *[]string
+		query string
+	}{
+		{&archive.Following, `SELECT u.username FROM follows f INNER JOIN users u ON u.id = f.followed
+			WHERE f.follower = $1 ORDER BY f.created_at`},
+		{&archive.Followers, `SELECT u.username FROM follows f INNER JOIN users u ON u.id = f.follower
+			WHERE f.followed = $1 ORDER BY f.created_at`},
+		{&archive.Blocking, `SELECT u.username FROM blocks b INNER JOIN users u ON u.id = b.blocked
+			WHERE b.blocker = $1 ORDER BY b.created_at`},
+		{&archive.Muting, `SELECT u.username FROM mutes m INNER JOIN users u ON u.id = m.muted
+			WHERE m.muter = $1 ORDER BY m.created_at`},
+	}
+	for _, q := range usernameQueries {
+		*q.dest, err = queryStrings(ctx, q.query, userId)
+		if err != nil {
+			return archive, err
+		}
+	}
+
+	lists, err := GetOwnedLists(ctx, userId, userId)
+	if err != nil {
+		return archive, err
+	}
+	for _, list := range lists {
+		members, err := queryStrings(ctx, `SELECT u.username FROM list_members lm INNER JOIN users u ON u.id = lm.user_id
+			WHERE lm.list_id = $1 ORDER BY lm.created_at`, list.Id)
+		if err != nil {
+			return archive, err
+		}
+		archive.Lists = append(archive.Lists, ArchiveList{
+			Name: list.Name,
+			Description: list.Description,
+			Private: list.Private,
+			Members: members,
+		})
+	}
+
+	result, err := db.QueryContext(ctx, `SELECT m.conversation_id, u.username, m.text, m.created_at
+		FROM messages m
+		INNER JOIN users u
+		ON u.id = m.sender_id
+		WHERE m.conversation_id IN (SELECT conversation_id FROM conversations_users WHERE user_id = $1)
+		ORDER BY m.conversation_id, m.created_at`, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return archive, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var message ArchiveMessage
+		var text sql.NullString
+		err := result.Scan(&message.ConversationId, &message.Sender, &text, &message.Date)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		message.Text = nullStringToString(text)
+		archive.Messages = append(archive.Messages, message)
+	}
+	return archive, nil
+}
@@ -0,0 +1,227 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// UserListItem is a row in a list of accounts, annotated with how the
+// viewing user relates to it.
+type UserListItem struct {
+	Id int64 `json:"id"`
+	Username string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Bio string `json:"bio"`
+	Following bool `json:"following"`
+	FollowsYou bool `json:"follows_you"`
+}
+
+// CanSeeTweets reports whether currentUserId may see userId's Tweets and
+// connections: neither has blocked the other, and userId is not protected
+// or is followed by currentUserId.
+func CanSeeTweets(ctx context.Context, userId, currentUserId int64) (bool, error) {
+	var canSee bool
+	// The EXISTS comes first so that it fixes the parameters' types.
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1) AND ` + canSeeUser,
+		userId, currentUserId).Scan(&canSee)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return false, err
+	}
+	return canSee, nil
+}
+
+func DeleteFollow(ctx context.Context, followed, follower int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM follows WHERE followed = $1 AND follower = $2`, followed, follower)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func scanUserListItems(ctx context.Context, result *sql.Rows) []UserListItem {
+	var users []UserListItem
+	for result.Next() {
+		var id int64
+		var username string
+		var displayName, bio sql.NullString
+		var following, followsYou bool
+		err := result.Scan(&id, &username, &displayName, &bio, &following, &followsYou)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		user := UserListItem{
+			Id: id,
+			Username: username,
+			DisplayName: nullStringToString(displayName),
+			Bio: nullStringToString(bio),
+			Following: following,
+			FollowsYou: followsYou,
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// GetFollowers lists the accounts following userId, most recent first.
+func GetFollowers(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio,
+		EXISTS (SELECT 1 FROM follows v WHERE v.followed = u.id AND v.follower = $2) AS following,
+		EXISTS (SELECT 1 FROM follows v WHERE v.followed = $2 AND v.follower = u.id) AS follows_you
+		FROM follows f
+		INNER JOIN users u
+		ON u.id = f.follower
+		WHERE f.followed = $1
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = u.id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = u.id)
+		)
+		ORDER BY f.created_at DESC
+		LIMIT $3 OFFSET $4`, userId, currentUserId, limit, offset)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanUserListItems(ctx, result), nil
+}
+
+// GetFollowing lists the accounts userId follows, most recent first.
+func GetFollowing(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio,
+		EXISTS (SELECT 1 FROM follows v WHERE v.followed = u.id AND v.follower = $2) AS following,
+		EXISTS (SELECT 1 FROM follows v WHERE v.followed = $2 AND v.follower = u.id) AS follows_you
+		FROM follows f
+		INNER JOIN users u
+		ON u.id = f.followed
+		WHERE f.follower = $1
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = u.id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = u.id)
+		)
+		ORDER BY f.created_at DESC
+		LIMIT $3 OFFSET $4`, userId, currentUserId, limit, offset)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanUserListItems(ctx, result), nil
+}
+
+// GetMutualFollowers returns up to limit accounts that follow userId and are
+// followed by currentUserId, along with how many such accounts there are.
+func GetMutualFollowers(ctx context.Context, userId, currentUserId int64, limit int) ([]User, int64, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio, COUNT(*) OVER ()
+		FROM follows a
+		INNER JOIN follows b
+		ON b.followed = a.follower AND b.follower = $2
+		INNER JOIN users u
+		ON u.id = a.follower
+		WHERE a.followed = $1
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		ORDER BY b.created_at DESC
+		LIMIT $3`, userId, currentUserId, limit)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, 0, err
+	}
+	defer result.Close()
+
+	var users []User
+	var count int64
+	for result.Next() {
+		var id int64
+		var username string
+		var displayName, bio sql.NullString
+		err := result.Scan(&id, &username, &displayName, &bio, &count)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		user := User{
+			Id: id,
+			Username: username,
+			DisplayName: nullStringToString(displayName),
+			Bio: nullStringToString(bio),
+		}
+		users = append(users, user)
+	}
+	return users, count, nil
+}
+
+// FollowGraphStats describes the shape of the follow graph.
+type FollowGraphStats struct {
+	Users int64
+	Follows int64
+	MutualPairs int64
+	NoFollowers int64
+	NoFollowing int64
+	MedianFollowers float64
+	TopFollowed []FollowCount
+}
+
+// FollowCount is a user and how many followers they have.
+type FollowCount struct {
+	Username string
+	Followers int64
+}
+
+// GetFollowGraphStats summarises the follow graph, listing the top most
+// followed accounts.
+func GetFollowGraphStats(ctx context.Context, top int) (FollowGraphStats, error) {
+	var stats FollowGraphStats
+	err := db.QueryRowContext(ctx, `WITH counts AS (
+			SELECT u.id,
+			(SELECT COUNT(*) FROM follows WHERE followed = u.id) AS followers,
+			(SELECT COUNT(*) FROM follows WHERE follower = u.id) AS following
+			FROM users u
+		)
+		SELECT
+		(SELECT COUNT(*) FROM counts),
+		(SELECT COUNT(*) FROM follows),
+		(SELECT COUNT(*) FROM follows a
+			INNER JOIN follows b
+			ON b.follower = a.followed AND b.followed = a.follower
+			WHERE a.follower < a.followed),
+		(SELECT COUNT(*) FROM counts WHERE followers = 0),
+		(SELECT COUNT(*) FROM counts WHERE following = 0),
+		COALESCE((SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY followers) FROM counts), 0)`).Scan(
+		&stats.Users, &stats.Follows, &stats.MutualPairs, &stats.NoFollowers, &stats.NoFollowing, &stats.MedianFollowers)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return FollowGraphStats{}, err
+	}
+
+	result, err := db.QueryContext(ctx, `SELECT u.username, COUNT(*) AS followers
+		FROM follows f
+		INNER JOIN users u
+		ON u.id = f.followed
+		GROUP BY u.id
+		ORDER BY followers DESC, u.id ASC
+		LIMIT $1`, top)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return FollowGraphStats{}, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var count FollowCount
+		err := result.Scan(&count.Username, &count.Followers)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		stats.TopFollowed = append(stats.TopFollowed, count)
+	}
+	return stats, nil
+}
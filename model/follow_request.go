@@ -0,0 +1,115 @@
+package model
+
+import (
+	"context"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+func CreateFollowRequest(ctx context.Context, requested, requester int64) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO follow_requests (requested, requester) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, requested, requester)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteFollowRequest(ctx context.Context, requested, requester int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM follow_requests WHERE requested = $1 AND requester = $2`, requested, requester)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// ApproveFollowRequest turns a pending request into a follow. It is a no-op
+// when requester has not asked to follow requested.
+func ApproveFollowRequest(ctx context.Context, requested, requester int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO follows (followed, follower)
+		SELECT requested, requester FROM follow_requests
+		WHERE requested = $1 AND requester = $2
+		ON CONFLICT DO NOTHING`, requested, requester)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM follow_requests WHERE requested = $1 AND requester = $2`, requested, requester)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// ApproveAllFollowRequests is used when an account stops being protected.
+func ApproveAllFollowRequests(ctx context.Context, requested int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO follows (followed, follower)
+		SELECT requested, requester FROM follow_requests
+		WHERE requested = $1
+		ON CONFLICT DO NOTHING`, requested)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM follow_requests WHERE requested = $1`, requested)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func GetFollowRequests(ctx context.Context, userId int64) ([]User, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio
+		FROM follow_requests fr
+		INNER JOIN users u
+		ON u.id = fr.requester
+		WHERE fr.requested = $1
+		ORDER BY fr.created_at DESC`, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanUserList(ctx, result), nil
+}
+
+func CountFollowRequests(ctx context.Context, userId int64) (int64, error) {
+	var count int64
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM follow_requests WHERE requested = $1`, userId).Scan(&count)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, err
+	}
+	return count, nil
+}
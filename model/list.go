@@ -0,0 +1,340 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// List is a curated set of accounts whose Tweets make up their own timeline.
+// Private lists are only visible to their owner. Subscribed is whether the
+// viewing user subscribes to the list.
+type List struct {
+	Id int64
+	OwnerId int64
+	OwnerUsername string
+	Name string
+	Description string
+	Private bool
+	Members int64
+	Subscribers int64
+	Subscribed bool
+}
+
+// listColumns selects a List as seen by the user in $1.
+const listColumns = `l.id, l.owner_id, o.username, l.name, l.description, l.private,
+	(SELECT COUNT(*) FROM list_members m WHERE m.list_id = l.id) AS members,
+	(SELECT COUNT(*) FROM list_subscriptions s WHERE s.list_id = l.id) AS subscribers,
+	EXISTS (SELECT 1 FROM list_subscriptions s WHERE s.list_id = l.id AND s.user_id = $1) AS subscribed`
+
+// listVisible hides private lists from everyone but their owner, and lists
+// whose owner has blocked or been blocked by the user in $1.
+const listVisible = `(NOT l.private OR l.owner_id = $1)
+	AND NOT EXISTS (
+		SELECT 1 FROM blocks b
+		WHERE (b.blocker = l.owner_id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = l.owner_id)
+	)`
+
+func scanList(row interface{ Scan(...interface{}) error }) (List, error) {
+	var list List
+	var description sql.NullString
+	err := row.Scan(&list.Id, &list.OwnerId, &list.OwnerUsername, &list.Name, &description,
+		&list.Private, &list.Members, &list.Subscribers, &list.Subscribed)
+	list.Description = nullStringToString(description)
+	return list, err
+}
+
+func scanLists(ctx context.Context, result *sql.Rows) []List {
+	var lists []List
+	for result.Next() {
+		list, err := scanList(result)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		lists = append(lists, list)
+	}
+	return lists
+}
+
+func queryLists(ctx context.Context, query string, args ...interface{}) ([]List, error) {
+	result, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanLists(ctx, result), nil
+}
+
+func CreateList(ctx context.Context, ownerId int64, name, description string, private bool) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, `INSERT INTO lists (owner_id, name, description, private)
+		VALUES ($1, $2, $3, $4) RETURNING id`, ownerId, name, description, private).Scan(&id)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateList edits a list owned by ownerId. Making a list private drops its
+// subscribers, since they can no longer see it.
+func UpdateList(ctx context.Context, listId, ownerId int64, name, description string, private bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE lists SET name = $3, description = $4, private = $5
+		WHERE id = $1 AND owner_id = $2`, listId, ownerId, name, description, private)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	if private {
+		_, err = tx.ExecContext(ctx, `DELETE FROM list_subscriptions WHERE list_id = $1`, listId)
+		if err != nil {
+			logging.Error(ctx, "Query failed.", "err", err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteList(ctx context.Context, listId, ownerId int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM lists WHERE id = $1 AND owner_id = $2`, listId, ownerId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetList returns listId as seen by currentUserId, or sql.ErrNoRows when it
+// does not exist or is not visible to them.
+func GetList(ctx context.Context, listId, currentUserId int64) (List, error) {
+	row := db.QueryRowContext(ctx, `SELECT ` + listColumns + `
+		FROM lists l
+		INNER JOIN users o
+		ON o.id = l.owner_id
+		WHERE l.id = $2
+		AND ` + listVisible, currentUserId, listId)
+	list, err := scanList(row)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Error(ctx, "Query failed.", "err", err)
+	}
+	return list, err
+}
+
+// GetOwnedLists returns the lists ownerId has made that currentUserId may see.
+func GetOwnedLists(ctx context.Context, ownerId, currentUserId int64) ([]List, error) {
+	return queryLists(ctx, `SELECT ` + listColumns + `
+		FROM lists l
+		INNER JOIN users o
+		ON o.id = l.owner_id
+		WHERE l.owner_id = $2
+		AND ` + listVisible + `
+		ORDER BY l.created_at DESC`, currentUserId, ownerId)
+}
+
+// GetSubscribedLists returns the lists userId subscribes to.
+func GetSubscribedLists(ctx context.Context, userId int64) ([]List, error) {
+	return queryLists(ctx, `SELECT ` + listColumns + `
+		FROM list_subscriptions ls
+		INNER JOIN lists l
+		ON l.id = ls.list_id
+		INNER JOIN users o
+		ON o.id = l.owner_id
+		WHERE ls.user_id = $1
+		AND ` + listVisible + `
+		ORDER BY ls.created_at DESC`, userId)
+}
+
+// GetListMemberships returns the public lists other people have added userId
+// to. Private lists stay hidden from their members.
+func GetListMemberships(ctx context.Context, userId int64) ([]List, error) {
+	return queryLists(ctx, `SELECT ` + listColumns + `
+		FROM list_members lm
+		INNER JOIN lists l
+		ON l.id = lm.list_id
+		INNER JOIN users o
+		ON o.id = l.owner_id
+		WHERE lm.user_id = $1
+		AND l.owner_id != $1
+		AND ` + listVisible + `
+		ORDER BY lm.created_at DESC`, userId)
+}
+
+// AddListMember adds userId to a list owned by ownerId. Accounts that have
+// blocked, or been blocked by, the owner cannot be added.
+func AddListMember(ctx context.Context, listId, ownerId, userId int64) error {
+	blocked, err := blockedBetween(ctx, ownerId, userId)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	result, err := db.ExecContext(ctx, `INSERT INTO list_members (list_id, user_id)
+		SELECT id, $3 FROM lists WHERE id = $1 AND owner_id = $2
+		ON CONFLICT DO NOTHING`, listId, ownerId, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		var exists bool
+		err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM lists WHERE id = $1 AND owner_id = $2)`,
+			listId, ownerId).Scan(&exists)
+		if err != nil {
+			logging.Error(ctx, "Query failed.", "err", err)
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+func RemoveListMember(ctx context.Context, listId, ownerId, userId int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM list_members lm
+		USING lists l
+		WHERE l.id = lm.list_id AND l.id = $1 AND l.owner_id = $2 AND lm.user_id = $3`, listId, ownerId, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetListMembers lists the members of listId, most recently added first,
+// annotated for currentUserId.
+func GetListMembers(ctx context.Context, listId, currentUserId int64, limit, offset int) ([]UserListItem, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio,
+		EXISTS (SELECT 1 FROM follows v WHERE v.followed = u.id AND v.follower = $2) AS following,
+		EXISTS (SELECT 1 FROM follows v WHERE v.followed = $2 AND v.follower = u.id) AS follows_you
+		FROM list_members lm
+		INNER JOIN users u
+		ON u.id = lm.user_id
+		WHERE lm.list_id = $1
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = u.id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = u.id)
+		)
+		ORDER BY lm.created_at DESC
+		LIMIT $3 OFFSET $4`, listId, currentUserId, limit, offset)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanUserListItems(ctx, result), nil
+}
+
+// SubscribeList subscribes userId to a public list they do not own.
+func SubscribeList(ctx context.Context, listId, userId int64) error {
+	result, err := db.ExecContext(ctx, `INSERT INTO list_subscriptions (list_id, user_id)
+		SELECT l.id, $1 FROM lists l
+		WHERE l.id = $2 AND NOT l.private AND l.owner_id != $1
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = l.owner_id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = l.owner_id)
+		)
+		ON CONFLICT DO NOTHING`, userId, listId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_, err = GetList(ctx, listId, userId)
+		return err
+	}
+	return nil
+}
+
+func UnsubscribeList(ctx context.Context, listId, userId int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM list_subscriptions WHERE list_id = $1 AND user_id = $2`, listId, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetListFeed is GetFeed for a list: the Tweets of its members, newest first,
+// as seen by currentUserId. Callers check the list is visible with GetList.
+func GetListFeed(ctx context.Context, listId, currentUserId int64) ([]Tweet, error) {
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text, t.created_at, u.username,
+		(l.user_id IS NOT NULL) AS liked,
+		(r.user_id IS NOT NULL) AS retweeted,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $1) AS bookmarked,
+		u.avatar_url
+		FROM tweets t
+		INNER JOIN list_members lm
+		ON t.user_id = lm.user_id AND lm.list_id = $2
+		INNER JOIN users u
+		ON u.id = t.user_id
+		LEFT JOIN likes l
+		ON l.tweet_id = t.id AND l.user_id = $1
+		LEFT JOIN retweets r
+		ON r.tweet_id = t.id AND r.user_id = $1
+		WHERE NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter = $1 AND m.muted = t.user_id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR u.id = $1 OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $1
+		))
+		ORDER BY t.created_at DESC
+		LIMIT 200`, currentUserId, listId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var tweets []Tweet
+	for result.Next() {
+		var id int64
+		var text, createdAt, username string
+		var liked, retweeted, bookmarked bool
+		var avatarURL sql.NullString
+		err := result.Scan(&id, &text, &createdAt, &username, &liked, &retweeted, &bookmarked, &avatarURL)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		tweet := Tweet{
+			Id: id,
+			Text: text,
+			Username: username,
+			Date: createdAt,
+			Liked: liked,
+			Retweeted: retweeted,
+			Bookmarked: bookmarked,
+			AvatarURL: nullStringToString(avatarURL),
+		}
+		tweets = append(tweets, tweet)
+	}
+	return tweets, nil
+}
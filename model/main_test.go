@@ -0,0 +1,83 @@
+package model_test
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"time"
+	"strings"
+	"context"
+	"testing"
+	"net/url"
+	"database/sql"
+	_ "github.com/lib/pq"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+// The Postgres tests and benchmarks are skipped unless TEST_DATABASE_URL
+// names a database they may create schemas in, as for the integration
+// tests. They share one schema, dropped when they finish.
+const TEST_DATABASE_ENV = "TEST_DATABASE_URL"
+
+// haveDatabase is set when tests may use Postgres.
+var haveDatabase bool
+
+var ctx = context.Background()
+
+// withSearchPath points dsn at schema. lib/pq passes unknown settings on to
+// the server, so search_path applies to every connection in the pool.
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	dsn := os.Getenv(TEST_DATABASE_ENV)
+	if dsn == "" {
+		fixture.skip = TEST_DATABASE_ENV + " is not set"
+		os.Exit(m.Run())
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	schema := fmt.Sprintf("test_model_%d_%d", os.Getpid(), time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Setenv("DATABASE_URL", withSearchPath(dsn, schema))
+	os.Unsetenv("POSTGRES_USER")
+	model.InitDB()
+	haveDatabase = true
+	if flag.Lookup("test.bench").Value.String() != "" {
+		err = setUp()
+		if err != nil {
+			fixture.skip = err.Error()
+		}
+	}
+
+	code := m.Run()
+
+	model.CloseDB()
+	_, err = admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not drop schema %s: %v\n", schema, err)
+	}
+	admin.Close()
+	os.Exit(code)
+}
@@ -0,0 +1,1027 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"database/sql"
+	"unicode/utf8"
+)
+
+// MemoryStore is a Store that keeps everything in process, for tests. It
+// mirrors the queries PostgresStore runs, including what each one leaves
+// out, e.g. GetFeed not returning display names. Accounts are never
+// deactivated or suspended.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// last is the most recent timestamp handed out, so that rows always
+	// sort in the order they were created.
+	last time.Time
+	users map[int64]*memoryUser
+	usernames map[string]int64
+	tweets map[int64]*memoryTweet
+	nextUserId int64
+	nextTweetId int64
+	// Pairs are keyed first user first: follows by {followed, follower},
+	// requests by {requested, requester}, blocks by {blocker, blocked} and
+	// mutes by {muter, muted}. Bookmarks are keyed by {user, Tweet}.
+	follows map[[2]int64]time.Time
+	requests map[[2]int64]time.Time
+	blocks map[[2]int64]time.Time
+	mutes map[[2]int64]time.Time
+	bookmarks map[[2]int64]time.Time
+	likes []engagement
+	retweets []engagement
+	conversations map[int64][]int64
+	messages []memoryMessage
+	nextConversationId int64
+	nextMessageId int64
+}
+
+type memoryUser struct {
+	User
+	createdAt time.Time
+	usernameChangedAt time.Time
+	sessionVersion int64
+}
+
+type memoryTweet struct {
+	id int64
+	userId int64
+	text string
+	imageURL string
+	parentId int64
+	createdAt time.Time
+}
+
+type engagement struct {
+	userId int64
+	tweetId int64
+	createdAt time.Time
+}
+
+type memoryMessage struct {
+	id int64
+	senderId int64
+	text string
+	conversationId int64
+	createdAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[int64]*memoryUser),
+		usernames: make(map[string]int64),
+		tweets: make(map[int64]*memoryTweet),
+		follows: make(map[[2]int64]time.Time),
+		requests: make(map[[2]int64]time.Time),
+		blocks: make(map[[2]int64]time.Time),
+		mutes: make(map[[2]int64]time.Time),
+		bookmarks: make(map[[2]int64]time.Time),
+		conversations: make(map[int64][]int64),
+	}
+}
+
+// now returns the current time, or just after the last one handed out.
+func (s *MemoryStore) now() time.Time {
+	now := time.Now()
+	if !now.After(s.last) {
+		now = s.last.Add(time.Microsecond)
+	}
+	s.last = now
+	return now
+}
+
+// formatTime renders times the way database/sql scans timestamps into
+// strings.
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// has reports whether pairs holds {first, second}.
+func has(pairs map[[2]int64]time.Time, first, second int64) bool {
+	_, ok := pairs[[2]int64{first, second}]
+	return ok
+}
+
+func (s *MemoryStore) blockedBetween(userId, otherUserId int64) bool {
+	return has(s.blocks, userId, otherUserId) || has(s.blocks, otherUserId, userId)
+}
+
+func (s *MemoryStore) following(followed, follower int64) bool {
+	_, ok := s.follows[[2]int64{followed, follower}]
+	return ok
+}
+
+// canSee reports whether userId may see Tweets by authorId.
+func (s *MemoryStore) canSee(authorId, userId int64) bool {
+	if s.blockedBetween(authorId, userId) {
+		return false
+	}
+	return !s.users[authorId].Protected || authorId == userId || s.following(authorId, userId)
+}
+
+func (s *MemoryStore) engaged(engagements []engagement, userId, tweetId int64) bool {
+	for _, e := range engagements {
+		if e.userId == userId && e.tweetId == tweetId {
+			return true
+		}
+	}
+	return false
+}
+
+// tweet renders t as seen by userId, with the fields every query returns.
+func (s *MemoryStore) tweet(t *memoryTweet, userId int64) Tweet {
+	author := s.users[t.userId]
+	return Tweet{
+		Id: t.id,
+		Username: author.Username,
+		Text: t.text,
+		Date: formatTime(t.createdAt),
+		Liked: s.engaged(s.likes, userId, t.id),
+		Retweeted: s.engaged(s.retweets, userId, t.id),
+		Bookmarked: has(s.bookmarks, userId, t.id),
+		AvatarURL: author.AvatarURL,
+	}
+}
+
+// newestFirst returns the Tweets matching keep, most recent first.
+func (s *MemoryStore) newestFirst(keep func(t *memoryTweet) bool) []*memoryTweet {
+	var tweets []*memoryTweet
+	for _, t := range s.tweets {
+		if keep(t) {
+			tweets = append(tweets, t)
+		}
+	}
+	sort.Slice(tweets, func(i, j int) bool {
+		return tweets[i].createdAt.After(tweets[j].createdAt)
+	})
+	return tweets
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, username, password string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.usernames[username]; ok {
+		return 0, fmt.Errorf("username %q is taken", username)
+	}
+	s.nextUserId++
+	createdAt := s.now()
+	s.users[s.nextUserId] = &memoryUser{
+		User: User{
+			Id: s.nextUserId,
+			Username: username,
+			Password: password,
+			CreatedAt: formatTime(createdAt),
+			Role: ROLE_USER,
+		},
+		createdAt: createdAt,
+	}
+	s.usernames[username] = s.nextUserId
+	return s.nextUserId, nil
+}
+
+func (s *MemoryStore) GetUserFromUsername(ctx context.Context, username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	userId, ok := s.usernames[username]
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+	return s.users[userId].User, nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, userId int64) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[userId]
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+	return user.User, nil
+}
+
+func (s *MemoryStore) GetUserIdFromUsername(ctx context.Context, username string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	userId, ok := s.usernames[username]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return userId, nil
+}
+
+func (s *MemoryStore) EditUser(ctx context.Context, edits User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[edits.Id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if user.Website != edits.Website {
+		user.WebsiteVerified = false
+	}
+	user.DisplayName = edits.DisplayName
+	user.Bio = edits.Bio
+	user.Location = edits.Location
+	user.Website = edits.Website
+	user.Protected = edits.Protected
+	user.Birthday = edits.Birthday
+
+	if !edits.Protected {
+		for request := range s.requests {
+			if request[0] == edits.Id {
+				if !s.following(request[0], request[1]) {
+					s.follows[request] = s.now()
+				}
+				delete(s.requests, request)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) IsSuspended(ctx context.Context, userId int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.users[userId]; !ok {
+		return false, sql.ErrNoRows
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) ChangeUsername(ctx context.Context, userId int64, username string, cooldown time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if username == user.Username {
+		return nil
+	}
+	if !user.usernameChangedAt.IsZero() && time.Since(user.usernameChangedAt) < cooldown {
+		return ErrUsernameCooldown
+	}
+	if _, ok := s.usernames[username]; ok {
+		return ErrUsernameTaken
+	}
+	delete(s.usernames, user.Username)
+	s.usernames[username] = userId
+	user.Username = username
+	user.usernameChangedAt = s.now()
+	user.WebsiteVerified = false
+	return nil
+}
+
+func (s *MemoryStore) ChangePassword(ctx context.Context, userId int64, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	user.Password = password
+	user.sessionVersion++
+	return nil
+}
+
+func (s *MemoryStore) GetSessionVersion(ctx context.Context, userId int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[userId]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return user.sessionVersion, nil
+}
+
+func (s *MemoryStore) SetEmail(ctx context.Context, userId int64, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, other := range s.users {
+		if email != "" && other.Id != userId && strings.EqualFold(other.Email, email) {
+			return ErrEmailTaken
+		}
+	}
+	user.Email = email
+	user.EmailVerified = false
+	return nil
+}
+
+// DeleteUser removes userId as the Postgres cascade does: their Tweets, with
+// replies to them detached, everything they took part in, and conversations
+// left empty.
+func (s *MemoryStore) DeleteUser(ctx context.Context, userId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userId]
+	if !ok {
+		return nil
+	}
+
+	for tweetId, t := range s.tweets {
+		if t.userId != userId {
+			continue
+		}
+		for _, reply := range s.tweets {
+			if reply.parentId == tweetId {
+				reply.parentId = 0
+			}
+		}
+		delete(s.tweets, tweetId)
+		s.likes = removeEngagements(s.likes, tweetId)
+		s.retweets = removeEngagements(s.retweets, tweetId)
+		for bookmark := range s.bookmarks {
+			if bookmark[1] == tweetId {
+				delete(s.bookmarks, bookmark)
+			}
+		}
+	}
+	s.likes = removeUserEngagements(s.likes, userId)
+	s.retweets = removeUserEngagements(s.retweets, userId)
+	for _, pairs := range []map[[2]int64]time.Time{s.follows, s.requests, s.blocks, s.mutes} {
+		for pair := range pairs {
+			if pair[0] == userId || pair[1] == userId {
+				delete(pairs, pair)
+			}
+		}
+	}
+	for bookmark := range s.bookmarks {
+		if bookmark[0] == userId {
+			delete(s.bookmarks, bookmark)
+		}
+	}
+
+	for conversationId, members := range s.conversations {
+		var kept []int64
+		for _, member := range members {
+			if member != userId {
+				kept = append(kept, member)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.conversations, conversationId)
+		} else {
+			s.conversations[conversationId] = kept
+		}
+	}
+	var messages []memoryMessage
+	for _, m := range s.messages {
+		if _, ok := s.conversations[m.conversationId]; ok && m.senderId != userId {
+			messages = append(messages, m)
+		}
+	}
+	s.messages = messages
+
+	delete(s.usernames, user.Username)
+	delete(s.users, userId)
+	return nil
+}
+
+func (s *MemoryStore) CreateTweet(ctx context.Context, request TweetRequest) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[request.UserId]; !ok {
+		return 0, fmt.Errorf("no user %d", request.UserId)
+	}
+	if request.ParentId != 0 {
+		parent, ok := s.tweets[request.ParentId]
+		if !ok {
+			return 0, fmt.Errorf("no Tweet %d", request.ParentId)
+		}
+		if s.blockedBetween(request.UserId, parent.userId) {
+			return 0, ErrBlocked
+		}
+	}
+	if utf8.RuneCountInString(request.Text) > 140 {
+		return 0, fmt.Errorf("Tweet is longer than 140 characters")
+	}
+
+	s.nextTweetId++
+	s.tweets[s.nextTweetId] = &memoryTweet{
+		id: s.nextTweetId,
+		userId: request.UserId,
+		text: request.Text,
+		imageURL: request.ImageURL,
+		parentId: request.ParentId,
+		createdAt: s.now(),
+	}
+	return s.nextTweetId, nil
+}
+
+func removeEngagements(engagements []engagement, tweetId int64) []engagement {
+	var kept []engagement
+	for _, e := range engagements {
+		if e.tweetId != tweetId {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func removeUserEngagements(engagements []engagement, userId int64) []engagement {
+	var kept []engagement
+	for _, e := range engagements {
+		if e.userId != userId {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func (s *MemoryStore) DeleteTweet(ctx context.Context, tweetId int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tweets[tweetId]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	for _, reply := range s.tweets {
+		if reply.parentId == tweetId {
+			reply.parentId = 0
+		}
+	}
+	delete(s.tweets, tweetId)
+	s.likes = removeEngagements(s.likes, tweetId)
+	s.retweets = removeEngagements(s.retweets, tweetId)
+	for bookmark := range s.bookmarks {
+		if bookmark[1] == tweetId {
+			delete(s.bookmarks, bookmark)
+		}
+	}
+	for _, user := range s.users {
+		if user.PinnedTweetId == tweetId {
+			user.PinnedTweetId = 0
+		}
+	}
+	return t.imageURL, nil
+}
+
+func (s *MemoryStore) GetTweet(ctx context.Context, tweetId, userId int64) (Tweet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tweets[tweetId]
+	if !ok || !s.canSee(t.userId, userId) {
+		return Tweet{}, sql.ErrNoRows
+	}
+	tweet := s.tweet(t, userId)
+	tweet.ImageURL = t.imageURL
+	tweet.DisplayName = s.users[t.userId].DisplayName
+	tweet.Pinned = s.users[t.userId].PinnedTweetId == t.id
+	return tweet, nil
+}
+
+func (s *MemoryStore) GetReplies(ctx context.Context, tweetId, userId int64) ([]Tweet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var replies []Tweet
+	for _, t := range s.newestFirst(func(t *memoryTweet) bool {
+		return t.parentId == tweetId && s.canSee(t.userId, userId)
+	}) {
+		reply := s.tweet(t, userId)
+		reply.ImageURL = t.imageURL
+		reply.DisplayName = s.users[t.userId].DisplayName
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+func (s *MemoryStore) GetFeed(ctx context.Context, userId int64) ([]Tweet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var tweets []Tweet
+	for _, t := range s.newestFirst(func(t *memoryTweet) bool {
+		return s.following(t.userId, userId) && !has(s.mutes, userId, t.userId) &&
+			!s.blockedBetween(t.userId, userId)
+	}) {
+		tweets = append(tweets, s.tweet(t, userId))
+	}
+	return tweets, nil
+}
+
+func (s *MemoryStore) GetHistory(ctx context.Context, userId, currentUserId int64) ([]Tweet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.canSee(userId, currentUserId) {
+		return nil, nil
+	}
+	var tweets []Tweet
+	for _, t := range s.newestFirst(func(t *memoryTweet) bool {
+		return (t.userId == userId || s.engaged(s.retweets, userId, t.id)) && s.canSee(t.userId, currentUserId)
+	}) {
+		tweet := s.tweet(t, currentUserId)
+		tweet.Pinned = s.users[t.userId].PinnedTweetId == t.id
+		tweets = append(tweets, tweet)
+	}
+	return tweets, nil
+}
+
+func (s *MemoryStore) GetLikes(ctx context.Context, userId, currentUserId int64) ([]Tweet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.canSee(userId, currentUserId) {
+		return nil, nil
+	}
+	var tweets []Tweet
+	for _, t := range s.newestFirst(func(t *memoryTweet) bool {
+		return s.engaged(s.likes, userId, t.id) && s.canSee(t.userId, currentUserId)
+	}) {
+		tweets = append(tweets, s.tweet(t, currentUserId))
+	}
+	return tweets, nil
+}
+
+func (s *MemoryStore) PinTweet(ctx context.Context, userId, tweetId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tweets[tweetId]
+	if !ok || t.userId != userId {
+		return sql.ErrNoRows
+	}
+	s.users[userId].PinnedTweetId = tweetId
+	return nil
+}
+
+func (s *MemoryStore) UnpinTweet(ctx context.Context, userId, tweetId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userId]; ok && user.PinnedTweetId == tweetId {
+		user.PinnedTweetId = 0
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateBookmark(ctx context.Context, userId, tweetId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tweets[tweetId]; !ok {
+		return fmt.Errorf("no Tweet %d", tweetId)
+	}
+	if !has(s.bookmarks, userId, tweetId) {
+		s.bookmarks[[2]int64{userId, tweetId}] = s.now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteBookmark(ctx context.Context, userId, tweetId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bookmarks, [2]int64{userId, tweetId})
+	return nil
+}
+
+func (s *MemoryStore) GetBookmarks(ctx context.Context, userId int64, query string, limit, offset int) ([]Tweet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	query = strings.ToLower(query)
+	var saved []*memoryTweet
+	for bookmark := range s.bookmarks {
+		t := s.tweets[bookmark[1]]
+		if bookmark[0] == userId && s.canSee(t.userId, userId) && strings.Contains(strings.ToLower(t.text), query) {
+			saved = append(saved, t)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool {
+		a, b := s.bookmarks[[2]int64{userId, saved[i].id}], s.bookmarks[[2]int64{userId, saved[j].id}]
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return saved[i].id > saved[j].id
+	})
+
+	var tweets []Tweet
+	for i := offset; i < len(saved) && i < offset + limit; i++ {
+		tweets = append(tweets, s.tweet(saved[i], userId))
+	}
+	return tweets, nil
+}
+
+// engage records a like or retweet. Repeating one succeeds without
+// recording it twice.
+func (s *MemoryStore) engage(engagements *[]engagement, userId, tweetId int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tweets[tweetId]
+	if !ok {
+		return false, fmt.Errorf("no Tweet %d", tweetId)
+	}
+	if s.blockedBetween(userId, t.userId) {
+		return false, ErrBlocked
+	}
+	if !s.engaged(*engagements, userId, tweetId) {
+		*engagements = append(*engagements, engagement{userId: userId, tweetId: tweetId, createdAt: s.now()})
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) CreateLike(ctx context.Context, userId, tweetId int64) (bool, error) {
+	return s.engage(&s.likes, userId, tweetId)
+}
+
+func (s *MemoryStore) CreateRetweet(ctx context.Context, userId, tweetId int64) (bool, error) {
+	return s.engage(&s.retweets, userId, tweetId)
+}
+
+func (s *MemoryStore) CreateFollow(ctx context.Context, followed, follower int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blockedBetween(followed, follower) {
+		return false, ErrBlocked
+	}
+	user, ok := s.users[followed]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	if user.Protected && followed != follower {
+		if _, ok := s.requests[[2]int64{followed, follower}]; !ok {
+			s.requests[[2]int64{followed, follower}] = s.now()
+		}
+		return false, nil
+	}
+	if !s.following(followed, follower) {
+		s.follows[[2]int64{followed, follower}] = s.now()
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteFollow(ctx context.Context, followed, follower int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.follows, [2]int64{followed, follower})
+	return nil
+}
+
+func (s *MemoryStore) ApproveFollowRequest(ctx context.Context, requested, requester int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request := [2]int64{requested, requester}
+	if _, ok := s.requests[request]; !ok {
+		return nil
+	}
+	if !s.following(requested, requester) {
+		s.follows[request] = s.now()
+	}
+	delete(s.requests, request)
+	return nil
+}
+
+func (s *MemoryStore) DeleteFollowRequest(ctx context.Context, requested, requester int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.requests, [2]int64{requested, requester})
+	return nil
+}
+
+// pairedUsers lists the second user of each of userId's pairs, most recent
+// first.
+func (s *MemoryStore) pairedUsers(pairs map[[2]int64]time.Time, userId int64) []User {
+	var others []int64
+	for pair := range pairs {
+		if pair[0] == userId {
+			others = append(others, pair[1])
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return pairs[[2]int64{userId, others[i]}].After(pairs[[2]int64{userId, others[j]}])
+	})
+
+	var users []User
+	for _, other := range others {
+		user := s.users[other]
+		users = append(users, User{
+			Id: user.Id,
+			Username: user.Username,
+			DisplayName: user.DisplayName,
+			Bio: user.Bio,
+		})
+	}
+	return users
+}
+
+func (s *MemoryStore) GetFollowRequests(ctx context.Context, userId int64) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pairedUsers(s.requests, userId), nil
+}
+
+func (s *MemoryStore) CountFollowRequests(ctx context.Context, userId int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var count int64
+	for request := range s.requests {
+		if request[0] == userId {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) CreateBlock(ctx context.Context, blocker, blocked int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !has(s.blocks, blocker, blocked) {
+		s.blocks[[2]int64{blocker, blocked}] = s.now()
+	}
+	for _, pair := range [][2]int64{{blocker, blocked}, {blocked, blocker}} {
+		delete(s.follows, pair)
+		delete(s.requests, pair)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteBlock(ctx context.Context, blocker, blocked int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blocks, [2]int64{blocker, blocked})
+	return nil
+}
+
+func (s *MemoryStore) CreateMute(ctx context.Context, muter, muted int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !has(s.mutes, muter, muted) {
+		s.mutes[[2]int64{muter, muted}] = s.now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteMute(ctx context.Context, muter, muted int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mutes, [2]int64{muter, muted})
+	return nil
+}
+
+func (s *MemoryStore) GetBlockedUsers(ctx context.Context, userId int64) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pairedUsers(s.blocks, userId), nil
+}
+
+func (s *MemoryStore) GetMutedUsers(ctx context.Context, userId int64) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pairedUsers(s.mutes, userId), nil
+}
+
+func (s *MemoryStore) CanSeeTweets(ctx context.Context, userId, currentUserId int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.users[userId]; !ok {
+		return false, nil
+	}
+	return s.canSee(userId, currentUserId), nil
+}
+
+func (s *MemoryStore) GetUsersRelationship(ctx context.Context, userId, currentUserId int64) (CrossUsers, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var relationship CrossUsers
+	for pair := range s.follows {
+		if pair[0] == userId {
+			relationship.Followers++
+		}
+		if pair[1] == userId {
+			relationship.Follows++
+		}
+	}
+	relationship.SecondFollowsFirst = s.following(userId, currentUserId)
+	relationship.FirstFollowsSecond = s.following(currentUserId, userId)
+	relationship.FirstBlocksSecond = has(s.blocks, userId, currentUserId)
+	relationship.SecondBlocksFirst = has(s.blocks, currentUserId, userId)
+	relationship.SecondMutesFirst = has(s.mutes, currentUserId, userId)
+	_, relationship.SecondRequestedFirst = s.requests[[2]int64{userId, currentUserId}]
+	return relationship, nil
+}
+
+// connections lists the accounts on one side of userId's follows, most
+// recently followed first. other picks that side out of a follow.
+func (s *MemoryStore) connections(matches func(pair [2]int64) bool, other func(pair [2]int64) int64, currentUserId int64, limit, offset int) []UserListItem {
+	var pairs [][2]int64
+	for pair := range s.follows {
+		if matches(pair) && !s.blockedBetween(other(pair), currentUserId) {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return s.follows[pairs[i]].After(s.follows[pairs[j]])
+	})
+
+	var users []UserListItem
+	for i := offset; i < len(pairs) && i < offset + limit; i++ {
+		user := s.users[other(pairs[i])]
+		users = append(users, UserListItem{
+			Id: user.Id,
+			Username: user.Username,
+			DisplayName: user.DisplayName,
+			Bio: user.Bio,
+			Following: s.following(user.Id, currentUserId),
+			FollowsYou: s.following(currentUserId, user.Id),
+		})
+	}
+	return users
+}
+
+func (s *MemoryStore) GetFollowers(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connections(func(pair [2]int64) bool {
+		return pair[0] == userId
+	}, func(pair [2]int64) int64 {
+		return pair[1]
+	}, currentUserId, limit, offset), nil
+}
+
+func (s *MemoryStore) GetFollowing(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connections(func(pair [2]int64) bool {
+		return pair[1] == userId
+	}, func(pair [2]int64) int64 {
+		return pair[0]
+	}, currentUserId, limit, offset), nil
+}
+
+func (s *MemoryStore) GetMutualFollowers(ctx context.Context, userId, currentUserId int64, limit int) ([]User, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var mutuals []int64
+	for pair := range s.follows {
+		if pair[0] == userId && s.following(pair[1], currentUserId) {
+			mutuals = append(mutuals, pair[1])
+		}
+	}
+	// Ordered by when currentUserId followed them.
+	sort.Slice(mutuals, func(i, j int) bool {
+		return s.follows[[2]int64{mutuals[i], currentUserId}].After(s.follows[[2]int64{mutuals[j], currentUserId}])
+	})
+
+	var users []User
+	for i := 0; i < len(mutuals) && i < limit; i++ {
+		user := s.users[mutuals[i]]
+		users = append(users, User{
+			Id: user.Id,
+			Username: user.Username,
+			DisplayName: user.DisplayName,
+			Bio: user.Bio,
+		})
+	}
+	return users, int64(len(mutuals)), nil
+}
+
+func (s *MemoryStore) GetTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found int64
+	for conversationId, members := range s.conversations {
+		onlyThem := true
+		hasUser, hasCurrentUser := false, false
+		for _, member := range members {
+			hasUser = hasUser || member == userId
+			hasCurrentUser = hasCurrentUser || member == currentUserId
+			onlyThem = onlyThem && (member == userId || member == currentUserId)
+		}
+		if onlyThem && hasUser && hasCurrentUser && (found == 0 || conversationId < found) {
+			found = conversationId
+		}
+	}
+	return found, nil
+}
+
+func (s *MemoryStore) CreateTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blockedBetween(userId, currentUserId) {
+		return 0, ErrBlocked
+	}
+	s.nextConversationId++
+	s.conversations[s.nextConversationId] = []int64{userId, currentUserId}
+	return s.nextConversationId, nil
+}
+
+func (s *MemoryStore) CreateMessage(ctx context.Context, request MessageRequest) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	member := false
+	for _, userId := range s.conversations[request.ConversationId] {
+		if userId == request.SenderId {
+			member = true
+		} else if s.blockedBetween(userId, request.SenderId) {
+			return 0, sql.ErrNoRows
+		}
+	}
+	if !member {
+		return 0, sql.ErrNoRows
+	}
+	s.nextMessageId++
+	s.messages = append(s.messages, memoryMessage{
+		id: s.nextMessageId,
+		senderId: request.SenderId,
+		text: request.Text,
+		conversationId: request.ConversationId,
+		createdAt: s.now(),
+	})
+	return s.nextMessageId, nil
+}
+
+func (s *MemoryStore) GetConversation(ctx context.Context, conversationId int64) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var messages []Message
+	for _, m := range s.messages {
+		if m.conversationId != conversationId {
+			continue
+		}
+		sender := s.users[m.senderId]
+		messages = append(messages, Message{
+			Id: m.id,
+			SenderId: sender.Id,
+			SenderUsername: sender.Username,
+			SenderDisplayName: sender.DisplayName,
+			SenderAvatarURL: sender.AvatarURL,
+			Text: m.text,
+			CreatedAt: formatTime(m.createdAt),
+		})
+	}
+	return messages, nil
+}
+
+// GetConversations summarises each of userId's conversations by its latest
+// message, in the order the conversations were started. As in Postgres, the
+// "other user" is whoever sent that message.
+func (s *MemoryStore) GetConversations(ctx context.Context, userId int64) ([]Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	latest := map[int64]memoryMessage{}
+	for _, m := range s.messages {
+		for _, member := range s.conversations[m.conversationId] {
+			if member == userId {
+				latest[m.conversationId] = m
+			}
+		}
+	}
+
+	var conversations []Conversation
+	for conversationId, m := range latest {
+		sender := s.users[m.senderId]
+		conversations = append(conversations, Conversation{
+			Id: conversationId,
+			Text: m.text,
+			OtherUserDisplayName: sender.DisplayName,
+			OtherUserName: sender.Username,
+			OtherUserAvatarURL: sender.AvatarURL,
+			MostRecentDate: formatTime(m.createdAt),
+		})
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].Id < conversations[j].Id
+	})
+	return conversations, nil
+}
+
+func (s *MemoryStore) GetNotifications(ctx context.Context, userId int64) ([]Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	type event struct {
+		engagement
+		retweeted bool
+	}
+	var events []event
+	for _, e := range s.likes {
+		events = append(events, event{engagement: e})
+	}
+	for _, e := range s.retweets {
+		events = append(events, event{engagement: e, retweeted: true})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].createdAt.After(events[j].createdAt)
+	})
+
+	var notifications []Notification
+	for _, e := range events {
+		t := s.tweets[e.tweetId]
+		if t.userId != userId || e.userId == userId || has(s.mutes, userId, e.userId) ||
+			s.blockedBetween(userId, e.userId) {
+			continue
+		}
+		user := s.users[e.userId]
+		notifications = append(notifications, Notification{
+			TweetId: t.id,
+			Text: t.text,
+			Username: user.Username,
+			Retweeted: e.retweeted,
+			Liked: !e.retweeted,
+			DisplayName: user.DisplayName,
+			AvatarURL: user.AvatarURL,
+		})
+	}
+	return notifications, nil
+}
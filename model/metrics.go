@@ -0,0 +1,169 @@
+package model
+
+import (
+	"time"
+	"regexp"
+	"context"
+	"runtime"
+	"strings"
+	"database/sql"
+	metrics "github.com/dustinnewman98/twitter_clone/metrics"
+	tracing "github.com/dustinnewman98/twitter_clone/tracing"
+)
+
+var (
+	queryDuration = metrics.NewHistogram("db_query_duration_seconds", "Database statement latency by the model function that ran it.", metrics.DURATION_BUCKETS, "function")
+	queryErrors = metrics.NewCounter("db_query_errors_total", "Failed database statements by the model function that ran them.", "function")
+)
+
+func init() {
+	gauge := func(name, help string, value func(sql.DBStats) float64) {
+		metrics.NewGaugeFunc(name, help, func() float64 {
+			return value(dbStats())
+		})
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) {
+		metrics.NewCounterFunc(name, help, func() float64 {
+			return value(dbStats())
+		})
+	}
+	gauge("db_max_open_connections", "Maximum number of open connections to the database.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("db_open_connections", "Established connections, in use or idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("db_in_use_connections", "Connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("db_idle_connections", "Idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("db_wait_count_total", "Times a statement waited for a free connection.", func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("db_wait_duration_seconds_total", "Time spent waiting for a free connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("db_max_idle_closed_total", "Connections closed because of the idle limit.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("db_max_lifetime_closed_total", "Connections closed because of their maximum lifetime.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+func dbStats() sql.DBStats {
+	if db == nil {
+		return sql.DBStats{}
+	}
+	return db.Stats()
+}
+
+// instrumentedDB times every statement for the metrics endpoint, labelled
+// with the model function that ran it, and traces it as a child of the span
+// in the statement's context.
+type instrumentedDB struct {
+	*sql.DB
+}
+
+// instrumentedTx does the same for statements in a transaction.
+type instrumentedTx struct {
+	*sql.Tx
+}
+
+const modelPackage = "github.com/dustinnewman98/twitter_clone/model."
+
+// queryFunction names the model function that is running a statement, such
+// as "GetFeed". Closures count towards the function that defines them.
+func queryFunction() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		name := strings.TrimPrefix(frame.Function, modelPackage)
+		if name != frame.Function && !strings.Contains(name, "instrumented") {
+			return strings.Split(name, ".")[0]
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// Literals are blanked out of traced statements in case they hold user data.
+var (
+	stringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numberLiteral = regexp.MustCompile(`\$?\b\d+(?:\.\d+)?\b`)
+)
+
+// sanitizeStatement collapses whitespace in query and replaces its literals
+// with "?", keeping placeholders such as $1.
+func sanitizeStatement(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	query = stringLiteral.ReplaceAllString(query, "?")
+	return numberLiteral.ReplaceAllStringFunc(query, func(number string) string {
+		if strings.HasPrefix(number, "$") {
+			return number
+		}
+		return "?"
+	})
+}
+
+// startQuery begins timing a statement, tracing it when tracing is on.
+func startQuery(ctx context.Context, query string) (string, time.Time, *tracing.Span) {
+	function := queryFunction()
+	var span *tracing.Span
+	if tracing.Enabled() {
+		_, span = tracing.Start(ctx, "db " + function, tracing.CLIENT,
+			"db.system", "postgresql",
+			"db.statement", sanitizeStatement(query),
+			"code.function", function,
+		)
+	}
+	return function, time.Now(), span
+}
+
+func endQuery(function string, start time.Time, span *tracing.Span, err error) {
+	queryDuration.Observe(time.Since(start).Seconds(), function)
+	if err != nil && err != sql.ErrNoRows {
+		queryErrors.Inc(function)
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func (d *instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	function, start, span := startQuery(ctx, query)
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	endQuery(function, start, span, err)
+	return rows, err
+}
+
+// QueryRow's errors only show up on Scan, so only its latency is recorded.
+func (d *instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	function, start, span := startQuery(ctx, query)
+	row := d.DB.QueryRowContext(ctx, query, args...)
+	endQuery(function, start, span, nil)
+	return row
+}
+
+func (d *instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	function, start, span := startQuery(ctx, query)
+	result, err := d.DB.ExecContext(ctx, query, args...)
+	endQuery(function, start, span, err)
+	return result, err
+}
+
+func (d *instrumentedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*instrumentedTx, error) {
+	tx, err := d.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedTx{tx}, nil
+}
+
+func (t *instrumentedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	function, start, span := startQuery(ctx, query)
+	rows, err := t.Tx.QueryContext(ctx, query, args...)
+	endQuery(function, start, span, err)
+	return rows, err
+}
+
+func (t *instrumentedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	function, start, span := startQuery(ctx, query)
+	row := t.Tx.QueryRowContext(ctx, query, args...)
+	endQuery(function, start, span, nil)
+	return row
+}
+
+func (t *instrumentedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	function, start, span := startQuery(ctx, query)
+	result, err := t.Tx.ExecContext(ctx, query, args...)
+	endQuery(function, start, span, err)
+	return result, err
+}
@@ -1,11 +1,14 @@
 package model
 
 import (
+	"context"
 	"log"
 	"fmt"
+	"time"
     "os"
 	"database/sql"
 	_ "github.com/lib/pq"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
 )
 
 type TweetRequest struct {
@@ -16,14 +19,17 @@ type TweetRequest struct {
 }
 
 type Tweet struct {
-	Id int64
-	Username string
-	Text string
-	ImageURL string
-	Date string
-	Liked bool
-	Retweeted bool
-	DisplayName string
+	Id int64 `json:"id"`
+	Username string `json:"username"`
+	Text string `json:"text"`
+	ImageURL string `json:"image_url,omitempty"`
+	Date string `json:"date"`
+	Liked bool `json:"liked"`
+	Retweeted bool `json:"retweeted"`
+	Bookmarked bool `json:"bookmarked"`
+	Pinned bool `json:"pinned"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
 }
 
 type User struct {
@@ -35,12 +41,32 @@ type User struct {
 	Bio string
 	Website string
 	Location string
+	Email string
+	EmailVerified bool
+	Protected bool
+	PinnedTweetId int64
+	AvatarURL string
+	BannerURL string
+	Deactivated bool
+	WebsiteVerified bool
+	// Birthday is YYYY-MM-DD, or "" when not set.
+	Birthday string
+	Role string
+	Suspended bool
+	// SuspendedUntil is "" for indefinite suspensions.
+	SuspendedUntil string
+	SuspensionReason string
 }
 
 type CrossUsers struct {
 	Followers int64
 	Follows int64
 	SecondFollowsFirst bool
+	FirstFollowsSecond bool
+	FirstBlocksSecond bool
+	SecondBlocksFirst bool
+	SecondMutesFirst bool
+	SecondRequestedFirst bool
 }
 
 type Message struct {
@@ -48,6 +74,7 @@ type Message struct {
 	SenderId int64
 	SenderUsername string
 	SenderDisplayName string
+	SenderAvatarURL string
 	Text string
 	CreatedAt string
 }
@@ -64,6 +91,7 @@ type Conversation struct {
 	Text string
 	OtherUserDisplayName string
 	OtherUserName string
+	OtherUserAvatarURL string
 	MostRecentDate string
 }
 
@@ -74,9 +102,10 @@ type Notification struct {
 	Retweeted bool
 	Liked bool
 	DisplayName string
+	AvatarURL string
 }
 
-var db *sql.DB
+var db *instrumentedDB
 
 func nullStringToString(nullString sql.NullString) string {
 	var maybeString string
@@ -94,6 +123,14 @@ func nullInt64ToInt64(nullInt sql.NullInt64) int64 {
 	return maybeInt
 }
 
+// CloseDB closes the pool opened by InitDB.
+func CloseDB() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
 func InitDB() {
     postgresUsername := os.Getenv("POSTGRES_USER")
 	postgresPassword := os.Getenv("POSTGRES_PASSWORD")
@@ -104,14 +141,15 @@ func InitDB() {
 	} else {
 		connStr = fmt.Sprintf("postgres://%s:%s@postgres:5432/twitter?sslmode=disable", postgresUsername, postgresPassword)
 	}
-	var err error
-	db, err = sql.Open("postgres", connStr)
+	conn, err := sql.Open("postgres", connStr)
 	if err != nil {
 		log.Println("Could not connect to database.\n", err)
 		return
 	}
+	db = &instrumentedDB{conn}
+	ctx := context.Background()
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS users(
 		id serial PRIMARY KEY,
 		username VARCHAR (50) UNIQUE NOT NULL,
 		password VARCHAR (50) NOT NULL,
@@ -125,7 +163,7 @@ func InitDB() {
 		log.Println("Could not create users table.\n", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS tweets(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS tweets(
 		id serial PRIMARY KEY,
 		text VARCHAR (140) NOT NULL,
 		image_url TEXT,
@@ -137,7 +175,7 @@ func InitDB() {
 		log.Println("Could not create tweets table.\n", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS follows(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS follows(
 		followed integer REFERENCES users ON DELETE CASCADE,
 		follower integer REFERENCES users,
 		created_at timestamptz NOT NULL DEFAULT now(),
@@ -147,7 +185,7 @@ func InitDB() {
 		log.Println("Could not create follows table.\n", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS retweets(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS retweets(
 		tweet_id integer REFERENCES tweets ON DELETE CASCADE,
 		user_id integer REFERENCES users ON DELETE CASCADE,
 		created_at timestamptz NOT NULL DEFAULT now()
@@ -156,7 +194,7 @@ func InitDB() {
 		log.Println("Could not create retweets table.\n", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS likes(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS likes(
 		tweet_id integer REFERENCES tweets ON DELETE CASCADE,
 		user_id integer REFERENCES users ON DELETE CASCADE,
 		created_at timestamptz NOT NULL DEFAULT now()
@@ -165,7 +203,7 @@ func InitDB() {
 		log.Println("Could not create likes table.\n", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS conversations(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS conversations(
 		id serial PRIMARY KEY,
 		name VARCHAR(30),
 		created_at timestamptz NOT NULL DEFAULT now()
@@ -174,7 +212,7 @@ func InitDB() {
 		log.Println("Could not create conversations table.\n", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS conversations_users(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS conversations_users(
 		conversation_id integer REFERENCES conversations ON DELETE CASCADE,
 		user_id integer REFERENCES users ON DELETE CASCADE,
 		created_at timestamptz NOT NULL DEFAULT now()
@@ -183,7 +221,7 @@ func InitDB() {
 		log.Println("Could not create conversations_users table.\n", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS messages(
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS messages(
 		id serial PRIMARY KEY,
 		text TEXT,
 		conversation_id integer REFERENCES conversations ON DELETE CASCADE,
@@ -193,27 +231,335 @@ func InitDB() {
 	if err != nil {
 		log.Println("Could not create messages table.\n", err)
 	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS email VARCHAR(254) UNIQUE,
+		ADD COLUMN IF NOT EXISTS email_verified boolean NOT NULL DEFAULT false`)
+	if err != nil {
+		log.Println("Could not add email columns to users table.\n", err)
+	}
+
+	// Addresses are matched case-insensitively, so they must be unique that
+	// way too.
+	_, err = db.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS users_lower_email_idx ON users (lower(email))`)
+	if err != nil {
+		log.Println("Could not create users email index.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS protected boolean NOT NULL DEFAULT false`)
+	if err != nil {
+		log.Println("Could not add protected column to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS pinned_tweet_id integer REFERENCES tweets ON DELETE SET NULL`)
+	if err != nil {
+		log.Println("Could not add pinned_tweet_id column to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS avatar_url TEXT,
+		ADD COLUMN IF NOT EXISTS banner_url TEXT`)
+	if err != nil {
+		log.Println("Could not add image columns to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS username_changed_at timestamptz`)
+	if err != nil {
+		log.Println("Could not add username_changed_at column to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS deactivated_at timestamptz`)
+	if err != nil {
+		log.Println("Could not add deactivated_at column to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS website_verified boolean NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS birthday date`)
+	if err != nil {
+		log.Println("Could not add profile columns to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS session_version integer NOT NULL DEFAULT 0`)
+	if err != nil {
+		log.Println("Could not add session_version column to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS follow_requests(
+		requester integer REFERENCES users ON DELETE CASCADE,
+		requested integer REFERENCES users ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (requester, requested)
+		)`)
+	if err != nil {
+		log.Println("Could not create follow_requests table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS blocks(
+		blocker integer REFERENCES users ON DELETE CASCADE,
+		blocked integer REFERENCES users ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (blocker, blocked)
+		)`)
+	if err != nil {
+		log.Println("Could not create blocks table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS mutes(
+		muter integer REFERENCES users ON DELETE CASCADE,
+		muted integer REFERENCES users ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (muter, muted)
+		)`)
+	if err != nil {
+		log.Println("Could not create mutes table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS home_timelines(
+		user_id integer REFERENCES users ON DELETE CASCADE,
+		tweet_id integer REFERENCES tweets ON DELETE CASCADE,
+		actor_id integer REFERENCES users ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (user_id, tweet_id)
+		)`)
+	if err != nil {
+		log.Println("Could not create home_timelines table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS home_timelines_user_id_created_at
+		ON home_timelines (user_id, created_at DESC)`)
+	if err != nil {
+		log.Println("Could not create home_timelines index.\n", err)
+	}
+
+	// Fan-out needs to know which accounts are too large to push to on
+	// every home page load, so follower counts are kept on users by a
+	// trigger rather than counted each time.
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS follower_count integer NOT NULL DEFAULT 0`)
+	if err != nil {
+		log.Println("Could not add follower_count column to users table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE OR REPLACE FUNCTION count_followers() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'INSERT' THEN
+				UPDATE users SET follower_count = follower_count + 1 WHERE id = NEW.followed;
+			ELSE
+				UPDATE users SET follower_count = follower_count - 1 WHERE id = OLD.followed;
+			END IF;
+			RETURN NULL;
+		END $$ LANGUAGE plpgsql`)
+	if err != nil {
+		log.Println("Could not create count_followers function.\n", err)
+	}
+
+	// Counts start from the follows table when the trigger is installed. The
+	// lock keeps follows from changing in between.
+	_, err = db.ExecContext(ctx, `DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'follows_follower_count') THEN
+				LOCK TABLE follows IN SHARE ROW EXCLUSIVE MODE;
+				CREATE TRIGGER follows_follower_count AFTER INSERT OR DELETE ON follows
+					FOR EACH ROW EXECUTE PROCEDURE count_followers();
+				UPDATE users u SET follower_count = (SELECT COUNT(*) FROM follows f WHERE f.followed = u.id);
+			END IF;
+		END $$`)
+	if err != nil {
+		log.Println("Could not create follower_count trigger.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS bookmarks(
+		user_id integer REFERENCES users ON DELETE CASCADE,
+		tweet_id integer REFERENCES tweets ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (user_id, tweet_id)
+		)`)
+	if err != nil {
+		log.Println("Could not create bookmarks table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS lists(
+		id SERIAL PRIMARY KEY,
+		owner_id integer NOT NULL REFERENCES users ON DELETE CASCADE,
+		name VARCHAR(25) NOT NULL,
+		description VARCHAR(100),
+		private boolean NOT NULL DEFAULT false,
+		created_at timestamptz NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		log.Println("Could not create lists table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS list_members(
+		list_id integer REFERENCES lists ON DELETE CASCADE,
+		user_id integer REFERENCES users ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (list_id, user_id)
+		)`)
+	if err != nil {
+		log.Println("Could not create list_members table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS list_subscriptions(
+		list_id integer REFERENCES lists ON DELETE CASCADE,
+		user_id integer REFERENCES users ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (list_id, user_id)
+		)`)
+	if err != nil {
+		log.Println("Could not create list_subscriptions table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS rate_limits(
+		key VARCHAR(200) PRIMARY KEY,
+		tokens double precision NOT NULL,
+		updated_at timestamptz NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		log.Println("Could not create rate_limits table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS user_tokens(
+		token VARCHAR(64) PRIMARY KEY,
+		user_id integer REFERENCES users ON DELETE CASCADE,
+		kind VARCHAR(20) NOT NULL,
+		email VARCHAR(254),
+		created_at timestamptz NOT NULL DEFAULT now(),
+		expires_at timestamptz NOT NULL
+		)`)
+	if err != nil {
+		log.Println("Could not create user_tokens table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS username_history(
+		username VARCHAR (50) PRIMARY KEY,
+		user_id integer REFERENCES users ON DELETE CASCADE,
+		changed_at timestamptz NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		log.Println("Could not create username_history table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS data_exports(
+		id SERIAL PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users ON DELETE CASCADE,
+		status VARCHAR(10) NOT NULL DEFAULT 'pending',
+		object_name TEXT,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		completed_at timestamptz
+		)`)
+	if err != nil {
+		log.Println("Could not create data_exports table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS role VARCHAR(10) NOT NULL DEFAULT 'user',
+		ADD COLUMN IF NOT EXISTS suspended_until timestamptz,
+		ADD COLUMN IF NOT EXISTS suspension_reason VARCHAR(500)`)
+	if err != nil {
+		log.Println("Could not add moderation columns to users table.\n", err)
+	}
+
+	// Moderators used to be flagged with a boolean column; carry them over.
+	_, err = db.ExecContext(ctx, `DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'users' AND column_name = 'moderator') THEN
+				UPDATE users SET role = 'moderator' WHERE moderator AND role = 'user';
+				ALTER TABLE users DROP COLUMN moderator;
+			END IF;
+		END $$`)
+	if err != nil {
+		log.Println("Could not migrate moderator column to roles.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS reports(
+		id SERIAL PRIMARY KEY,
+		reporter_id integer REFERENCES users ON DELETE SET NULL,
+		kind VARCHAR(10) NOT NULL,
+		tweet_id integer REFERENCES tweets ON DELETE SET NULL,
+		message_id integer REFERENCES messages ON DELETE SET NULL,
+		reported_user_id integer REFERENCES users ON DELETE CASCADE,
+		content TEXT NOT NULL DEFAULT '',
+		category VARCHAR(20) NOT NULL,
+		comment VARCHAR(500),
+		status VARCHAR(10) NOT NULL DEFAULT 'open',
+		resolution VARCHAR(20),
+		created_at timestamptz NOT NULL DEFAULT now(),
+		resolved_at timestamptz,
+		resolved_by integer REFERENCES users ON DELETE SET NULL
+		)`)
+	if err != nil {
+		log.Println("Could not create reports table.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS reports_status_created_at
+		ON reports (status, created_at)`)
+	if err != nil {
+		log.Println("Could not create reports index.\n", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS moderation_actions(
+		id SERIAL PRIMARY KEY,
+		moderator_id integer REFERENCES users ON DELETE SET NULL,
+		report_id integer REFERENCES reports ON DELETE SET NULL,
+		target_user_id integer REFERENCES users ON DELETE SET NULL,
+		target_username VARCHAR (50),
+		action VARCHAR(20) NOT NULL,
+		reason VARCHAR(500),
+		created_at timestamptz NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		log.Println("Could not create moderation_actions table.\n", err)
+	}
 }
 
-func CreateUser(username, password string) (int64, error) {
+func CreateUser(ctx context.Context, username, password string) (int64, error) {
 	var id int64
-	err := db.QueryRow(`INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id`, username, password).Scan(&id)
+	// Signing up with a retired username claims it, ending its redirect.
+	err := db.QueryRowContext(ctx, `WITH released AS (DELETE FROM username_history WHERE username = $1)
+		INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id`, username, password).Scan(&id)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
 	return id, nil
 }
 
-func GetUserFromUsername(username string) (User, error) {
-	var password, createdAt string
-	var displayName, bio, website, location sql.NullString
+func GetUserFromUsername(ctx context.Context, username string) (User, error) {
+	return getUser(ctx, "username", username)
+}
+
+// GetUser returns the account with the given ID, or sql.ErrNoRows.
+func GetUser(ctx context.Context, userId int64) (User, error) {
+	return getUser(ctx, "id", userId)
+}
+
+// getUser looks up a user by column, which is "id" or "username".
+func getUser(ctx context.Context, column string, value interface{}) (User, error) {
+	var username, password, createdAt string
+	var displayName, bio, website, location, email sql.NullString
+	var emailVerified, protected, deactivated, websiteVerified, suspended bool
 	var id int64
-	err := db.QueryRow(`SELECT 
-		id, password, created_at, display_name, bio, website, location 
-		FROM users WHERE username = $1`, username).Scan(&id, &password, &createdAt, &displayName, &bio, &website, &location)
+	var pinnedTweetId sql.NullInt64
+	var avatarURL, bannerURL, birthday sql.NullString
+	var role string
+	var suspendedUntil, suspensionReason sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT 
+		id, username, password, created_at, display_name, bio, website, location, email, email_verified, protected, pinned_tweet_id, avatar_url, banner_url,
+		deactivated_at IS NOT NULL, website_verified, to_char(birthday, 'YYYY-MM-DD'), role,
+		COALESCE(suspended_until > now(), false),
+		CASE WHEN suspended_until = 'infinity' THEN NULL ELSE to_char(suspended_until, 'FMMonth FMDD, YYYY') END,
+		suspension_reason
+		FROM users WHERE ` + column + ` = $1`, value).Scan(&id, &username, &password, &createdAt, &displayName, &bio, &website, &location, &email, &emailVerified, &protected, &pinnedTweetId, &avatarURL, &bannerURL, &deactivated, &websiteVerified, &birthday, &role, &suspended, &suspendedUntil, &suspensionReason)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return User{}, err
 	}
 
@@ -226,31 +572,55 @@ func GetUserFromUsername(username string) (User, error) {
 		Bio: nullStringToString(bio),
 		Website: nullStringToString(website),
 		Location: nullStringToString(location),
+		Email: nullStringToString(email),
+		EmailVerified: emailVerified,
+		Protected: protected,
+		PinnedTweetId: nullInt64ToInt64(pinnedTweetId),
+		AvatarURL: nullStringToString(avatarURL),
+		BannerURL: nullStringToString(bannerURL),
+		Deactivated: deactivated,
+		WebsiteVerified: websiteVerified,
+		Birthday: nullStringToString(birthday),
+		Role: role,
+		Suspended: suspended,
+	}
+	if suspended {
+		user.SuspendedUntil = nullStringToString(suspendedUntil)
+		user.SuspensionReason = nullStringToString(suspensionReason)
 	}
 	return user, nil
 }
 
-func GetUserIdFromUsername(username string) (int64, error) {
+func GetUserIdFromUsername(ctx context.Context, username string) (int64, error) {
 	var id int64
-	err := db.QueryRow(`SELECT id FROM users WHERE username = $1`, username).Scan(&id)
+	err := db.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1`, username).Scan(&id)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
 	return id, nil
 }
 
-func GetUsersRelationship(userId, currentUserId int64) (CrossUsers, error) {
+func GetUsersRelationship(ctx context.Context, userId, currentUserId int64) (CrossUsers, error) {
 	var followers, follows int64
-	var secondFollowsFirst bool
-	err := db.QueryRow(`SELECT 
-		COUNT(*) FILTER (WHERE f.followed = $1) as followers,
-		COUNT(*) FILTER (WHERE f.follower = $1) as follows,
-		COUNT(*) FILTER (WHERE f.follower = $2 AND f.followed = $1) = 1 as dnf
+	var secondFollowsFirst, firstFollowsSecond, firstBlocksSecond, secondBlocksFirst, secondMutesFirst, secondRequestedFirst bool
+	// Suspended accounts are left out of the counts, as they are from the
+	// follower lists.
+	err := db.QueryRowContext(ctx, `SELECT 
+		COUNT(*) FILTER (WHERE f.followed = $1 AND (o.suspended_until IS NULL OR o.suspended_until <= now())) as followers,
+		COUNT(*) FILTER (WHERE f.follower = $1 AND (o.suspended_until IS NULL OR o.suspended_until <= now())) as follows,
+		COUNT(*) FILTER (WHERE f.follower = $2 AND f.followed = $1) = 1 as dnf,
+		COUNT(*) FILTER (WHERE f.follower = $1 AND f.followed = $2) = 1 as ffs,
+		EXISTS (SELECT 1 FROM blocks WHERE blocker = $1 AND blocked = $2) as fbs,
+		EXISTS (SELECT 1 FROM blocks WHERE blocker = $2 AND blocked = $1) as sbf,
+		EXISTS (SELECT 1 FROM mutes WHERE muter = $2 AND muted = $1) as smf,
+		EXISTS (SELECT 1 FROM follow_requests WHERE requester = $2 AND requested = $1) as srf
 		FROM follows f
-		WHERE $1 IN (f.followed, f.follower)`, userId, currentUserId).Scan(&followers, &follows, &secondFollowsFirst)
+		INNER JOIN users o
+		ON o.id = CASE WHEN f.followed = $1 THEN f.follower ELSE f.followed END
+		WHERE $1 IN (f.followed, f.follower)`, userId, currentUserId).Scan(&followers, &follows, &secondFollowsFirst, &firstFollowsSecond, &firstBlocksSecond, &secondBlocksFirst, &secondMutesFirst, &secondRequestedFirst)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return CrossUsers{}, err
 	}
 
@@ -258,13 +628,18 @@ func GetUsersRelationship(userId, currentUserId int64) (CrossUsers, error) {
 		Followers: followers,
 		Follows: follows,
 		SecondFollowsFirst: secondFollowsFirst,
+		FirstFollowsSecond: firstFollowsSecond,
+		FirstBlocksSecond: firstBlocksSecond,
+		SecondBlocksFirst: secondBlocksFirst,
+		SecondMutesFirst: secondMutesFirst,
+		SecondRequestedFirst: secondRequestedFirst,
 	}
 	return crossUsers, nil
 }
 
-func GetTwoUsersConversation(userId, currentUserId int64) (int64, error) {
+func GetTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error) {
 	var id sql.NullInt64
-	err := db.QueryRow(`SELECT cu.conversation_id
+	err := db.QueryRowContext(ctx, `SELECT cu.conversation_id
 		FROM conversations_users cu
 		INNER JOIN conversations_users cus
 		ON cus.conversation_id = cu.conversation_id AND cus.user_id = $2
@@ -274,124 +649,241 @@ func GetTwoUsersConversation(userId, currentUserId int64) (int64, error) {
 		WHERE conversation_id = cus.conversation_id AND user_id != $1 AND user_id != $2
 		)`, userId, currentUserId).Scan(&id)
 	if err != nil && err != sql.ErrNoRows {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
 	return nullInt64ToInt64(id), nil
 }
 
-func CreateTwoUsersConversation(userId, currentUserId int64) (int64, error) {
+func CreateTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error) {
+	blocked, err := blockedBetween(ctx, userId, currentUserId)
+	if err != nil {
+		return 0, err
+	}
+	if blocked {
+		return 0, ErrBlocked
+	}
+
 	var conversationId int64
-	err := db.QueryRow(`INSERT INTO conversations DEFAULT VALUES RETURNING id`).Scan(&conversationId)
+	err = db.QueryRowContext(ctx, `INSERT INTO conversations DEFAULT VALUES RETURNING id`).Scan(&conversationId)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
 
-	_, err = db.Exec(`INSERT INTO 
+	_, err = db.ExecContext(ctx, `INSERT INTO 
 		conversations_users(conversation_id, user_id) VALUES($1, $2)`,
 		conversationId, userId)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
 
-	_, err = db.Exec(`INSERT INTO 
+	_, err = db.ExecContext(ctx, `INSERT INTO 
 		conversations_users(conversation_id, user_id) VALUES($1, $2)`,
 		conversationId, currentUserId)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
 	return conversationId, nil
 }
 
-func SmartCreateUser(request MessageRequest) (int64, error) {
+func SmartCreateUser(ctx context.Context, request MessageRequest) (int64, error) {
 	var id int64
-	err := db.QueryRow(`INSERT INTO messages(sender_id, text, conversation_id)
+	err := db.QueryRowContext(ctx, `INSERT INTO messages(sender_id, text, conversation_id)
 		SELECT $1, $2, $3
 		WHERE EXISTS (
 			SELECT c.conversation_id
 			FROM conversations_users c
 			WHERE c.user_id = $1 AND c.conversation_id = $3
+		) AND NOT EXISTS (
+			SELECT 1
+			FROM conversations_users o
+			INNER JOIN blocks b
+			ON (b.blocker = o.user_id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = o.user_id)
+			WHERE o.conversation_id = $3
 		) RETURNING id`, request.SenderId, request.Text, request.ConversationId).Scan(&id)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
 	return id, nil
 }
 
-func EditUser(edits User) error {
-	result, err := db.Exec(`UPDATE users
-		SET display_name = $1, bio = $2, location = $3, website = $4 WHERE id = $5`,
-	edits.DisplayName, edits.Bio, edits.Location, edits.Website, edits.Id)
+// EditUser saves the profile fields. Changing the website clears its
+// verification. It returns sql.ErrNoRows if the user no longer exists.
+func EditUser(ctx context.Context, edits User) error {
+	result, err := db.ExecContext(ctx, `UPDATE users
+		SET display_name = $1, bio = $2, location = $3, website = $4, protected = $5,
+		birthday = NULLIF($7, '')::date,
+		website_verified = website_verified AND website IS NOT DISTINCT FROM $4
+		WHERE id = $6`,
+	edits.DisplayName, edits.Bio, edits.Location, edits.Website, edits.Protected, edits.Id, edits.Birthday)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return err
 	}
 	rows, err := result.RowsAffected()
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return err
 	}
-	if rows != 1 {
-		log.Fatalf("expected to affect 1 row, affected %d", rows)
-		log.Println("Query Error: ", err)
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	if !edits.Protected {
+		return ApproveAllFollowRequests(ctx, edits.Id)
+	}
+	return nil
+}
+
+// SetWebsiteVerified records whether website links back to userId's
+// profile, unless the user has changed their website since.
+func SetWebsiteVerified(ctx context.Context, userId int64, website string, verified bool) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET website_verified = $3 WHERE id = $1 AND website = $2`,
+		userId, website, verified)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// SetAvatar stores the base URL of userId's avatar variants; "" removes it.
+func SetAvatar(ctx context.Context, userId int64, avatarURL string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET avatar_url = NULLIF($2, '') WHERE id = $1`, userId, avatarURL)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// SetBanner stores the base URL of userId's banner variants; "" removes it.
+func SetBanner(ctx context.Context, userId int64, bannerURL string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET banner_url = NULLIF($2, '') WHERE id = $1`, userId, bannerURL)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
 		return err
 	}
 	return nil
 }
 
-func CreateTweet(request TweetRequest) (int64, error) {
+func CreateTweet(ctx context.Context, request TweetRequest) (int64, error) {
 	var id int64
+	var createdAt time.Time
 	var err error
+	if request.ParentId != 0 {
+		blocked, err := blockedFromTweet(ctx, request.UserId, request.ParentId)
+		if err != nil {
+			return 0, err
+		}
+		if blocked {
+			return 0, ErrBlocked
+		}
+	}
+
 	if request.ParentId != 0 {
 		if request.ImageURL != "" {
-			err = db.QueryRow(`INSERT INTO tweets (text, user_id, image_url, parent_id) 
-				VALUES ($1, $2, $3, $4) RETURNING id`, 
-				request.Text, request.UserId, request.ImageURL, request.ParentId).Scan(&id)
+			err = db.QueryRowContext(ctx, `INSERT INTO tweets (text, user_id, image_url, parent_id) 
+				VALUES ($1, $2, $3, $4) RETURNING id, created_at`, 
+				request.Text, request.UserId, request.ImageURL, request.ParentId).Scan(&id, &createdAt)
 		} else {
-			err = db.QueryRow(`INSERT INTO tweets (text, user_id, parent_id) 
-				VALUES ($1, $2, $3) RETURNING id`, 
-				request.Text, request.UserId, request.ParentId).Scan(&id)
+			err = db.QueryRowContext(ctx, `INSERT INTO tweets (text, user_id, parent_id) 
+				VALUES ($1, $2, $3) RETURNING id, created_at`, 
+				request.Text, request.UserId, request.ParentId).Scan(&id, &createdAt)
 		}
 	} else {
 		if request.ImageURL != "" {
-			err = db.QueryRow(`INSERT INTO tweets (text, user_id, image_url) 
-				VALUES ($1, $2, $3) RETURNING id`, 
-				request.Text, request.UserId, request.ImageURL).Scan(&id)
+			err = db.QueryRowContext(ctx, `INSERT INTO tweets (text, user_id, image_url) 
+				VALUES ($1, $2, $3) RETURNING id, created_at`, 
+				request.Text, request.UserId, request.ImageURL).Scan(&id, &createdAt)
 		} else {
-			err = db.QueryRow(`INSERT INTO tweets (text, user_id) 
-				VALUES ($1, $2) RETURNING id`, 
-				request.Text, request.UserId).Scan(&id)
+			err = db.QueryRowContext(ctx, `INSERT INTO tweets (text, user_id) 
+				VALUES ($1, $2) RETURNING id, created_at`, 
+				request.Text, request.UserId).Scan(&id, &createdAt)
 		}
 	}
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return 0, err
 	}
+	publish(ctx, TimelineEntry{TweetId: id, AuthorId: request.UserId, ActorId: request.UserId, CreatedAt: createdAt})
 	return id, nil
 }
 
-func GetTweet(tweetId, userId int64) (Tweet, error) {
+// DeleteTweet removes a Tweet, keeping replies to it as standalone Tweets,
+// and returns its image URL so the upload can be removed. It returns
+// sql.ErrNoRows if there is no such Tweet.
+func DeleteTweet(ctx context.Context, tweetId int64) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+	defer tx.Rollback()
+
+	imageURL, err := deleteTweet(ctx, tx, tweetId)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+	return imageURL, nil
+}
+
+func deleteTweet(ctx context.Context, tx *instrumentedTx, tweetId int64) (string, error) {
+	_, err := tx.ExecContext(ctx, `UPDATE tweets SET parent_id = NULL WHERE parent_id = $1`, tweetId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+	var imageURL sql.NullString
+	err = tx.QueryRowContext(ctx, `DELETE FROM tweets WHERE id = $1 RETURNING image_url`, tweetId).Scan(&imageURL)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logging.Error(ctx, "Query failed.", "err", err)
+		}
+		return "", err
+	}
+	return nullStringToString(imageURL), nil
+}
+
+func GetTweet(ctx context.Context, tweetId, userId int64) (Tweet, error) {
 	var text, date, username string
-	var imageURL, displayName sql.NullString
-	var liked, retweeted bool
-	err := db.QueryRow(`SELECT t.text, t.created_at, t.image_url, u.username,
+	var imageURL, displayName, avatarURL sql.NullString
+	var liked, retweeted, bookmarked, pinned bool
+	err := db.QueryRowContext(ctx, `SELECT t.text, t.created_at, t.image_url, u.username,
 		(l.user_id IS NOT NULL) AS liked, 
 		(r.user_id IS NOT NULL) AS retweeted,
-		u.display_name
+		u.display_name,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $2) AS bookmarked,
+		COALESCE(u.pinned_tweet_id = t.id, false) AS pinned,
+		u.avatar_url
 		FROM tweets t
 		INNER JOIN users u
 		ON t.user_id = u.id AND t.id = $1
 		LEFT JOIN likes l
         ON l.user_id = $2 AND l.tweet_id = $1
         LEFT JOIN retweets r
-		ON r.user_id = $2 AND r.tweet_id = $1`, 
-	tweetId, userId).Scan(&text, &date, &imageURL, &username, &liked, &retweeted, &displayName)
+		ON r.user_id = $2 AND r.tweet_id = $1
+		WHERE NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR u.id = $2 OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $2
+		))`, 
+	tweetId, userId).Scan(&text, &date, &imageURL, &username, &liked, &retweeted, &displayName, &bookmarked, &pinned, &avatarURL)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return Tweet{}, err
 	}
 	tweet := Tweet{
@@ -402,16 +894,21 @@ func GetTweet(tweetId, userId int64) (Tweet, error) {
 		Date: date,
 		Liked: liked,
 		Retweeted: retweeted,
+		Bookmarked: bookmarked,
+		Pinned: pinned,
 		DisplayName: nullStringToString(displayName),
+		AvatarURL: nullStringToString(avatarURL),
 	}
 	return tweet, nil
 }
 
-func GetReplies(tweetId, userId int64) ([]Tweet, error) {
-	result, err := db.Query(`SELECT t.id, t.text, t.image_url, 
+func GetReplies(ctx context.Context, tweetId, userId int64) ([]Tweet, error) {
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text, t.image_url, 
 		t.created_at, u.username, u.display_name,
 		(l.user_id IS NOT NULL) as user_liked,
-		(r.user_id IS NOT NULL) as user_retweeted
+		(r.user_id IS NOT NULL) as user_retweeted,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $2) AS bookmarked,
+		u.avatar_url
 		FROM tweets t
 		INNER JOIN users u
 		ON u.id = t.user_id
@@ -419,9 +916,17 @@ func GetReplies(tweetId, userId int64) ([]Tweet, error) {
 		ON l.user_id = $2 AND l.tweet_id = t.id
 		LEFT JOIN retweets r
 		ON r.user_id = $2 AND r.tweet_id = t.id
-		WHERE t.parent_id = $1`, tweetId, userId)
+		WHERE t.parent_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR u.id = $2 OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $2
+		))`, tweetId, userId)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return nil, err
 	}
 	defer result.Close()
@@ -430,11 +935,11 @@ func GetReplies(tweetId, userId int64) ([]Tweet, error) {
 	for result.Next() {
 		var id int64
 		var text, imageURL, createdAt, username string
-		var displayName sql.NullString
-		var liked, retweeted bool
-		err := result.Scan(&id, &text, &imageURL, &createdAt, &username, &displayName, &liked, &retweeted)
+		var displayName, avatarURL sql.NullString
+		var liked, retweeted, bookmarked bool
+		err := result.Scan(&id, &text, &imageURL, &createdAt, &username, &displayName, &liked, &retweeted, &bookmarked, &avatarURL)
 		if err != nil {
-			log.Println("Scanning error: ", err)
+			logging.Error(ctx, "Could not scan row.", "err", err)
 			break
 		}
 		reply := Tweet{
@@ -446,22 +951,26 @@ func GetReplies(tweetId, userId int64) ([]Tweet, error) {
 			Date: createdAt,
 			Liked: liked,
 			Retweeted: retweeted,
+			Bookmarked: bookmarked,
+			AvatarURL: nullStringToString(avatarURL),
 		}
 		replies = append(replies, reply)
 	}
 	return replies, nil
 }
 
-func GetConversation(conversationId int64) ([]Message, error) {
-	result, err := db.Query(`SELECT m.id, m.text, m.created_at,
-		u.id, u.username, u.display_name
+func GetConversation(ctx context.Context, conversationId int64) ([]Message, error) {
+	result, err := db.QueryContext(ctx, `SELECT m.id, m.text, m.created_at,
+		u.id, u.username, u.display_name,
+		u.avatar_url
 		FROM messages m
 		LEFT JOIN users u
 		ON u.id = m.sender_id
 		WHERE m.conversation_id = $1
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
 		ORDER BY m.created_at ASC`, conversationId)
 	if err != nil {
-		log.Println("Query error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return nil, err
 	}
 	defer result.Close()
@@ -470,10 +979,10 @@ func GetConversation(conversationId int64) ([]Message, error) {
 	for result.Next() {
 		var id, senderId int64
 		var text, senderUsername, createdAt string
-		var senderDisplayName sql.NullString
-		err = result.Scan(&id, &text, &createdAt, &senderId, &senderUsername, &senderDisplayName)
+		var senderDisplayName, avatarURL sql.NullString
+		err = result.Scan(&id, &text, &createdAt, &senderId, &senderUsername, &senderDisplayName, &avatarURL)
 		if err != nil {
-			log.Println("Scanning Error: ", err)
+			logging.Error(ctx, "Could not scan row.", "err", err)
 			break
 		}
 		message := Message{
@@ -483,16 +992,18 @@ func GetConversation(conversationId int64) ([]Message, error) {
 			SenderId: senderId,
 			SenderUsername: senderUsername,
 			SenderDisplayName: nullStringToString(senderDisplayName),
+			SenderAvatarURL: nullStringToString(avatarURL),
 		}
 		messages = append(messages, message)
 	}
 	return messages, nil
 }
 
-func GetConversations(userId int64) ([]Conversation, error) {
-	result, err := db.Query(`SELECT DISTINCT ON (m.conversation_id)
+func GetConversations(ctx context.Context, userId int64) ([]Conversation, error) {
+	result, err := db.QueryContext(ctx, `SELECT DISTINCT ON (m.conversation_id)
 		m.conversation_id, m.text, m.created_at,
-		c.name, u.username, u.display_name
+		c.name, u.username, u.display_name,
+		u.avatar_url
 		FROM messages m
 		LEFT JOIN conversations c
 		ON c.id = m.conversation_id
@@ -505,9 +1016,10 @@ func GetConversations(userId int64) ([]Conversation, error) {
 			ON c.id = cu.conversation_id
 			WHERE cu.user_id = $1
 		)
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
 		ORDER BY m.conversation_id, m.created_at DESC`, userId)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return nil, err
 	}
 	defer result.Close()
@@ -516,10 +1028,10 @@ func GetConversations(userId int64) ([]Conversation, error) {
 	for result.Next() {
 		var id int64
 		var text, createdAt, otherUsername string
-		var name, otherUserDisplayName sql.NullString
-		err := result.Scan(&id, &text, &createdAt, &name, &otherUsername, &otherUserDisplayName)
+		var name, otherUserDisplayName, avatarURL sql.NullString
+		err := result.Scan(&id, &text, &createdAt, &name, &otherUsername, &otherUserDisplayName, &avatarURL)
 		if err != nil {
-			log.Println("Scanning error: ", err)
+			logging.Error(ctx, "Could not scan row.", "err", err)
 			break
 		}
 		conversation := Conversation{
@@ -529,6 +1041,7 @@ func GetConversations(userId int64) ([]Conversation, error) {
 			OtherUserName: otherUsername,
 			OtherUserDisplayName: nullStringToString(otherUserDisplayName),
 			MostRecentDate: createdAt,
+			OtherUserAvatarURL: nullStringToString(avatarURL),
 		}
 		
 		conversations = append(conversations, conversation)
@@ -536,22 +1049,32 @@ func GetConversations(userId int64) ([]Conversation, error) {
 	return conversations, nil
 }
 
-func GetNotifications(userId int64) ([]Notification, error) {
-	result, err := db.Query(`SELECT t.id, t.text,
-		r.user_id IS NOT NULL as retweeted, 
-		l.user_id IS NOT NULL as liked,
-		u.username, u.display_name
+func GetNotifications(ctx context.Context, userId int64) ([]Notification, error) {
+	// One notification per like or retweet by someone else.
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text,
+		e.retweeted, NOT e.retweeted AS liked,
+		u.username, u.display_name,
+		u.avatar_url
 		FROM tweets t
-		LEFT JOIN retweets r
-		ON r.tweet_id = t.id AND r.user_id != $1
-		LEFT JOIN likes l
-		ON l.tweet_id = t.id AND l.user_id != $1
+		INNER JOIN (
+			SELECT tweet_id, user_id, created_at, true AS retweeted FROM retweets
+			UNION ALL
+			SELECT tweet_id, user_id, created_at, false AS retweeted FROM likes
+		) e
+		ON e.tweet_id = t.id AND e.user_id != $1
 		INNER JOIN users u
-		ON u.id = l.user_id OR u.id = r.user_id
+		ON u.id = e.user_id
 		WHERE t.user_id = $1
-		ORDER BY l.created_at DESC, r.created_at DESC`, userId)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter = $1 AND m.muted = u.id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = u.id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = u.id)
+		)
+		ORDER BY e.created_at DESC`, userId)
 	if err != nil && err != sql.ErrNoRows {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return nil, err
 	}
 	defer result.Close()
@@ -561,11 +1084,11 @@ func GetNotifications(userId int64) ([]Notification, error) {
 		var id int64
 		var text, username string
 		var retweeted, liked bool
-		var displayName sql.NullString
+		var displayName, avatarURL sql.NullString
 
-		err := result.Scan(&id, &text, &retweeted, &liked, &username, &displayName)
+		err := result.Scan(&id, &text, &retweeted, &liked, &username, &displayName, &avatarURL)
 		if err != nil {
-			log.Println("Scanning error: ", err)
+			logging.Error(ctx, "Could not scan row.", "err", err)
 			break
 		}
 
@@ -576,6 +1099,7 @@ func GetNotifications(userId int64) ([]Notification, error) {
 			Retweeted: retweeted,
 			Liked: liked,
 			DisplayName: nullStringToString(displayName),
+			AvatarURL: nullStringToString(avatarURL),
 		}
 
 		notifications = append(notifications, notification)
@@ -583,37 +1107,79 @@ func GetNotifications(userId int64) ([]Notification, error) {
 	return notifications, nil
 }
 
-func CreateFollow(followed, follower int64) (bool, error) {
-	_, err := db.Exec(`INSERT INTO follows (followed, follower) VALUES($1, $2)`, followed, follower)
+func CreateFollow(ctx context.Context, followed, follower int64) (bool, error) {
+	blocked, err := blockedBetween(ctx, followed, follower)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, ErrBlocked
+	}
+
+	// Protected accounts approve their followers, so only record a request.
+	var protected bool
+	err = db.QueryRowContext(ctx, `SELECT protected FROM users WHERE id = $1`, followed).Scan(&protected)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return false, err
+	}
+	if protected && followed != follower {
+		return false, CreateFollowRequest(ctx, followed, follower)
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO follows (followed, follower) VALUES($1, $2)`, followed, follower)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return false, err
 	}
 	return true, nil
 }
 
-func CreateRetweet(userId, tweetId int64) (bool, error) {
-	_, err := db.Exec(`INSERT INTO retweets (user_id, tweet_id) VALUES($1, $2)`, userId, tweetId)
+func CreateRetweet(ctx context.Context, userId, tweetId int64) (bool, error) {
+	blocked, err := blockedFromTweet(ctx, userId, tweetId)
 	if err != nil {
-		log.Println("Query Error: ", err)
 		return false, err
 	}
+	if blocked {
+		return false, ErrBlocked
+	}
+
+	var createdAt time.Time
+	var authorId int64
+	err = db.QueryRowContext(ctx, `INSERT INTO retweets (user_id, tweet_id) VALUES($1, $2)
+		RETURNING created_at, (SELECT user_id FROM tweets WHERE id = $2)`,
+		userId, tweetId).Scan(&createdAt, &authorId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return false, err
+	}
+	publish(ctx, TimelineEntry{TweetId: tweetId, AuthorId: authorId, ActorId: userId, CreatedAt: createdAt})
 	return true, nil
 }
 
-func CreateLike(userId, tweetId int64) (bool, error) {
-	_, err := db.Exec(`INSERT INTO likes (user_id, tweet_id) VALUES($1, $2)`, userId, tweetId)
+func CreateLike(ctx context.Context, userId, tweetId int64) (bool, error) {
+	blocked, err := blockedFromTweet(ctx, userId, tweetId)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, ErrBlocked
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO likes (user_id, tweet_id) VALUES($1, $2)`, userId, tweetId)
 	if err != nil {
-		log.Println("Query Error: ", err)
+		logging.Error(ctx, "Query failed.", "err", err)
 		return false, err
 	}
 	return true, nil
 }
 
-func GetFeed(userId int64) ([]Tweet, error) {
-	result, err := db.Query(`SELECT t.id, t.text, t.created_at, u.username, 
+func GetFeed(ctx context.Context, userId int64) ([]Tweet, error) {
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text, t.created_at, u.username, 
 		(l.user_id IS NOT NULL) AS liked, 
-		(r.user_id IS NOT NULL) AS retweeted
+		(r.user_id IS NOT NULL) AS retweeted,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $1) AS bookmarked,
+		u.avatar_url
 		FROM tweets t
 		INNER JOIN follows f 
 		ON t.user_id = f.followed AND f.follower = $1
@@ -624,21 +1190,28 @@ func GetFeed(userId int64) ([]Tweet, error) {
 		FULL JOIN retweets r
 		ON r.tweet_id = t.id AND r.user_id = $1
 		WHERE t.id IS NOT NULL
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter = $1 AND m.muted = t.user_id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = t.user_id)
+		)
 		ORDER BY t.created_at DESC`, userId)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(result)
 	defer result.Close()
 
 	var tweets []Tweet
 	for result.Next() {
 		var id int64
 		var text, createdAt, username string
-		var liked, retweeted bool
-		err := result.Scan(&id, &text, &createdAt, &username, &liked, &retweeted)
+		var liked, retweeted, bookmarked bool
+		var avatarURL sql.NullString
+		err := result.Scan(&id, &text, &createdAt, &username, &liked, &retweeted, &bookmarked, &avatarURL)
 		if err != nil {
-			log.Println("Scanning error: ", err)
+			logging.Error(ctx, "Could not scan row.", "err", err)
 			break
 		}
 		tweet := Tweet{
@@ -648,41 +1221,69 @@ func GetFeed(userId int64) ([]Tweet, error) {
 			Date: createdAt,
 			Liked: liked,
 			Retweeted: retweeted,
+			Bookmarked: bookmarked,
+			AvatarURL: nullStringToString(avatarURL),
 		}
 		tweets = append(tweets, tweet)
 	}
 	return tweets, nil
 }
 
-func GetHistory(userId, currentUserId int64) ([]Tweet, error) {
-	result, err := db.Query(`SELECT t.id, t.text, u.username, t.created_at,
+// canSeeUser is the CanSeeTweets condition for user $1 and viewer $2. It
+// also gates profile tabs, where Tweets by other authors show what $1
+// retweeted or liked.
+const canSeeUser = `($1 = $2 OR (
+			NOT EXISTS (
+				SELECT 1 FROM blocks ob
+				WHERE (ob.blocker = $1 AND ob.blocked = $2) OR (ob.blocker = $2 AND ob.blocked = $1)
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM users o
+				WHERE o.id = $1 AND o.protected
+				AND NOT EXISTS (SELECT 1 FROM follows vf WHERE vf.followed = $1 AND vf.follower = $2)
+			)
+		))`
+
+func GetHistory(ctx context.Context, userId, currentUserId int64) ([]Tweet, error) {
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text, u.username, t.created_at,
 		(l.tweet_id IS NOT NULL) AS liked,
-		(e.tweet_id IS NOT NULL) AS retweeted
+		(e.tweet_id IS NOT NULL) AS retweeted,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $2) AS bookmarked,
+		COALESCE(u.pinned_tweet_id = t.id, false) AS pinned,
+		u.avatar_url
 		FROM tweets t
-		LEFT JOIN retweets r
-		ON r.tweet_id = t.id
 		LEFT JOIN users u 
 		ON t.user_id = u.id 
 		LEFT JOIN likes l
 		ON l.tweet_id = t.id AND l.user_id = $2
 		LEFT JOIN retweets e
 		ON e.user_id = $2 AND e.tweet_id = t.id
-		WHERE r.user_id = $1 OR t.user_id = $1 
+		WHERE (t.user_id = $1 OR EXISTS (SELECT 1 FROM retweets r WHERE r.tweet_id = t.id AND r.user_id = $1))
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR u.id = $2 OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $2
+		))
+		AND ` + canSeeUser + `
 		ORDER BY t.created_at DESC`, userId, currentUserId)
 		if err != nil {
 		return nil, err
 	}
-	fmt.Println(result)
 	defer result.Close()
 
 	var tweets []Tweet
 	for result.Next() {
 		var id int64
 		var text, username, createdAt string
-		var liked, retweeted bool
-		err := result.Scan(&id, &text, &username, &createdAt, &liked, &retweeted)
+		var liked, retweeted, bookmarked, pinned bool
+		var avatarURL sql.NullString
+		err := result.Scan(&id, &text, &username, &createdAt, &liked, &retweeted, &bookmarked, &pinned, &avatarURL)
 		if err != nil {
-			log.Println("Scanning error: ", err)
+			logging.Error(ctx, "Could not scan row.", "err", err)
 			break
 		}
 		tweet := Tweet{
@@ -692,16 +1293,21 @@ func GetHistory(userId, currentUserId int64) ([]Tweet, error) {
 			Date: createdAt,
 			Liked: liked,
 			Retweeted: retweeted,
+			Bookmarked: bookmarked,
+			Pinned: pinned,
+			AvatarURL: nullStringToString(avatarURL),
 		}
 		tweets = append(tweets, tweet)
 	}
 	return tweets, nil
 }
 
-func GetLikes(userId, currentUserId int64) ([]Tweet, error) {
-	result, err := db.Query(`SELECT t.id, t.text, u.username, t.created_at,
+func GetLikes(ctx context.Context, userId, currentUserId int64) ([]Tweet, error) {
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text, u.username, t.created_at,
 		(l.tweet_id IS NOT NULL) AS liked,
-		(e.tweet_id IS NOT NULL) AS retweeted
+		(e.tweet_id IS NOT NULL) AS retweeted,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $2) AS bookmarked,
+		u.avatar_url
 		FROM likes k
 			LEFT JOIN tweets t
 			ON k.tweet_id = t.id
@@ -712,21 +1318,31 @@ func GetLikes(userId, currentUserId int64) ([]Tweet, error) {
 			LEFT JOIN retweets e
 			ON e.user_id = $2 AND e.tweet_id = t.id
 		WHERE k.user_id = $1
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR u.id = $2 OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $2
+		))
+		AND ` + canSeeUser + `
 		ORDER BY t.created_at DESC`, userId, currentUserId)
 		if err != nil {
 		return nil, err
 	}
-	fmt.Println(result)
 	defer result.Close()
 
 	var tweets []Tweet
 	for result.Next() {
 		var id int64
 		var text, username, createdAt string
-		var liked, retweeted bool
-		err := result.Scan(&id, &text, &username, &createdAt, &liked, &retweeted)
+		var liked, retweeted, bookmarked bool
+		var avatarURL sql.NullString
+		err := result.Scan(&id, &text, &username, &createdAt, &liked, &retweeted, &bookmarked, &avatarURL)
 		if err != nil {
-			log.Println("Scanning error: ", err)
+			logging.Error(ctx, "Could not scan row.", "err", err)
 			break
 		}
 		tweet := Tweet{
@@ -736,6 +1352,8 @@ func GetLikes(userId, currentUserId int64) ([]Tweet, error) {
 			Date: createdAt,
 			Liked: liked,
 			Retweeted: retweeted,
+			Bookmarked: bookmarked,
+			AvatarURL: nullStringToString(avatarURL),
 		}
 		tweets = append(tweets, tweet)
 	}
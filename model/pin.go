@@ -0,0 +1,39 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// PinTweet pins one of userId's own Tweets to the top of their profile,
+// replacing any earlier pin. It returns sql.ErrNoRows when tweetId is not
+// theirs.
+func PinTweet(ctx context.Context, userId, tweetId int64) error {
+	result, err := db.ExecContext(ctx, `UPDATE users SET pinned_tweet_id = $2
+		WHERE id = $1 AND EXISTS (SELECT 1 FROM tweets WHERE id = $2 AND user_id = $1)`, userId, tweetId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UnpinTweet clears userId's pinned Tweet if it is tweetId.
+func UnpinTweet(ctx context.Context, userId, tweetId int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET pinned_tweet_id = NULL
+		WHERE id = $1 AND pinned_tweet_id = $2`, userId, tweetId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"context"
+	"math"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// TakeRateLimitToken refills the token bucket stored under key at rate tokens
+// per second, capped at burst, and removes one token when take is set and one
+// is available. It returns the tokens left afterwards and whether one was
+// taken. The row is locked for the duration so concurrent servers agree.
+func TakeRateLimitToken(ctx context.Context, key string, rate float64, burst int, take bool) (float64, bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO rate_limits (key, tokens) VALUES ($1, $2)
+		ON CONFLICT (key) DO NOTHING`, key, burst)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, false, err
+	}
+
+	var tokens, elapsed float64
+	err = tx.QueryRowContext(ctx, `SELECT tokens, EXTRACT(EPOCH FROM now() - updated_at)
+		FROM rate_limits WHERE key = $1 FOR UPDATE`, key).Scan(&tokens, &elapsed)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, false, err
+	}
+
+	tokens = math.Min(float64(burst), tokens + elapsed * rate)
+	taken := false
+	if take && tokens >= 1 {
+		tokens--
+		taken = true
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE rate_limits SET tokens = $1, updated_at = now() WHERE key = $2`, tokens, key)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, false, err
+	}
+	return tokens, taken, nil
+}
+
+func DeleteRateLimit(ctx context.Context, key string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM rate_limits WHERE key = $1`, key)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// PruneRateLimits drops buckets that have been idle long enough to be full.
+func PruneRateLimits(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated_at < now() - interval '1 day'`)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
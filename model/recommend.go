@@ -0,0 +1,119 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// FollowCandidate is an account userId might want to follow, with the
+// signals that suggested it. Score is filled in by the ranking code.
+type FollowCandidate struct {
+	Id int64
+	Username string
+	DisplayName string
+	Bio string
+	MutualFollows int64
+	SharedLikes int64
+	Followers int64
+	Score float64
+}
+
+func scanFollowCandidates(ctx context.Context, result *sql.Rows) []FollowCandidate {
+	var candidates []FollowCandidate
+	for result.Next() {
+		var id, mutualFollows, sharedLikes, followers int64
+		var username string
+		var displayName, bio sql.NullString
+		err := result.Scan(&id, &username, &displayName, &bio, &mutualFollows, &sharedLikes, &followers)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		candidate := FollowCandidate{
+			Id: id,
+			Username: username,
+			DisplayName: nullStringToString(displayName),
+			Bio: nullStringToString(bio),
+			MutualFollows: mutualFollows,
+			SharedLikes: sharedLikes,
+			Followers: followers,
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// GetFollowCandidates finds accounts followed by the people userId follows
+// and accounts that liked the same Tweets as userId. Accounts userId already
+// follows, has asked to follow, blocked, muted or been blocked by are left out.
+func GetFollowCandidates(ctx context.Context, userId int64, limit int) ([]FollowCandidate, error) {
+	result, err := db.QueryContext(ctx, `WITH signals AS (
+			SELECT f2.followed AS candidate, 1 AS mutual, 0 AS shared
+			FROM follows f1
+			INNER JOIN follows f2
+			ON f2.follower = f1.followed
+			WHERE f1.follower = $1
+			UNION ALL
+			SELECT DISTINCT ON (l2.user_id, l2.tweet_id) l2.user_id, 0, 1
+			FROM likes l1
+			INNER JOIN likes l2
+			ON l2.tweet_id = l1.tweet_id AND l2.user_id != l1.user_id
+			WHERE l1.user_id = $1
+		), scored AS (
+			SELECT candidate, SUM(mutual) AS mutual, SUM(shared) AS shared
+			FROM signals
+			GROUP BY candidate
+		)
+		SELECT u.id, u.username, u.display_name, u.bio, s.mutual, s.shared,
+		(SELECT COUNT(*) FROM follows c WHERE c.followed = u.id) AS followers
+		FROM scored s
+		INNER JOIN users u
+		ON u.id = s.candidate
+		WHERE u.id != $1
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND NOT EXISTS (SELECT 1 FROM follows f WHERE f.follower = $1 AND f.followed = u.id)
+		AND NOT EXISTS (SELECT 1 FROM follow_requests fr WHERE fr.requester = $1 AND fr.requested = u.id)
+		AND NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter = $1 AND m.muted = u.id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = u.id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = u.id)
+		)
+		ORDER BY s.mutual + s.shared DESC
+		LIMIT $2`, userId, limit)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanFollowCandidates(ctx, result), nil
+}
+
+// GetPopularUsers returns the most followed accounts userId does not follow
+// yet. It is the fallback for new users with no follows or likes.
+func GetPopularUsers(ctx context.Context, userId int64, limit int) ([]FollowCandidate, error) {
+	result, err := db.QueryContext(ctx, `SELECT u.id, u.username, u.display_name, u.bio, 0, 0, COUNT(c.follower) AS followers
+		FROM users u
+		LEFT JOIN follows c
+		ON c.followed = u.id
+		WHERE u.id != $1
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND NOT EXISTS (SELECT 1 FROM follows f WHERE f.follower = $1 AND f.followed = u.id)
+		AND NOT EXISTS (SELECT 1 FROM follow_requests fr WHERE fr.requester = $1 AND fr.requested = u.id)
+		AND NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter = $1 AND m.muted = u.id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = u.id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = u.id)
+		)
+		GROUP BY u.id
+		ORDER BY followers DESC, u.id DESC
+		LIMIT $2`, userId, limit)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanFollowCandidates(ctx, result), nil
+}
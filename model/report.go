@@ -0,0 +1,409 @@
+package model
+
+import (
+	"context"
+	"time"
+	"errors"
+	"database/sql"
+	pq "github.com/lib/pq"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+const (
+	REPORT_TWEET = "tweet"
+	REPORT_USER = "user"
+	REPORT_MESSAGE = "message"
+
+	REPORT_OPEN = "open"
+	REPORT_RESOLVED = "resolved"
+
+	ACTION_DISMISS = "dismiss"
+	ACTION_DELETE_TWEET = "delete_tweet"
+	ACTION_WARN = "warn"
+	ACTION_SUSPEND = "suspend"
+	ACTION_UNSUSPEND = "unsuspend"
+	ACTION_SET_ROLE = "set_role"
+)
+
+var ErrOwnReport = errors.New("moderators cannot resolve their own reports")
+var ErrOutranked = errors.New("only accounts with a lower role can be suspended")
+
+// REPORT_CATEGORIES are the reasons a report can give, in the order the
+// report form lists them.
+var REPORT_CATEGORIES = []string{"spam", "harassment", "hate", "violence", "self_harm", "impersonation", "sensitive", "other"}
+
+// Report is a user's complaint about a Tweet, an account or a message.
+// Content is a copy of the reported text taken when the report was made, so
+// moderators can still see it if the original is deleted.
+type Report struct {
+	Id int64
+	Kind string
+	Category string
+	Comment string
+	Content string
+	TweetId int64
+	MessageId int64
+	ReporterUsername string
+	ReportedUserId int64
+	ReportedUsername string
+	Status string
+	Resolution string
+	CreatedAt string
+	ResolvedAt string
+}
+
+// ModerationAction is an entry in the audit trail of moderator decisions.
+type ModerationAction struct {
+	Id int64
+	ModeratorUsername string
+	ReportId int64
+	TargetUsername string
+	Action string
+	Reason string
+	CreatedAt string
+}
+
+// reportTargets select the tweet, message, reported user and content for each
+// kind of report, given the reporter in $1 and the target id in $2. People
+// cannot report themselves, and only members of a conversation can report
+// its messages.
+var reportTargets = map[string]string{
+	REPORT_TWEET: `SELECT t.id, NULL::integer, t.user_id, t.text
+		FROM tweets t WHERE t.id = $2 AND t.user_id != $1`,
+	REPORT_USER: `SELECT NULL::integer, NULL::integer, u.id, COALESCE(u.bio, '')
+		FROM users u WHERE u.id = $2 AND u.id != $1`,
+	REPORT_MESSAGE: `SELECT NULL::integer, m.id, m.sender_id, COALESCE(m.text, '')
+		FROM messages m WHERE m.id = $2 AND m.sender_id != $1
+		AND EXISTS (
+			SELECT 1 FROM conversations_users cu
+			WHERE cu.conversation_id = m.conversation_id AND cu.user_id = $1
+		)`,
+}
+
+// CreateReport files a report of the given kind about targetId, returning
+// sql.ErrNoRows when the target does not exist or cannot be reported by
+// reporterId.
+func CreateReport(ctx context.Context, reporterId int64, kind string, targetId int64, category, comment string) (int64, error) {
+	target, ok := reportTargets[kind]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	var id int64
+	err := db.QueryRowContext(ctx, `INSERT INTO reports
+		(reporter_id, kind, category, comment, tweet_id, message_id, reported_user_id, content)
+		SELECT $1::integer, $3::text, $4::text, $5::text, s.* FROM (` + target + `) s
+		RETURNING id`, reporterId, targetId, kind, category, comment).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Error(ctx, "Query failed.", "err", err)
+	}
+	return id, err
+}
+
+const reportColumns = `r.id, r.kind, r.category, r.comment, r.content, r.tweet_id, r.message_id,
+	COALESCE(reporter.username, ''), r.reported_user_id, COALESCE(reported.username, ''),
+	r.status, COALESCE(r.resolution, ''), r.created_at, r.resolved_at`
+
+const reportJoins = `LEFT JOIN users reporter
+	ON reporter.id = r.reporter_id
+	LEFT JOIN users reported
+	ON reported.id = r.reported_user_id`
+
+func queryReports(ctx context.Context, query string, args ...interface{}) ([]Report, error) {
+	result, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var reports []Report
+	for result.Next() {
+		var report Report
+		var comment sql.NullString
+		var tweetId, messageId, reportedUserId sql.NullInt64
+		var resolvedAt pq.NullTime
+		err := result.Scan(&report.Id, &report.Kind, &report.Category, &comment, &report.Content,
+			&tweetId, &messageId, &report.ReporterUsername, &reportedUserId, &report.ReportedUsername,
+			&report.Status, &report.Resolution, &report.CreatedAt, &resolvedAt)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		report.Comment = nullStringToString(comment)
+		report.TweetId = nullInt64ToInt64(tweetId)
+		report.MessageId = nullInt64ToInt64(messageId)
+		report.ReportedUserId = nullInt64ToInt64(reportedUserId)
+		if resolvedAt.Valid {
+			report.ResolvedAt = resolvedAt.Time.Format(time.RFC3339)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// GetOpenReports returns the review queue, oldest first.
+func GetOpenReports(ctx context.Context, limit, offset int) ([]Report, error) {
+	return queryReports(ctx, `SELECT ` + reportColumns + `
+		FROM reports r
+		` + reportJoins + `
+		WHERE r.status = $1
+		ORDER BY r.created_at ASC, r.id ASC
+		LIMIT $2 OFFSET $3`, REPORT_OPEN, limit, offset)
+}
+
+// GetResolvedReports returns the reports userId made that were resolved
+// after since, most recent first, so they can be told the outcome.
+func GetResolvedReports(ctx context.Context, userId int64, since time.Time) ([]Report, error) {
+	return queryReports(ctx, `SELECT ` + reportColumns + `
+		FROM reports r
+		` + reportJoins + `
+		WHERE r.reporter_id = $1 AND r.status = $2 AND r.resolved_at > $3
+		ORDER BY r.resolved_at DESC`, userId, REPORT_RESOLVED, since)
+}
+
+// ResolveReport closes an open report with one of the ACTION_ constants and
+// records the decision in the audit trail. Deleting a Tweet closes every
+// open report about it and returns the Tweet's image URL, if any, so the
+// upload can be removed. Suspending sets the reported account's suspension
+// until the given time, or indefinitely when until is zero. It returns
+// sql.ErrNoRows if the report does not exist, is already resolved, or the
+// action does not apply to it, ErrOwnReport if moderatorId made the report
+// and ErrOutranked if it would suspend someone whose role is not below
+// moderatorId's.
+func ResolveReport(ctx context.Context, reportId, moderatorId int64, action, reason string, until time.Time) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var kind string
+	var reporterId, tweetId, reportedUserId sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT kind, reporter_id, tweet_id, reported_user_id FROM reports
+		WHERE id = $1 AND status = $2 FOR UPDATE`, reportId, REPORT_OPEN).Scan(&kind, &reporterId, &tweetId, &reportedUserId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logging.Error(ctx, "Query failed.", "err", err)
+		}
+		return "", err
+	}
+	// Reports from deleted accounts keep a NULL reporter_id.
+	if reporterId.Valid && reporterId.Int64 == moderatorId {
+		return "", ErrOwnReport
+	}
+
+	switch action {
+	case ACTION_DISMISS, ACTION_WARN:
+	case ACTION_DELETE_TWEET:
+		if kind != REPORT_TWEET || !tweetId.Valid {
+			return "", sql.ErrNoRows
+		}
+	case ACTION_SUSPEND:
+		if !reportedUserId.Valid {
+			return "", sql.ErrNoRows
+		}
+		err = checkOutranks(ctx, tx, moderatorId, reportedUserId.Int64)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", sql.ErrNoRows
+	}
+
+	// Reports about a deleted Tweet lose their tweet_id, so close them first.
+	_, err = tx.ExecContext(ctx, `UPDATE reports SET status = $3, resolution = $4, resolved_at = now(), resolved_by = $5
+		WHERE id = $1 OR ($4 = $6 AND tweet_id = $2 AND status = $7)`,
+		reportId, tweetId, REPORT_RESOLVED, action, moderatorId, ACTION_DELETE_TWEET, REPORT_OPEN)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+
+	err = recordAction(ctx, tx, moderatorId, reportId, reportedUserId.Int64, action, reason)
+	if err != nil {
+		return "", err
+	}
+
+	var imageURL string
+	switch action {
+	case ACTION_DELETE_TWEET:
+		imageURL, err = deleteTweet(ctx, tx, tweetId.Int64)
+		if err != nil {
+			return "", err
+		}
+	case ACTION_SUSPEND:
+		err = suspendUser(ctx, tx, reportedUserId.Int64, reason, until)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return "", err
+	}
+	return imageURL, nil
+}
+
+func queryModerationActions(ctx context.Context, query string, args ...interface{}) ([]ModerationAction, error) {
+	result, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var actions []ModerationAction
+	for result.Next() {
+		var action ModerationAction
+		var reportId sql.NullInt64
+		var reason sql.NullString
+		err := result.Scan(&action.Id, &action.ModeratorUsername, &reportId, &action.TargetUsername,
+			&action.Action, &reason, &action.CreatedAt)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		action.ReportId = nullInt64ToInt64(reportId)
+		action.Reason = nullStringToString(reason)
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// GetModerationLog returns moderator decisions, most recent first.
+func GetModerationLog(ctx context.Context, limit, offset int) ([]ModerationAction, error) {
+	return queryModerationActions(ctx, `SELECT a.id, COALESCE(m.username, ''), a.report_id,
+		COALESCE(a.target_username, ''), a.action, a.reason, a.created_at
+		FROM moderation_actions a
+		LEFT JOIN users m
+		ON m.id = a.moderator_id
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $1 OFFSET $2`, limit, offset)
+}
+
+// GetWarnings returns the warnings userId has received since the given time.
+func GetWarnings(ctx context.Context, userId int64, since time.Time) ([]ModerationAction, error) {
+	return queryModerationActions(ctx, `SELECT a.id, '', a.report_id,
+		COALESCE(a.target_username, ''), a.action, a.reason, a.created_at
+		FROM moderation_actions a
+		WHERE a.target_user_id = $1 AND a.action = $2 AND a.created_at > $3
+		ORDER BY a.created_at DESC`, userId, ACTION_WARN, since)
+}
+
+// recordAction adds a decision to the audit trail. reportId is 0 for
+// actions taken outside the review queue, and moderatorId is 0 for those
+// taken from the command line.
+func recordAction(ctx context.Context, tx *instrumentedTx, moderatorId, reportId, targetUserId int64, action, reason string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO moderation_actions (moderator_id, report_id, target_user_id, target_username, action, reason)
+		SELECT NULLIF($1, 0), NULLIF($2, 0), u.id, u.username, $4, $5 FROM users u WHERE u.id = $3`,
+		moderatorId, reportId, targetUserId, action, reason)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// suspendUser suspends userId until the given time, or indefinitely when it
+// is zero.
+func suspendUser(ctx context.Context, tx *instrumentedTx, userId int64, reason string, until time.Time) error {
+	var maybeUntil pq.NullTime
+	if !until.IsZero() {
+		maybeUntil = pq.NullTime{Time: until, Valid: true}
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE users SET suspended_until = COALESCE($2, 'infinity'), suspension_reason = $3
+		WHERE id = $1`, userId, maybeUntil, reason)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// checkOutranks returns ErrOutranked unless moderatorId's role is above
+// userId's. Roles are compared as assigned, so suspending an admin does not
+// let a moderator act on them. moderatorId 0 is the command line, which may
+// do anything.
+func checkOutranks(ctx context.Context, tx *instrumentedTx, moderatorId, userId int64) error {
+	if moderatorId == 0 {
+		return nil
+	}
+	var moderatorRole, userRole string
+	err := tx.QueryRowContext(ctx, `SELECT m.role, u.role FROM users m, users u WHERE m.id = $1 AND u.id = $2`,
+		moderatorId, userId).Scan(&moderatorRole, &userRole)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logging.Error(ctx, "Query failed.", "err", err)
+		}
+		return err
+	}
+	if roleRank(moderatorRole) <= roleRank(userRole) {
+		return ErrOutranked
+	}
+	return nil
+}
+
+// moderate runs update and records it in the audit trail in one transaction.
+func moderate(ctx context.Context, moderatorId, userId int64, action, reason string, update func(tx *instrumentedTx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	err = update(tx)
+	if err != nil {
+		return err
+	}
+	err = recordAction(ctx, tx, moderatorId, 0, userId, action, reason)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// SuspendUser suspends userId outside the review queue, until the given time
+// or indefinitely when it is zero. It returns ErrOutranked if userId's role
+// is not below moderatorId's.
+func SuspendUser(ctx context.Context, moderatorId, userId int64, reason string, until time.Time) error {
+	return moderate(ctx, moderatorId, userId, ACTION_SUSPEND, reason, func(tx *instrumentedTx) error {
+		err := checkOutranks(ctx, tx, moderatorId, userId)
+		if err != nil {
+			return err
+		}
+		return suspendUser(ctx, tx, userId, reason, until)
+	})
+}
+
+// UnsuspendUser lifts any suspension on userId.
+func UnsuspendUser(ctx context.Context, moderatorId, userId int64, reason string) error {
+	return moderate(ctx, moderatorId, userId, ACTION_UNSUSPEND, reason, func(tx *instrumentedTx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE users SET suspended_until = NULL, suspension_reason = NULL WHERE id = $1`, userId)
+		if err != nil {
+			logging.Error(ctx, "Query failed.", "err", err)
+		}
+		return err
+	})
+}
+
+// IsSuspended reports whether userId is currently suspended.
+func IsSuspended(ctx context.Context, userId int64) (bool, error) {
+	var suspended bool
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(suspended_until > now(), false) FROM users WHERE id = $1`,
+		userId).Scan(&suspended)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return false, err
+	}
+	return suspended, nil
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// Roles, from least to most privileged. Moderators review reports; admins
+// can also suspend accounts directly, assign roles and see the dashboard.
+const (
+	ROLE_USER = "user"
+	ROLE_MODERATOR = "moderator"
+	ROLE_ADMIN = "admin"
+)
+
+var ROLES = []string{ROLE_USER, ROLE_MODERATOR, ROLE_ADMIN}
+
+func roleRank(role string) int {
+	for i, r := range ROLES {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidRole reports whether role is one of ROLES.
+func ValidRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+// RoleAtLeast reports whether role grants everything required does.
+func RoleAtLeast(role, required string) bool {
+	return ValidRole(required) && roleRank(role) >= roleRank(required)
+}
+
+// GetRole returns userId's role. Suspended and deactivated accounts have no
+// privileges while they are away, so they get ROLE_USER.
+func GetRole(ctx context.Context, userId int64) (string, error) {
+	var role string
+	err := db.QueryRowContext(ctx, `SELECT CASE
+		WHEN deactivated_at IS NOT NULL OR suspended_until > now() THEN $2
+		ELSE role END
+		FROM users WHERE id = $1`, userId, ROLE_USER).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Error(ctx, "Query failed.", "err", err)
+	}
+	return role, err
+}
+
+// HasRole reports whether userId holds required or a more privileged role.
+func HasRole(ctx context.Context, userId int64, required string) (bool, error) {
+	role, err := GetRole(ctx, userId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return RoleAtLeast(role, required), nil
+}
+
+// SetRole gives userId a new role and records who did it. adminId is 0 when
+// run from the command line, which is how the first admin is appointed.
+func SetRole(ctx context.Context, adminId, userId int64, role string) error {
+	return moderate(ctx, adminId, userId, ACTION_SET_ROLE, role, func(tx *instrumentedTx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE users SET role = $2 WHERE id = $1`, userId, role)
+		if err != nil {
+			logging.Error(ctx, "Query failed.", "err", err)
+		}
+		return err
+	})
+}
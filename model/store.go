@@ -0,0 +1,302 @@
+package model
+
+import (
+	"time"
+	"context"
+)
+
+// The store interfaces cover users and their account settings, Tweets with
+// pins and bookmarks, the social graph, messages and notifications.
+// Handlers that only use these, through api.Data, can run against
+// MemoryStore in tests instead of Postgres. Account tokens, deactivation,
+// profile images, website verification, renamed usernames, data exports,
+// lists and moderation still call the model functions directly. Both
+// implementations must pass the conformance suite in store_test.go.
+
+type UserStore interface {
+	CreateUser(ctx context.Context, username, password string) (int64, error)
+	// GetUserFromUsername returns sql.ErrNoRows if there is no such user.
+	GetUserFromUsername(ctx context.Context, username string) (User, error)
+	// GetUser returns sql.ErrNoRows if there is no such user.
+	GetUser(ctx context.Context, userId int64) (User, error)
+	GetUserIdFromUsername(ctx context.Context, username string) (int64, error)
+	EditUser(ctx context.Context, edits User) error
+	IsSuspended(ctx context.Context, userId int64) (bool, error)
+	// ChangeUsername returns ErrUsernameCooldown if userId was renamed less
+	// than cooldown ago and ErrUsernameTaken if username is in use.
+	ChangeUsername(ctx context.Context, userId int64, username string, cooldown time.Duration) error
+	// ChangePassword also bumps userId's session version.
+	ChangePassword(ctx context.Context, userId int64, password string) error
+	// GetSessionVersion returns sql.ErrNoRows if there is no such user.
+	GetSessionVersion(ctx context.Context, userId int64) (int64, error)
+	// SetEmail returns ErrEmailTaken if another account has the address.
+	SetEmail(ctx context.Context, userId int64, email string) error
+	DeleteUser(ctx context.Context, userId int64) error
+}
+
+type TweetStore interface {
+	CreateTweet(ctx context.Context, request TweetRequest) (int64, error)
+	DeleteTweet(ctx context.Context, tweetId int64) (string, error)
+	// GetTweet returns sql.ErrNoRows if userId can't see the Tweet.
+	GetTweet(ctx context.Context, tweetId, userId int64) (Tweet, error)
+	GetReplies(ctx context.Context, tweetId, userId int64) ([]Tweet, error)
+	GetFeed(ctx context.Context, userId int64) ([]Tweet, error)
+	GetHistory(ctx context.Context, userId, currentUserId int64) ([]Tweet, error)
+	GetLikes(ctx context.Context, userId, currentUserId int64) ([]Tweet, error)
+	CreateLike(ctx context.Context, userId, tweetId int64) (bool, error)
+	CreateRetweet(ctx context.Context, userId, tweetId int64) (bool, error)
+	// PinTweet returns sql.ErrNoRows if tweetId is not userId's.
+	PinTweet(ctx context.Context, userId, tweetId int64) error
+	UnpinTweet(ctx context.Context, userId, tweetId int64) error
+	CreateBookmark(ctx context.Context, userId, tweetId int64) error
+	DeleteBookmark(ctx context.Context, userId, tweetId int64) error
+	// GetBookmarks lists the bookmarked Tweets userId can still see whose
+	// text contains query, most recently saved first.
+	GetBookmarks(ctx context.Context, userId int64, query string, limit, offset int) ([]Tweet, error)
+}
+
+// GraphStore holds follows, follow requests, blocks and mutes.
+type GraphStore interface {
+	// CreateFollow reports false when followed is protected and only a
+	// follow request was recorded.
+	CreateFollow(ctx context.Context, followed, follower int64) (bool, error)
+	DeleteFollow(ctx context.Context, followed, follower int64) error
+	// ApproveFollowRequest is a no-op when requester has not asked to
+	// follow requested.
+	ApproveFollowRequest(ctx context.Context, requested, requester int64) error
+	DeleteFollowRequest(ctx context.Context, requested, requester int64) error
+	// GetFollowRequests lists who asked to follow userId, most recent first.
+	GetFollowRequests(ctx context.Context, userId int64) ([]User, error)
+	CountFollowRequests(ctx context.Context, userId int64) (int64, error)
+	CreateBlock(ctx context.Context, blocker, blocked int64) error
+	DeleteBlock(ctx context.Context, blocker, blocked int64) error
+	CreateMute(ctx context.Context, muter, muted int64) error
+	DeleteMute(ctx context.Context, muter, muted int64) error
+	// GetBlockedUsers and GetMutedUsers list the accounts userId blocked or
+	// muted, most recent first.
+	GetBlockedUsers(ctx context.Context, userId int64) ([]User, error)
+	GetMutedUsers(ctx context.Context, userId int64) ([]User, error)
+	CanSeeTweets(ctx context.Context, userId, currentUserId int64) (bool, error)
+	GetUsersRelationship(ctx context.Context, userId, currentUserId int64) (CrossUsers, error)
+	GetFollowers(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error)
+	GetFollowing(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error)
+	GetMutualFollowers(ctx context.Context, userId, currentUserId int64, limit int) ([]User, int64, error)
+}
+
+type MessageStore interface {
+	// GetTwoUsersConversation returns 0 if the two have no conversation.
+	GetTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error)
+	CreateTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error)
+	// CreateMessage returns sql.ErrNoRows if the sender isn't in the
+	// conversation or is blocked by someone who is.
+	CreateMessage(ctx context.Context, request MessageRequest) (int64, error)
+	GetConversation(ctx context.Context, conversationId int64) ([]Message, error)
+	GetConversations(ctx context.Context, userId int64) ([]Conversation, error)
+}
+
+type NotificationStore interface {
+	GetNotifications(ctx context.Context, userId int64) ([]Notification, error)
+}
+
+type Store interface {
+	UserStore
+	TweetStore
+	GraphStore
+	MessageStore
+	NotificationStore
+}
+
+// PostgresStore is the Store backed by the package's database.
+type PostgresStore struct{}
+
+func (PostgresStore) CreateUser(ctx context.Context, username, password string) (int64, error) {
+	return CreateUser(ctx, username, password)
+}
+
+func (PostgresStore) GetUserFromUsername(ctx context.Context, username string) (User, error) {
+	return GetUserFromUsername(ctx, username)
+}
+
+func (PostgresStore) GetUser(ctx context.Context, userId int64) (User, error) {
+	return GetUser(ctx, userId)
+}
+
+func (PostgresStore) GetUserIdFromUsername(ctx context.Context, username string) (int64, error) {
+	return GetUserIdFromUsername(ctx, username)
+}
+
+func (PostgresStore) EditUser(ctx context.Context, edits User) error {
+	return EditUser(ctx, edits)
+}
+
+func (PostgresStore) IsSuspended(ctx context.Context, userId int64) (bool, error) {
+	return IsSuspended(ctx, userId)
+}
+
+func (PostgresStore) ChangeUsername(ctx context.Context, userId int64, username string, cooldown time.Duration) error {
+	return ChangeUsername(ctx, userId, username, cooldown)
+}
+
+func (PostgresStore) ChangePassword(ctx context.Context, userId int64, password string) error {
+	return ChangePassword(ctx, userId, password)
+}
+
+func (PostgresStore) GetSessionVersion(ctx context.Context, userId int64) (int64, error) {
+	return GetSessionVersion(ctx, userId)
+}
+
+func (PostgresStore) SetEmail(ctx context.Context, userId int64, email string) error {
+	return SetEmail(ctx, userId, email)
+}
+
+func (PostgresStore) DeleteUser(ctx context.Context, userId int64) error {
+	return DeleteUser(ctx, userId)
+}
+
+func (PostgresStore) CreateTweet(ctx context.Context, request TweetRequest) (int64, error) {
+	return CreateTweet(ctx, request)
+}
+
+func (PostgresStore) DeleteTweet(ctx context.Context, tweetId int64) (string, error) {
+	return DeleteTweet(ctx, tweetId)
+}
+
+func (PostgresStore) GetTweet(ctx context.Context, tweetId, userId int64) (Tweet, error) {
+	return GetTweet(ctx, tweetId, userId)
+}
+
+func (PostgresStore) GetReplies(ctx context.Context, tweetId, userId int64) ([]Tweet, error) {
+	return GetReplies(ctx, tweetId, userId)
+}
+
+func (PostgresStore) GetFeed(ctx context.Context, userId int64) ([]Tweet, error) {
+	return GetFeed(ctx, userId)
+}
+
+func (PostgresStore) GetHistory(ctx context.Context, userId, currentUserId int64) ([]Tweet, error) {
+	return GetHistory(ctx, userId, currentUserId)
+}
+
+func (PostgresStore) GetLikes(ctx context.Context, userId, currentUserId int64) ([]Tweet, error) {
+	return GetLikes(ctx, userId, currentUserId)
+}
+
+func (PostgresStore) CreateLike(ctx context.Context, userId, tweetId int64) (bool, error) {
+	return CreateLike(ctx, userId, tweetId)
+}
+
+func (PostgresStore) CreateRetweet(ctx context.Context, userId, tweetId int64) (bool, error) {
+	return CreateRetweet(ctx, userId, tweetId)
+}
+
+func (PostgresStore) PinTweet(ctx context.Context, userId, tweetId int64) error {
+	return PinTweet(ctx, userId, tweetId)
+}
+
+func (PostgresStore) UnpinTweet(ctx context.Context, userId, tweetId int64) error {
+	return UnpinTweet(ctx, userId, tweetId)
+}
+
+func (PostgresStore) CreateBookmark(ctx context.Context, userId, tweetId int64) error {
+	return CreateBookmark(ctx, userId, tweetId)
+}
+
+func (PostgresStore) DeleteBookmark(ctx context.Context, userId, tweetId int64) error {
+	return DeleteBookmark(ctx, userId, tweetId)
+}
+
+func (PostgresStore) GetBookmarks(ctx context.Context, userId int64, query string, limit, offset int) ([]Tweet, error) {
+	return GetBookmarks(ctx, userId, query, limit, offset)
+}
+
+func (PostgresStore) CreateFollow(ctx context.Context, followed, follower int64) (bool, error) {
+	return CreateFollow(ctx, followed, follower)
+}
+
+func (PostgresStore) DeleteFollow(ctx context.Context, followed, follower int64) error {
+	return DeleteFollow(ctx, followed, follower)
+}
+
+func (PostgresStore) ApproveFollowRequest(ctx context.Context, requested, requester int64) error {
+	return ApproveFollowRequest(ctx, requested, requester)
+}
+
+func (PostgresStore) DeleteFollowRequest(ctx context.Context, requested, requester int64) error {
+	return DeleteFollowRequest(ctx, requested, requester)
+}
+
+func (PostgresStore) GetFollowRequests(ctx context.Context, userId int64) ([]User, error) {
+	return GetFollowRequests(ctx, userId)
+}
+
+func (PostgresStore) CountFollowRequests(ctx context.Context, userId int64) (int64, error) {
+	return CountFollowRequests(ctx, userId)
+}
+
+func (PostgresStore) CreateBlock(ctx context.Context, blocker, blocked int64) error {
+	return CreateBlock(ctx, blocker, blocked)
+}
+
+func (PostgresStore) DeleteBlock(ctx context.Context, blocker, blocked int64) error {
+	return DeleteBlock(ctx, blocker, blocked)
+}
+
+func (PostgresStore) CreateMute(ctx context.Context, muter, muted int64) error {
+	return CreateMute(ctx, muter, muted)
+}
+
+func (PostgresStore) DeleteMute(ctx context.Context, muter, muted int64) error {
+	return DeleteMute(ctx, muter, muted)
+}
+
+func (PostgresStore) GetBlockedUsers(ctx context.Context, userId int64) ([]User, error) {
+	return GetBlockedUsers(ctx, userId)
+}
+
+func (PostgresStore) GetMutedUsers(ctx context.Context, userId int64) ([]User, error) {
+	return GetMutedUsers(ctx, userId)
+}
+
+func (PostgresStore) CanSeeTweets(ctx context.Context, userId, currentUserId int64) (bool, error) {
+	return CanSeeTweets(ctx, userId, currentUserId)
+}
+
+func (PostgresStore) GetUsersRelationship(ctx context.Context, userId, currentUserId int64) (CrossUsers, error) {
+	return GetUsersRelationship(ctx, userId, currentUserId)
+}
+
+func (PostgresStore) GetFollowers(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error) {
+	return GetFollowers(ctx, userId, currentUserId, limit, offset)
+}
+
+func (PostgresStore) GetFollowing(ctx context.Context, userId, currentUserId int64, limit, offset int) ([]UserListItem, error) {
+	return GetFollowing(ctx, userId, currentUserId, limit, offset)
+}
+
+func (PostgresStore) GetMutualFollowers(ctx context.Context, userId, currentUserId int64, limit int) ([]User, int64, error) {
+	return GetMutualFollowers(ctx, userId, currentUserId, limit)
+}
+
+func (PostgresStore) GetTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error) {
+	return GetTwoUsersConversation(ctx, userId, currentUserId)
+}
+
+func (PostgresStore) CreateTwoUsersConversation(ctx context.Context, userId, currentUserId int64) (int64, error) {
+	return CreateTwoUsersConversation(ctx, userId, currentUserId)
+}
+
+func (PostgresStore) CreateMessage(ctx context.Context, request MessageRequest) (int64, error) {
+	return SmartCreateUser(ctx, request)
+}
+
+func (PostgresStore) GetConversation(ctx context.Context, conversationId int64) ([]Message, error) {
+	return GetConversation(ctx, conversationId)
+}
+
+func (PostgresStore) GetConversations(ctx context.Context, userId int64) ([]Conversation, error) {
+	return GetConversations(ctx, userId)
+}
+
+func (PostgresStore) GetNotifications(ctx context.Context, userId int64) ([]Notification, error) {
+	return GetNotifications(ctx, userId)
+}
@@ -0,0 +1,806 @@
+package model_test
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"reflect"
+	"testing"
+	"database/sql"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+// The conformance suite every model.Store must pass. Tests only touch the
+// users they create, so they can share the schema TestMain sets up.
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func() model.Store {
+		return model.NewMemoryStore()
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	if !haveDatabase {
+		t.Skipf("%s is not set", TEST_DATABASE_ENV)
+	}
+	testStore(t, func() model.Store {
+		return model.PostgresStore{}
+	})
+}
+
+type storeTest struct {
+	*testing.T
+	store model.Store
+	prefix string
+	userIds []int64
+}
+
+func testStore(t *testing.T, newStore func() model.Store) {
+	tests := []struct {
+		name string
+		run func(s *storeTest)
+	}{
+		{"Users", testUsers},
+		{"AccountSettings", testAccountSettings},
+		{"DeleteUser", testDeleteUser},
+		{"Tweets", testTweets},
+		{"Pins", testPins},
+		{"Bookmarks", testBookmarks},
+		{"LikesAndRetweets", testLikesAndRetweets},
+		{"Feed", testFeed},
+		{"Protected", testProtected},
+		{"FollowRequests", testFollowRequests},
+		{"Blocks", testBlocks},
+		{"FollowLists", testFollowLists},
+		{"Messages", testMessages},
+		{"Notifications", testNotifications},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			s := &storeTest{
+				T: t,
+				store: newStore(),
+				prefix: fmt.Sprintf("t%d_", time.Now().UnixNano() % 1e9),
+			}
+			defer func() {
+				for i := len(s.userIds) - 1; i >= 0; i-- {
+					err := s.store.DeleteUser(ctx, s.userIds[i])
+					if err != nil {
+						t.Errorf("Could not delete user %d: %v", s.userIds[i], err)
+					}
+				}
+			}()
+			test.run(s)
+		})
+	}
+}
+
+func (s *storeTest) check(err error) {
+	s.Helper()
+	if err != nil {
+		s.Fatal(err)
+	}
+}
+
+// user creates an account with a name unique to this run.
+func (s *storeTest) user(name string) int64 {
+	s.Helper()
+	userId, err := s.store.CreateUser(ctx, s.prefix + name, "password")
+	s.check(err)
+	s.userIds = append(s.userIds, userId)
+	return userId
+}
+
+func (s *storeTest) username(userId int64) string {
+	for i, id := range s.userIds {
+		if id == userId {
+			return s.prefix + []string{"a", "b", "c", "d", "e"}[i]
+		}
+	}
+	s.Fatalf("Unknown user %d", userId)
+	return ""
+}
+
+// users creates up to five accounts, named a to e.
+func (s *storeTest) users(n int) []int64 {
+	s.Helper()
+	var userIds []int64
+	for i := 0; i < n; i++ {
+		userIds = append(userIds, s.user([]string{"a", "b", "c", "d", "e"}[i]))
+	}
+	return userIds
+}
+
+func (s *storeTest) tweet(userId int64, text string) int64 {
+	s.Helper()
+	tweetId, err := s.store.CreateTweet(ctx, model.TweetRequest{UserId: userId, Text: text})
+	s.check(err)
+	return tweetId
+}
+
+func (s *storeTest) follow(followed, follower int64) {
+	s.Helper()
+	following, err := s.store.CreateFollow(ctx, followed, follower)
+	s.check(err)
+	if !following {
+		s.Fatalf("%d should be following %d", follower, followed)
+	}
+}
+
+func (s *storeTest) relationship(userId, currentUserId int64) model.CrossUsers {
+	s.Helper()
+	relationship, err := s.store.GetUsersRelationship(ctx, userId, currentUserId)
+	s.check(err)
+	return relationship
+}
+
+func (s *storeTest) protect(userId int64, protected bool) {
+	s.Helper()
+	s.check(s.store.EditUser(ctx, model.User{Id: userId, Protected: protected}))
+}
+
+// expectTweets checks tweets are exactly tweetIds, in order.
+func (s *storeTest) expectTweets(what string, tweets []model.Tweet, err error, tweetIds ...int64) {
+	s.Helper()
+	s.check(err)
+	var got []int64
+	for _, tweet := range tweets {
+		got = append(got, tweet.Id)
+	}
+	if !reflect.DeepEqual(got, tweetIds) {
+		s.Errorf("%s: got Tweets %v, want %v", what, got, tweetIds)
+	}
+}
+
+func (s *storeTest) expectUsers(what string, users []model.UserListItem, err error, userIds ...int64) {
+	s.Helper()
+	s.check(err)
+	var got []int64
+	for _, user := range users {
+		got = append(got, user.Id)
+	}
+	if !reflect.DeepEqual(got, userIds) {
+		s.Errorf("%s: got users %v, want %v", what, got, userIds)
+	}
+}
+
+func (s *storeTest) expectAccounts(what string, users []model.User, err error, userIds ...int64) {
+	s.Helper()
+	s.check(err)
+	var got []int64
+	for _, user := range users {
+		got = append(got, user.Id)
+	}
+	if !reflect.DeepEqual(got, userIds) {
+		s.Errorf("%s: got users %v, want %v", what, got, userIds)
+	}
+}
+
+func (s *storeTest) canSee(userId, currentUserId int64) bool {
+	s.Helper()
+	canSee, err := s.store.CanSeeTweets(ctx, userId, currentUserId)
+	s.check(err)
+	return canSee
+}
+
+func (s *storeTest) expectErr(what string, err, want error) {
+	s.Helper()
+	if err != want {
+		s.Errorf("%s: got error %v, want %v", what, err, want)
+	}
+}
+
+func testUsers(s *storeTest) {
+	userId := s.user("a")
+
+	user, err := s.store.GetUserFromUsername(ctx, s.prefix + "a")
+	s.check(err)
+	if user.Id != userId || user.Username != s.prefix + "a" || user.Password != "password" {
+		s.Errorf("Got user %+v", user)
+	}
+	if user.Role != model.ROLE_USER || user.Protected || user.Deactivated || user.Suspended || user.CreatedAt == "" {
+		s.Errorf("New user has unexpected settings: %+v", user)
+	}
+
+	byId, err := s.store.GetUser(ctx, userId)
+	s.check(err)
+	if byId != user {
+		s.Errorf("Got user %+v by ID, want %+v", byId, user)
+	}
+
+	id, err := s.store.GetUserIdFromUsername(ctx, s.prefix + "a")
+	s.check(err)
+	if id != userId {
+		s.Errorf("Got user ID %d, want %d", id, userId)
+	}
+
+	_, err = s.store.CreateUser(ctx, s.prefix + "a", "password")
+	if err == nil {
+		s.Error("Creating a user with a taken username should fail")
+	}
+	_, err = s.store.GetUserFromUsername(ctx, s.prefix + "nobody")
+	s.expectErr("Missing user", err, sql.ErrNoRows)
+	_, err = s.store.GetUserIdFromUsername(ctx, s.prefix + "nobody")
+	s.expectErr("Missing user ID", err, sql.ErrNoRows)
+	_, err = s.store.GetUser(ctx, -1)
+	s.expectErr("Missing user by ID", err, sql.ErrNoRows)
+
+	s.check(s.store.EditUser(ctx, model.User{
+		Id: userId,
+		DisplayName: "Ada",
+		Bio: "Hello",
+		Location: "Lisbon",
+		Website: "https://example.com",
+		Birthday: "1990-12-10",
+	}))
+	user, err = s.store.GetUserFromUsername(ctx, s.prefix + "a")
+	s.check(err)
+	if user.DisplayName != "Ada" || user.Bio != "Hello" || user.Location != "Lisbon" ||
+		user.Website != "https://example.com" || user.Birthday != "1990-12-10" {
+		s.Errorf("Edits were not saved: %+v", user)
+	}
+	s.expectErr("Editing a missing user", s.store.EditUser(ctx, model.User{Id: -1}), sql.ErrNoRows)
+
+	suspended, err := s.store.IsSuspended(ctx, userId)
+	s.check(err)
+	if suspended {
+		s.Error("New user should not be suspended")
+	}
+}
+
+func testAccountSettings(s *storeTest) {
+	users := s.users(2)
+	a, b := users[0], users[1]
+
+	before, err := s.store.GetSessionVersion(ctx, a)
+	s.check(err)
+	s.check(s.store.ChangePassword(ctx, a, "new password"))
+	user, err := s.store.GetUser(ctx, a)
+	s.check(err)
+	if user.Password != "new password" {
+		s.Errorf("Got password %q", user.Password)
+	}
+	after, err := s.store.GetSessionVersion(ctx, a)
+	s.check(err)
+	if after == before {
+		s.Errorf("Session version stayed %d after changing the password", after)
+	}
+	_, err = s.store.GetSessionVersion(ctx, -1)
+	s.expectErr("Missing user's session version", err, sql.ErrNoRows)
+
+	email := s.prefix + "a@example.com"
+	s.check(s.store.SetEmail(ctx, a, email))
+	user, err = s.store.GetUser(ctx, a)
+	s.check(err)
+	if user.Email != email || user.EmailVerified {
+		s.Errorf("Got email %q, verified %v", user.Email, user.EmailVerified)
+	}
+	err = s.store.SetEmail(ctx, b, strings.ToUpper(email))
+	s.expectErr("Taking an address in another case", err, model.ErrEmailTaken)
+	s.check(s.store.SetEmail(ctx, a, ""))
+	s.check(s.store.SetEmail(ctx, b, email))
+
+	err = s.store.ChangeUsername(ctx, a, s.prefix + "b", time.Hour)
+	s.expectErr("Taking a username", err, model.ErrUsernameTaken)
+	s.check(s.store.ChangeUsername(ctx, a, s.prefix + "renamed", time.Hour))
+	userId, err := s.store.GetUserIdFromUsername(ctx, s.prefix + "renamed")
+	s.check(err)
+	if userId != a {
+		s.Errorf("New username belongs to %d, want %d", userId, a)
+	}
+	_, err = s.store.GetUserIdFromUsername(ctx, s.prefix + "a")
+	s.expectErr("Old username", err, sql.ErrNoRows)
+	// Keeping the same name is not a change.
+	s.check(s.store.ChangeUsername(ctx, a, s.prefix + "renamed", time.Hour))
+	err = s.store.ChangeUsername(ctx, a, s.prefix + "again", time.Hour)
+	s.expectErr("Renaming again", err, model.ErrUsernameCooldown)
+}
+
+func testDeleteUser(s *storeTest) {
+	users := s.users(3)
+	gone, other, fan := users[0], users[1], users[2]
+	tweetId := s.tweet(gone, "Goodbye")
+	replyId, err := s.store.CreateTweet(ctx, model.TweetRequest{UserId: other, Text: "Bye", ParentId: tweetId})
+	s.check(err)
+	otherId := s.tweet(other, "Still here")
+	s.follow(other, gone)
+	_, err = s.store.CreateLike(ctx, gone, otherId)
+	s.check(err)
+	s.check(s.store.CreateBookmark(ctx, fan, tweetId))
+	conversationId, err := s.store.CreateTwoUsersConversation(ctx, gone, other)
+	s.check(err)
+	_, err = s.store.CreateMessage(ctx, model.MessageRequest{SenderId: gone, Text: "hi", ConversationId: conversationId})
+	s.check(err)
+
+	s.check(s.store.DeleteUser(ctx, gone))
+	_, err = s.store.GetUser(ctx, gone)
+	s.expectErr("Deleted user", err, sql.ErrNoRows)
+	_, err = s.store.GetTweet(ctx, tweetId, fan)
+	s.expectErr("Deleted user's Tweet", err, sql.ErrNoRows)
+	// Replies to their Tweets stay.
+	_, err = s.store.GetTweet(ctx, replyId, fan)
+	s.check(err)
+	if followers := s.relationship(other, fan).Followers; followers != 0 {
+		s.Errorf("Got %d followers after the follower was deleted", followers)
+	}
+	notifications, err := s.store.GetNotifications(ctx, other)
+	s.check(err)
+	if len(notifications) != 0 {
+		s.Errorf("Got notifications %+v from a deleted user", notifications)
+	}
+	bookmarks, err := s.store.GetBookmarks(ctx, fan, "", 20, 0)
+	s.expectTweets("Bookmarks", bookmarks, err)
+	messages, err := s.store.GetConversation(ctx, conversationId)
+	s.check(err)
+	if len(messages) != 0 {
+		s.Errorf("Got messages %+v from a deleted user", messages)
+	}
+}
+
+func testTweets(s *storeTest) {
+	users := s.users(2)
+	author, reader := users[0], users[1]
+	s.check(s.store.EditUser(ctx, model.User{Id: author, DisplayName: "Author"}))
+
+	tweetId, err := s.store.CreateTweet(ctx, model.TweetRequest{UserId: author, Text: "Hello #world", ImageURL: "https://example.com/a.png"})
+	s.check(err)
+	tweet, err := s.store.GetTweet(ctx, tweetId, reader)
+	s.check(err)
+	if tweet.Id != tweetId || tweet.Username != s.username(author) || tweet.Text != "Hello #world" ||
+		tweet.ImageURL != "https://example.com/a.png" || tweet.DisplayName != "Author" || tweet.Date == "" {
+		s.Errorf("Got Tweet %+v", tweet)
+	}
+	if tweet.Liked || tweet.Retweeted || tweet.Bookmarked || tweet.Pinned {
+		s.Errorf("New Tweet has unexpected flags: %+v", tweet)
+	}
+
+	replyId, err := s.store.CreateTweet(ctx, model.TweetRequest{UserId: reader, Text: "Hi back", ParentId: tweetId})
+	s.check(err)
+	replies, err := s.store.GetReplies(ctx, tweetId, author)
+	s.expectTweets("Replies", replies, err, replyId)
+	history, err := s.store.GetHistory(ctx, author, reader)
+	s.expectTweets("Author's history", history, err, tweetId)
+	history, err = s.store.GetHistory(ctx, reader, reader)
+	s.expectTweets("Replier's history", history, err, replyId)
+
+	imageURL, err := s.store.DeleteTweet(ctx, tweetId)
+	s.check(err)
+	if imageURL != "https://example.com/a.png" {
+		s.Errorf("Deleting returned image %q", imageURL)
+	}
+	_, err = s.store.GetTweet(ctx, tweetId, reader)
+	s.expectErr("Deleted Tweet", err, sql.ErrNoRows)
+	_, err = s.store.DeleteTweet(ctx, tweetId)
+	s.expectErr("Deleting twice", err, sql.ErrNoRows)
+	// Replies outlive what they replied to.
+	_, err = s.store.GetTweet(ctx, replyId, author)
+	s.check(err)
+}
+
+func testPins(s *storeTest) {
+	users := s.users(2)
+	a, b := users[0], users[1]
+	tweetId := s.tweet(a, "Pin me")
+	otherId := s.tweet(b, "Not yours")
+
+	err := s.store.PinTweet(ctx, a, otherId)
+	s.expectErr("Pinning someone else's Tweet", err, sql.ErrNoRows)
+	s.check(s.store.PinTweet(ctx, a, tweetId))
+	tweet, err := s.store.GetTweet(ctx, tweetId, b)
+	s.check(err)
+	if !tweet.Pinned {
+		s.Error("Tweet was not pinned")
+	}
+	history, err := s.store.GetHistory(ctx, a, b)
+	s.check(err)
+	if len(history) != 1 || !history[0].Pinned {
+		s.Errorf("History does not show the pin: %+v", history)
+	}
+
+	// Unpinning another Tweet leaves the pin alone.
+	s.check(s.store.UnpinTweet(ctx, a, otherId))
+	tweet, err = s.store.GetTweet(ctx, tweetId, b)
+	s.check(err)
+	if !tweet.Pinned {
+		s.Error("Unpinning a different Tweet removed the pin")
+	}
+	s.check(s.store.UnpinTweet(ctx, a, tweetId))
+	tweet, err = s.store.GetTweet(ctx, tweetId, b)
+	s.check(err)
+	if tweet.Pinned {
+		s.Error("Tweet is still pinned")
+	}
+}
+
+func testBookmarks(s *storeTest) {
+	users := s.users(3)
+	reader, a, b := users[0], users[1], users[2]
+	first := s.tweet(a, "First, 100%")
+	second := s.tweet(b, "Second")
+	third := s.tweet(a, "Third")
+	for _, tweetId := range []int64{first, second, third, first} {
+		s.check(s.store.CreateBookmark(ctx, reader, tweetId))
+	}
+
+	bookmarks, err := s.store.GetBookmarks(ctx, reader, "", 20, 0)
+	s.expectTweets("Bookmarks", bookmarks, err, third, second, first)
+	if len(bookmarks) == 3 && !bookmarks[0].Bookmarked {
+		s.Errorf("Bookmark is not flagged: %+v", bookmarks[0])
+	}
+	bookmarks, err = s.store.GetBookmarks(ctx, reader, "", 1, 1)
+	s.expectTweets("Second page of bookmarks", bookmarks, err, second)
+	tweet, err := s.store.GetTweet(ctx, first, reader)
+	s.check(err)
+	if !tweet.Bookmarked {
+		s.Error("Bookmarked Tweet is not flagged")
+	}
+	tweet, err = s.store.GetTweet(ctx, first, a)
+	s.check(err)
+	if tweet.Bookmarked {
+		s.Error("Bookmarks should only show for the user who saved them")
+	}
+
+	bookmarks, err = s.store.GetBookmarks(ctx, reader, "SECOND", 20, 0)
+	s.expectTweets("Search ignoring case", bookmarks, err, second)
+	bookmarks, err = s.store.GetBookmarks(ctx, reader, "%", 20, 0)
+	s.expectTweets("Search for a literal %", bookmarks, err, first)
+	bookmarks, err = s.store.GetBookmarks(ctx, reader, "_", 20, 0)
+	s.expectTweets("Search for a literal _", bookmarks, err)
+
+	// Tweets the reader can no longer see drop out.
+	s.check(s.store.CreateBlock(ctx, b, reader))
+	bookmarks, err = s.store.GetBookmarks(ctx, reader, "", 20, 0)
+	s.expectTweets("Bookmarks after a block", bookmarks, err, third, first)
+	s.check(s.store.DeleteBlock(ctx, b, reader))
+	s.protect(a, true)
+	bookmarks, err = s.store.GetBookmarks(ctx, reader, "", 20, 0)
+	s.expectTweets("Bookmarks of a protected account", bookmarks, err, second)
+	s.protect(a, false)
+
+	s.check(s.store.DeleteBookmark(ctx, reader, second))
+	_, err = s.store.DeleteTweet(ctx, third)
+	s.check(err)
+	bookmarks, err = s.store.GetBookmarks(ctx, reader, "", 20, 0)
+	s.expectTweets("Bookmarks after removing", bookmarks, err, first)
+}
+
+func testLikesAndRetweets(s *storeTest) {
+	users := s.users(3)
+	author, fan, other := users[0], users[1], users[2]
+	first := s.tweet(author, "First")
+	second := s.tweet(author, "Second")
+
+	liked, err := s.store.CreateLike(ctx, fan, first)
+	s.check(err)
+	if !liked {
+		s.Error("Like was not created")
+	}
+	tweet, err := s.store.GetTweet(ctx, first, fan)
+	s.check(err)
+	if !tweet.Liked || tweet.Retweeted {
+		s.Errorf("Liker sees %+v", tweet)
+	}
+	tweet, err = s.store.GetTweet(ctx, first, other)
+	s.check(err)
+	if tweet.Liked {
+		s.Error("Likes should only show for the user who liked")
+	}
+	likes, err := s.store.GetLikes(ctx, fan, other)
+	s.expectTweets("Likes", likes, err, first)
+
+	retweeted, err := s.store.CreateRetweet(ctx, fan, second)
+	s.check(err)
+	if !retweeted {
+		s.Error("Retweet was not created")
+	}
+	tweet, err = s.store.GetTweet(ctx, second, fan)
+	s.check(err)
+	if !tweet.Retweeted {
+		s.Error("Retweeter should see the Tweet as retweeted")
+	}
+	history, err := s.store.GetHistory(ctx, fan, other)
+	s.expectTweets("Retweeter's history", history, err, second)
+
+	// Retweets by others don't repeat the author's Tweets.
+	_, err = s.store.CreateRetweet(ctx, other, second)
+	s.check(err)
+	history, err = s.store.GetHistory(ctx, author, fan)
+	s.expectTweets("Author's history", history, err, second, first)
+}
+
+func testFeed(s *storeTest) {
+	users := s.users(4)
+	reader, a, b, c := users[0], users[1], users[2], users[3]
+	s.follow(a, reader)
+	s.follow(b, reader)
+	first := s.tweet(a, "First")
+	second := s.tweet(b, "Second")
+	third := s.tweet(a, "Third")
+	s.tweet(c, "Not followed")
+
+	feed, err := s.store.GetFeed(ctx, reader)
+	s.expectTweets("Feed", feed, err, third, second, first)
+
+	s.check(s.store.CreateMute(ctx, reader, b))
+	if !s.relationship(b, reader).SecondMutesFirst {
+		s.Error("Mute was not recorded")
+	}
+	s.check(s.store.CreateMute(ctx, reader, c))
+	muted, err := s.store.GetMutedUsers(ctx, reader)
+	s.expectAccounts("Muted users", muted, err, c, b)
+	s.check(s.store.DeleteMute(ctx, reader, c))
+	feed, err = s.store.GetFeed(ctx, reader)
+	s.expectTweets("Feed after muting", feed, err, third, first)
+	s.check(s.store.DeleteMute(ctx, reader, b))
+	feed, err = s.store.GetFeed(ctx, reader)
+	s.expectTweets("Feed after unmuting", feed, err, third, second, first)
+	muted, err = s.store.GetMutedUsers(ctx, reader)
+	s.expectAccounts("Muted users after unmuting", muted, err)
+
+	s.check(s.store.DeleteFollow(ctx, a, reader))
+	feed, err = s.store.GetFeed(ctx, reader)
+	s.expectTweets("Feed after unfollowing", feed, err, second)
+}
+
+func testProtected(s *storeTest) {
+	users := s.users(3)
+	owner, fan, author := users[0], users[1], users[2]
+	publicId := s.tweet(author, "Public")
+	s.protect(owner, true)
+	tweetId := s.tweet(owner, "Followers only")
+	_, err := s.store.CreateLike(ctx, owner, publicId)
+	s.check(err)
+	_, err = s.store.CreateRetweet(ctx, owner, publicId)
+	s.check(err)
+
+	following, err := s.store.CreateFollow(ctx, owner, fan)
+	s.check(err)
+	if following {
+		s.Error("Following a protected account should only request to")
+	}
+	relationship := s.relationship(owner, fan)
+	if !relationship.SecondRequestedFirst || relationship.SecondFollowsFirst {
+		s.Errorf("After requesting: %+v", relationship)
+	}
+	_, err = s.store.GetTweet(ctx, tweetId, fan)
+	s.expectErr("Protected Tweet", err, sql.ErrNoRows)
+	if s.canSee(owner, fan) || !s.canSee(owner, owner) {
+		s.Error("Only the owner should see a protected account's Tweets")
+	}
+	// What a protected account retweets and likes is hidden too, even
+	// when the Tweets themselves are public.
+	history, err := s.store.GetHistory(ctx, owner, fan)
+	s.expectTweets("Protected history", history, err)
+	likes, err := s.store.GetLikes(ctx, owner, fan)
+	s.expectTweets("Protected likes", likes, err)
+	_, err = s.store.GetTweet(ctx, tweetId, owner)
+	s.check(err)
+	history, err = s.store.GetHistory(ctx, owner, owner)
+	s.expectTweets("Own protected history", history, err, tweetId, publicId)
+
+	// Unprotecting approves pending requests.
+	s.protect(owner, false)
+	relationship = s.relationship(owner, fan)
+	if relationship.SecondRequestedFirst || !relationship.SecondFollowsFirst {
+		s.Errorf("After unprotecting: %+v", relationship)
+	}
+	_, err = s.store.GetTweet(ctx, tweetId, fan)
+	s.check(err)
+	if !s.canSee(owner, fan) {
+		s.Error("Followers should see a protected account's Tweets")
+	}
+	likes, err = s.store.GetLikes(ctx, owner, fan)
+	s.expectTweets("Likes after unprotecting", likes, err, publicId)
+}
+
+func testFollowRequests(s *storeTest) {
+	users := s.users(4)
+	owner, first, second, third := users[0], users[1], users[2], users[3]
+	s.protect(owner, true)
+	for _, requester := range []int64{first, second, third} {
+		_, err := s.store.CreateFollow(ctx, owner, requester)
+		s.check(err)
+	}
+
+	requests, err := s.store.GetFollowRequests(ctx, owner)
+	s.expectAccounts("Follow requests", requests, err, third, second, first)
+	count, err := s.store.CountFollowRequests(ctx, owner)
+	s.check(err)
+	if count != 3 {
+		s.Errorf("Got %d follow requests", count)
+	}
+
+	s.check(s.store.ApproveFollowRequest(ctx, owner, first))
+	s.check(s.store.DeleteFollowRequest(ctx, owner, second))
+	// Approving someone who never asked does nothing.
+	s.check(s.store.ApproveFollowRequest(ctx, owner, second))
+	if !s.relationship(owner, first).SecondFollowsFirst {
+		s.Error("Approved request did not become a follow")
+	}
+	if relationship := s.relationship(owner, second); relationship.SecondFollowsFirst || relationship.SecondRequestedFirst {
+		s.Errorf("After denying: %+v", relationship)
+	}
+	count, err = s.store.CountFollowRequests(ctx, owner)
+	s.check(err)
+	if count != 1 {
+		s.Errorf("Got %d follow requests after approving and denying", count)
+	}
+}
+
+func testBlocks(s *storeTest) {
+	users := s.users(3)
+	a, b, c := users[0], users[1], users[2]
+	tweetId := s.tweet(a, "Hello")
+	s.follow(a, b)
+	s.follow(b, a)
+
+	s.check(s.store.CreateBlock(ctx, a, b))
+	relationship := s.relationship(a, b)
+	if !relationship.FirstBlocksSecond || relationship.SecondBlocksFirst {
+		s.Errorf("After blocking: %+v", relationship)
+	}
+	if relationship.FirstFollowsSecond || relationship.SecondFollowsFirst {
+		s.Errorf("Blocking should remove follows both ways: %+v", relationship)
+	}
+	if s.canSee(a, b) || s.canSee(b, a) || !s.canSee(a, a) {
+		s.Error("Blocks should hide Tweets both ways")
+	}
+	s.check(s.store.CreateBlock(ctx, a, c))
+	blocked, err := s.store.GetBlockedUsers(ctx, a)
+	s.expectAccounts("Blocked users", blocked, err, c, b)
+	blocked, err = s.store.GetBlockedUsers(ctx, b)
+	s.expectAccounts("Blocked users of the blocked", blocked, err)
+
+	_, err = s.store.CreateFollow(ctx, a, b)
+	s.expectErr("Following", err, model.ErrBlocked)
+	_, err = s.store.CreateLike(ctx, b, tweetId)
+	s.expectErr("Liking", err, model.ErrBlocked)
+	_, err = s.store.CreateRetweet(ctx, b, tweetId)
+	s.expectErr("Retweeting", err, model.ErrBlocked)
+	_, err = s.store.CreateTweet(ctx, model.TweetRequest{UserId: b, Text: "Reply", ParentId: tweetId})
+	s.expectErr("Replying", err, model.ErrBlocked)
+	_, err = s.store.CreateTwoUsersConversation(ctx, a, b)
+	s.expectErr("Starting a conversation", err, model.ErrBlocked)
+	_, err = s.store.GetTweet(ctx, tweetId, b)
+	s.expectErr("Reading", err, sql.ErrNoRows)
+
+	s.check(s.store.DeleteBlock(ctx, a, b))
+	_, err = s.store.CreateLike(ctx, b, tweetId)
+	s.check(err)
+	if !s.canSee(a, b) {
+		s.Error("Unblocking should show Tweets again")
+	}
+	blocked, err = s.store.GetBlockedUsers(ctx, a)
+	s.expectAccounts("Blocked users after unblocking", blocked, err, c)
+}
+
+func testFollowLists(s *storeTest) {
+	users := s.users(4)
+	user, first, second, viewer := users[0], users[1], users[2], users[3]
+	s.follow(user, first)
+	s.follow(user, second)
+	s.follow(second, viewer)
+	s.follow(viewer, first)
+
+	followers, err := s.store.GetFollowers(ctx, user, viewer, 20, 0)
+	s.expectUsers("Followers", followers, err, second, first)
+	if len(followers) == 2 {
+		if !followers[0].Following || followers[0].FollowsYou {
+			s.Errorf("Viewer follows %+v", followers[0])
+		}
+		if followers[1].Following || !followers[1].FollowsYou {
+			s.Errorf("%+v follows the viewer", followers[1])
+		}
+	}
+	followers, err = s.store.GetFollowers(ctx, user, viewer, 1, 1)
+	s.expectUsers("Second page of followers", followers, err, first)
+	following, err := s.store.GetFollowing(ctx, first, viewer, 20, 0)
+	s.expectUsers("Following", following, err, viewer, user)
+
+	relationship := s.relationship(user, viewer)
+	if relationship.Followers != 2 || relationship.Follows != 0 {
+		s.Errorf("Counts: %+v", relationship)
+	}
+
+	mutuals, count, err := s.store.GetMutualFollowers(ctx, user, viewer, 3)
+	s.check(err)
+	if count != 1 || len(mutuals) != 1 || mutuals[0].Id != second {
+		s.Errorf("Got %d mutual followers: %+v", count, mutuals)
+	}
+
+	s.check(s.store.DeleteFollow(ctx, user, first))
+	followers, err = s.store.GetFollowers(ctx, user, viewer, 20, 0)
+	s.expectUsers("Followers after unfollowing", followers, err, second)
+}
+
+func testMessages(s *storeTest) {
+	users := s.users(3)
+	a, b, outsider := users[0], users[1], users[2]
+
+	conversationId, err := s.store.GetTwoUsersConversation(ctx, a, b)
+	s.check(err)
+	if conversationId != 0 {
+		s.Fatalf("Found conversation %d before creating one", conversationId)
+	}
+	conversationId, err = s.store.CreateTwoUsersConversation(ctx, a, b)
+	s.check(err)
+	for _, pair := range [][2]int64{{a, b}, {b, a}} {
+		found, err := s.store.GetTwoUsersConversation(ctx, pair[0], pair[1])
+		s.check(err)
+		if found != conversationId {
+			s.Errorf("Found conversation %d, want %d", found, conversationId)
+		}
+	}
+
+	_, err = s.store.CreateMessage(ctx, model.MessageRequest{SenderId: a, Text: "hi", ConversationId: conversationId})
+	s.check(err)
+	_, err = s.store.CreateMessage(ctx, model.MessageRequest{SenderId: b, Text: "hey", ConversationId: conversationId})
+	s.check(err)
+	_, err = s.store.CreateMessage(ctx, model.MessageRequest{SenderId: outsider, Text: "psst", ConversationId: conversationId})
+	s.expectErr("Messaging someone else's conversation", err, sql.ErrNoRows)
+
+	messages, err := s.store.GetConversation(ctx, conversationId)
+	s.check(err)
+	if len(messages) != 2 || messages[0].Text != "hi" || messages[0].SenderUsername != s.username(a) ||
+		messages[1].Text != "hey" || messages[1].SenderId != b {
+		s.Errorf("Got messages %+v", messages)
+	}
+
+	conversations, err := s.store.GetConversations(ctx, a)
+	s.check(err)
+	if len(conversations) != 1 || conversations[0].Id != conversationId || conversations[0].Text != "hey" ||
+		conversations[0].OtherUserName != s.username(b) {
+		s.Errorf("Got conversations %+v", conversations)
+	}
+	conversations, err = s.store.GetConversations(ctx, outsider)
+	s.check(err)
+	if len(conversations) != 0 {
+		s.Errorf("Outsider sees conversations %+v", conversations)
+	}
+
+	s.check(s.store.CreateBlock(ctx, b, a))
+	_, err = s.store.CreateMessage(ctx, model.MessageRequest{SenderId: a, Text: "hello?", ConversationId: conversationId})
+	s.expectErr("Messaging someone who blocked you", err, sql.ErrNoRows)
+}
+
+func testNotifications(s *storeTest) {
+	users := s.users(4)
+	author, liker, retweeter, muted := users[0], users[1], users[2], users[3]
+	tweetId := s.tweet(author, "Notice me")
+	s.check(s.store.CreateMute(ctx, author, muted))
+
+	for _, create := range []func() (bool, error){
+		func() (bool, error) { return s.store.CreateLike(ctx, liker, tweetId) },
+		func() (bool, error) { return s.store.CreateRetweet(ctx, retweeter, tweetId) },
+		func() (bool, error) { return s.store.CreateLike(ctx, retweeter, tweetId) },
+		func() (bool, error) { return s.store.CreateLike(ctx, author, tweetId) },
+		func() (bool, error) { return s.store.CreateLike(ctx, muted, tweetId) },
+	} {
+		_, err := create()
+		s.check(err)
+	}
+
+	notifications, err := s.store.GetNotifications(ctx, author)
+	s.check(err)
+	type summary struct {
+		Username string
+		Liked bool
+		Retweeted bool
+	}
+	var got []summary
+	for _, notification := range notifications {
+		if notification.TweetId != tweetId || notification.Text != "Notice me" {
+			s.Errorf("Notification for the wrong Tweet: %+v", notification)
+		}
+		got = append(got, summary{notification.Username, notification.Liked, notification.Retweeted})
+	}
+	want := []summary{
+		{s.username(retweeter), true, false},
+		{s.username(retweeter), false, true},
+		{s.username(liker), true, false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		s.Errorf("Got notifications %+v, want %+v", got, want)
+	}
+}
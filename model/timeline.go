@@ -0,0 +1,375 @@
+package model
+
+import (
+	"context"
+	"time"
+	"database/sql"
+	pq "github.com/lib/pq"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+// TimelineCandidate is a Tweet that may appear on a ranked timeline together
+// with the engagement signals used to score it.
+type TimelineCandidate struct {
+	Tweet Tweet
+	CreatedAt time.Time
+	InNetwork bool
+	Likes int64
+	Retweets int64
+	NetworkEngagements int64
+}
+
+// GetTimelineCandidates gathers recent Tweets for userId's ranked timeline:
+// Tweets by accounts userId follows, plus out-of-network Tweets those
+// accounts liked or retweeted. Blocked, muted and protected authors are
+// filtered the same way as in GetFeed.
+func GetTimelineCandidates(ctx context.Context, userId int64, since time.Duration, limit int) ([]TimelineCandidate, error) {
+	result, err := db.QueryContext(ctx, `WITH network AS (
+			SELECT followed FROM follows WHERE follower = $1
+		), candidates AS (
+			SELECT t.id, true AS in_network
+			FROM tweets t
+			WHERE t.user_id IN (SELECT followed FROM network) AND t.created_at > $2
+			UNION
+			SELECT l.tweet_id, false
+			FROM likes l
+			WHERE l.user_id IN (SELECT followed FROM network) AND l.created_at > $2
+			UNION
+			SELECT r.tweet_id, false
+			FROM retweets r
+			WHERE r.user_id IN (SELECT followed FROM network) AND r.created_at > $2
+		)
+		SELECT t.id, t.text, t.image_url, t.created_at, t.created_at, u.username, u.display_name,
+		bool_or(c.in_network) AS in_network,
+		(SELECT COUNT(*) FROM likes k WHERE k.tweet_id = t.id) AS likes,
+		(SELECT COUNT(*) FROM retweets e WHERE e.tweet_id = t.id) AS retweets,
+		(SELECT COUNT(*) FROM likes k WHERE k.tweet_id = t.id AND k.user_id IN (SELECT followed FROM network)) +
+		(SELECT COUNT(*) FROM retweets e WHERE e.tweet_id = t.id AND e.user_id IN (SELECT followed FROM network)) AS network_engagements,
+		EXISTS (SELECT 1 FROM likes l WHERE l.tweet_id = t.id AND l.user_id = $1) AS liked,
+		EXISTS (SELECT 1 FROM retweets r WHERE r.tweet_id = t.id AND r.user_id = $1) AS retweeted,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $1) AS bookmarked,
+		u.avatar_url
+		FROM candidates c
+		INNER JOIN tweets t
+		ON t.id = c.id
+		INNER JOIN users u
+		ON u.id = t.user_id
+		WHERE t.user_id != $1
+		AND NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter = $1 AND m.muted = t.user_id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $1) OR (b.blocker = $1 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $1
+		))
+		GROUP BY t.id, u.id
+		ORDER BY t.created_at DESC
+		LIMIT $3`, userId, time.Now().Add(-since), limit)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var candidates []TimelineCandidate
+	for result.Next() {
+		var id, likes, retweets, networkEngagements int64
+		var text, date, username string
+		var createdAt time.Time
+		var imageURL, displayName, avatarURL sql.NullString
+		var inNetwork, liked, retweeted, bookmarked bool
+		err := result.Scan(&id, &text, &imageURL, &date, &createdAt, &username, &displayName,
+			&inNetwork, &likes, &retweets, &networkEngagements, &liked, &retweeted, &bookmarked, &avatarURL)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		candidate := TimelineCandidate{
+			Tweet: Tweet{
+				Id: id,
+				Username: username,
+				Text: text,
+				ImageURL: nullStringToString(imageURL),
+				Date: date,
+				Liked: liked,
+				Retweeted: retweeted,
+				Bookmarked: bookmarked,
+				DisplayName: nullStringToString(displayName),
+				AvatarURL: nullStringToString(avatarURL),
+			},
+			CreatedAt: createdAt,
+			InNetwork: inNetwork,
+			Likes: likes,
+			Retweets: retweets,
+			NetworkEngagements: networkEngagements,
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// TimelineEntry places a Tweet on a home timeline. ActorId is the author,
+// AuthorId, or the follower who retweeted it.
+type TimelineEntry struct {
+	TweetId int64
+	AuthorId int64
+	ActorId int64
+	CreatedAt time.Time
+}
+
+// OnPublish, when set, is called with every Tweet and retweet once it is
+// saved, so that whatever writes them, timelines hear about it. The timeline
+// package sets it to fan entries out to followers.
+var OnPublish func(ctx context.Context, entry TimelineEntry)
+
+func publish(ctx context.Context, entry TimelineEntry) {
+	if OnPublish != nil {
+		OnPublish(ctx, entry)
+	}
+}
+
+func scanTimelineEntries(ctx context.Context, result *sql.Rows) []TimelineEntry {
+	var entries []TimelineEntry
+	for result.Next() {
+		var entry TimelineEntry
+		err := result.Scan(&entry.TweetId, &entry.AuthorId, &entry.ActorId, &entry.CreatedAt)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func GetFollowerIds(ctx context.Context, userId int64) ([]int64, error) {
+	return queryIds(ctx, `SELECT follower FROM follows WHERE followed = $1`, userId)
+}
+
+func GetFollowingIds(ctx context.Context, userId int64) ([]int64, error) {
+	return queryIds(ctx, `SELECT followed FROM follows WHERE follower = $1`, userId)
+}
+
+func GetAllUserIds(ctx context.Context) ([]int64, error) {
+	return queryIds(ctx, `SELECT id FROM users ORDER BY id`)
+}
+
+func queryIds(ctx context.Context, query string, args ...interface{}) ([]int64, error) {
+	result, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	var ids []int64
+	for result.Next() {
+		var id int64
+		err := result.Scan(&id)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func GetFollowerCount(ctx context.Context, userId int64) (int64, error) {
+	var count int64
+	err := db.QueryRowContext(ctx, `SELECT follower_count FROM users WHERE id = $1`, userId).Scan(&count)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetHeavyFollowedIds returns the accounts userId follows that have more
+// than threshold followers.
+func GetHeavyFollowedIds(ctx context.Context, userId int64, threshold int64) ([]int64, error) {
+	return queryIds(ctx, `SELECT f.followed
+		FROM follows f
+		INNER JOIN users u
+		ON u.id = f.followed
+		WHERE f.follower = $1
+		AND u.follower_count > $2`, userId, threshold)
+}
+
+// GetActivity returns the most recent Tweets and retweets by actorIds.
+func GetActivity(ctx context.Context, actorIds []int64, limit int) ([]TimelineEntry, error) {
+	result, err := db.QueryContext(ctx, `SELECT id, user_id AS author_id, user_id AS actor_id, created_at
+		FROM tweets WHERE user_id = ANY($1)
+		UNION ALL
+		SELECT r.tweet_id, t.user_id, r.user_id, r.created_at
+		FROM retweets r
+		INNER JOIN tweets t
+		ON t.id = r.tweet_id
+		WHERE r.user_id = ANY($1)
+		ORDER BY created_at DESC
+		LIMIT $2`, pq.Array(actorIds), limit)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanTimelineEntries(ctx, result), nil
+}
+
+// PushTimelineEntry adds entry to the home timeline of every user in userIds.
+func PushTimelineEntry(ctx context.Context, userIds []int64, entry TimelineEntry) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO home_timelines (user_id, tweet_id, actor_id, created_at)
+		SELECT u, $2, $3, $4 FROM unnest($1::integer[]) AS u
+		ON CONFLICT DO NOTHING`, pq.Array(userIds), entry.TweetId, entry.ActorId, entry.CreatedAt)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+func GetTimelineEntries(ctx context.Context, userId int64, limit int) ([]TimelineEntry, error) {
+	result, err := db.QueryContext(ctx, `SELECT h.tweet_id, t.user_id, h.actor_id, h.created_at
+		FROM home_timelines h
+		INNER JOIN tweets t
+		ON t.id = h.tweet_id
+		WHERE h.user_id = $1
+		ORDER BY h.created_at DESC
+		LIMIT $2`, userId, limit)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+	return scanTimelineEntries(ctx, result), nil
+}
+
+func DeleteTimelineEntriesFromActor(ctx context.Context, userId, actorId int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM home_timelines WHERE user_id = $1 AND actor_id = $2`, userId, actorId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteTimelineEntriesByAuthor removes authorId's Tweets from userId's
+// timeline, including those retweeted by someone else.
+func DeleteTimelineEntriesByAuthor(ctx context.Context, userId, authorId int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM home_timelines h
+		USING tweets t
+		WHERE t.id = h.tweet_id AND h.user_id = $1 AND t.user_id = $2`, userId, authorId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// ReplaceTimelineEntries swaps userId's materialized timeline for entries.
+func ReplaceTimelineEntries(ctx context.Context, userId int64, entries []TimelineEntry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM home_timelines WHERE user_id = $1`, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	for _, entry := range entries {
+		_, err = tx.ExecContext(ctx, `INSERT INTO home_timelines (user_id, tweet_id, actor_id, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING`, userId, entry.TweetId, entry.ActorId, entry.CreatedAt)
+		if err != nil {
+			logging.Error(ctx, "Query failed.", "err", err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// TrimTimelines drops everything but the newest keep entries per user.
+func TrimTimelines(ctx context.Context, keep int) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM home_timelines h
+		USING (
+			SELECT user_id, tweet_id,
+			row_number() OVER (PARTITION BY user_id ORDER BY created_at DESC) AS position
+			FROM home_timelines
+		) ranked
+		WHERE h.user_id = ranked.user_id AND h.tweet_id = ranked.tweet_id AND ranked.position > $1`, keep)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetTweetsByIds loads the given Tweets for userId's home timeline, applying
+// the same mute, block and protected filters as GetFeed. Tweets that are
+// filtered out or deleted are simply missing from the result.
+func GetTweetsByIds(ctx context.Context, tweetIds []int64, userId int64) (map[int64]Tweet, error) {
+	result, err := db.QueryContext(ctx, `SELECT t.id, t.text, t.image_url, t.created_at, u.username, u.display_name,
+		EXISTS (SELECT 1 FROM likes l WHERE l.tweet_id = t.id AND l.user_id = $2) AS liked,
+		EXISTS (SELECT 1 FROM retweets r WHERE r.tweet_id = t.id AND r.user_id = $2) AS retweeted,
+		EXISTS (SELECT 1 FROM bookmarks bm WHERE bm.tweet_id = t.id AND bm.user_id = $2) AS bookmarked,
+		u.avatar_url
+		FROM tweets t
+		INNER JOIN users u
+		ON u.id = t.user_id
+		WHERE t.id = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muter = $2 AND m.muted = t.user_id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.blocker = t.user_id AND b.blocked = $2) OR (b.blocker = $2 AND b.blocked = t.user_id)
+		)
+		AND u.deactivated_at IS NULL
+		AND (u.suspended_until IS NULL OR u.suspended_until <= now())
+		AND (NOT u.protected OR u.id = $2 OR EXISTS (
+			SELECT 1 FROM follows pf WHERE pf.followed = u.id AND pf.follower = $2
+		))`, pq.Array(tweetIds), userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return nil, err
+	}
+	defer result.Close()
+
+	tweets := make(map[int64]Tweet)
+	for result.Next() {
+		var id int64
+		var text, date, username string
+		var imageURL, displayName, avatarURL sql.NullString
+		var liked, retweeted, bookmarked bool
+		err := result.Scan(&id, &text, &imageURL, &date, &username, &displayName, &liked, &retweeted, &bookmarked, &avatarURL)
+		if err != nil {
+			logging.Error(ctx, "Could not scan row.", "err", err)
+			break
+		}
+		tweets[id] = Tweet{
+			Id: id,
+			Username: username,
+			Text: text,
+			ImageURL: nullStringToString(imageURL),
+			Date: date,
+			Liked: liked,
+			Retweeted: retweeted,
+			Bookmarked: bookmarked,
+			DisplayName: nullStringToString(displayName),
+			AvatarURL: nullStringToString(avatarURL),
+		}
+	}
+	return tweets, nil
+}
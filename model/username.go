@@ -0,0 +1,85 @@
+package model
+
+import (
+	"context"
+	"time"
+	"errors"
+	"database/sql"
+	pq "github.com/lib/pq"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+var ErrUsernameTaken = errors.New("that username is already in use")
+var ErrUsernameCooldown = errors.New("username was changed too recently")
+
+// ChangeUsername renames userId, at most once per cooldown. The old username
+// is kept in username_history so that links to it keep working until someone
+// else claims it; claiming a retired username removes it from the history.
+func ChangeUsername(ctx context.Context, userId int64, username string, cooldown time.Duration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldUsername string
+	var changedAt pq.NullTime
+	err = tx.QueryRowContext(ctx, `SELECT username, username_changed_at FROM users WHERE id = $1 FOR UPDATE`,
+		userId).Scan(&oldUsername, &changedAt)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	if username == oldUsername {
+		return nil
+	}
+	if changedAt.Valid && time.Since(changedAt.Time) < cooldown {
+		return ErrUsernameCooldown
+	}
+
+	// The profile URL a verified website links to has changed with it.
+	_, err = tx.ExecContext(ctx, `UPDATE users SET username = $2, username_changed_at = now(), website_verified = false
+		WHERE id = $1`, userId, username)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrUsernameTaken
+	}
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM username_history WHERE username = $1`, username)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO username_history (username, user_id) VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET user_id = $2, changed_at = now()`, oldUsername, userId)
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logging.Error(ctx, "Query failed.", "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetRenamedUsername returns the current username of whoever last gave up
+// username, or sql.ErrNoRows if it was never changed away from.
+func GetRenamedUsername(ctx context.Context, username string) (string, error) {
+	var current string
+	err := db.QueryRowContext(ctx, `SELECT u.username FROM username_history h
+		INNER JOIN users u
+		ON u.id = h.user_id
+		WHERE h.username = $1`, username).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Error(ctx, "Query failed.", "err", err)
+	}
+	return current, err
+}
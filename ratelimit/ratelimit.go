@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"math"
+	"time"
+	"context"
+	"strings"
+	"strconv"
+	"net/http"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+	session "github.com/dustinnewman98/twitter_clone/session"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst.
+// A zero Burst disables the limit.
+type Limit struct {
+	Rate float64
+	Burst int
+}
+
+// Policy limits a route separately by client IP and by signed-in user.
+type Policy struct {
+	Name string
+	IP Limit
+	User Limit
+}
+
+const (
+	LOGIN_COOKIE_NAME = "login"
+)
+
+func perMinute(n int) float64 {
+	return float64(n) / 60
+}
+
+var (
+	LoginPolicy = Policy{Name: "login", IP: Limit{Rate: perMinute(10), Burst: 10}}
+	TweetPolicy = Policy{Name: "tweet", IP: Limit{Rate: perMinute(30), Burst: 30}, User: Limit{Rate: perMinute(6), Burst: 10}}
+	LikePolicy = Policy{Name: "like", IP: Limit{Rate: perMinute(120), Burst: 60}, User: Limit{Rate: perMinute(30), Burst: 30}}
+	RetweetPolicy = Policy{Name: "retweet", IP: Limit{Rate: perMinute(60), Burst: 30}, User: Limit{Rate: perMinute(15), Burst: 15}}
+	FollowPolicy = Policy{Name: "follow", IP: Limit{Rate: perMinute(60), Burst: 30}, User: Limit{Rate: perMinute(10), Burst: 20}}
+	MessagePolicy = Policy{Name: "message", IP: Limit{Rate: perMinute(60), Burst: 30}, User: Limit{Rate: perMinute(20), Burst: 20}}
+	AccountPolicy = Policy{Name: "account", IP: Limit{Rate: perMinute(5), Burst: 5}}
+	ReportPolicy = Policy{Name: "report", IP: Limit{Rate: perMinute(20), Burst: 20}, User: Limit{Rate: perMinute(5), Burst: 10}}
+
+	// LoginLockout allows five failed passwords per username, then one more
+	// attempt every fifteen minutes until a successful login resets it.
+	LoginLockout = Limit{Rate: 1.0 / (15 * 60), Burst: 5}
+)
+
+var store Store = NewMemoryStore()
+
+// Init selects the backing store. RATE_LIMIT_STORE=postgres shares limits
+// across servers through the database, otherwise they are kept in memory.
+func Init() {
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		store = &PostgresStore{}
+		return
+	}
+	store = NewMemoryStore()
+}
+
+// ClientIP returns the address the request came from. X-Forwarded-For is only
+// honoured when TRUST_PROXY is set, since clients can forge it otherwise.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") != "" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func retryAfter(tokens float64, limit Limit) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / limit.Rate)) * time.Second
+}
+
+func take(ctx context.Context, key string, limit Limit) time.Duration {
+	if limit.Burst == 0 {
+		return 0
+	}
+	tokens, taken, err := store.Take(ctx, key, limit, true)
+	if err != nil {
+		// Fail open rather than lock everyone out when the store is down.
+		logging.Error(ctx, "Could not check rate limit.", "err", err)
+		return 0
+	}
+	if taken {
+		return 0
+	}
+	return retryAfter(tokens, limit)
+}
+
+func WriteTooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	http.Error(w, "Too many requests. Try again later.", http.StatusTooManyRequests)
+}
+
+// Handler applies policy to next, answering 429 with Retry-After once either
+// the client's IP or the signed-in user has run out of tokens.
+func Handler(policy Policy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			wait := take(r.Context(), fmt.Sprintf("%s:ip:%s", policy.Name, ClientIP(r)), policy.IP)
+			if wait == 0 {
+				session, _ := session.Store.Get(r, LOGIN_COOKIE_NAME)
+				if uid, ok := session.Values["uid"].(int64); ok {
+					wait = take(r.Context(), fmt.Sprintf("%s:user:%d", policy.Name, uid), policy.User)
+				}
+			}
+			if wait > 0 {
+				WriteTooManyRequests(w, wait)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func lockoutKey(username string) string {
+	return "login_failures:" + strings.ToLower(username)
+}
+
+// LoginLockedFor reports how long username must wait before another
+// password attempt, or zero when it is not locked out.
+func LoginLockedFor(ctx context.Context, username string) time.Duration {
+	tokens, _, err := store.Take(ctx, lockoutKey(username), LoginLockout, false)
+	if err != nil {
+		logging.Error(ctx, "Could not check login lockout.", "err", err)
+		return 0
+	}
+	if tokens >= 1 {
+		return 0
+	}
+	return retryAfter(tokens, LoginLockout)
+}
+
+func LoginFailed(ctx context.Context, username string) {
+	_, _, err := store.Take(ctx, lockoutKey(username), LoginLockout, true)
+	if err != nil {
+		logging.Error(ctx, "Could not record failed login.", "err", err)
+	}
+}
+
+func LoginSucceeded(ctx context.Context, username string) {
+	err := store.Reset(ctx, lockoutKey(username))
+	if err != nil {
+		logging.Error(ctx, "Could not reset login lockout.", "err", err)
+	}
+}
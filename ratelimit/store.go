@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+	"math"
+	"context"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+// Store keeps token buckets. Take refills the bucket for key and, when take
+// is set, removes a token if one is available. It returns the tokens left
+// and whether a token was taken.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, take bool) (float64, bool, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps buckets in process. Limits are not shared between
+// server instances.
+type MemoryStore struct {
+	mu sync.Mutex
+	buckets map[string]*bucket
+	lastSweep time.Time
+}
+
+// PostgresStore keeps buckets in the rate_limits table so that every server
+// behind a load balancer enforces the same limits.
+type PostgresStore struct {
+	mu sync.Mutex
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	updatedAt time.Time
+}
+
+const SWEEP_INTERVAL = time.Hour
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, take bool) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > SWEEP_INTERVAL {
+		for k, b := range s.buckets {
+			if now.Sub(b.updatedAt) > SWEEP_INTERVAL {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens + now.Sub(b.updatedAt).Seconds() * limit.Rate)
+	b.updatedAt = now
+	taken := false
+	if take && b.tokens >= 1 {
+		b.tokens--
+		taken = true
+	}
+	return b.tokens, taken, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets, key)
+	return nil
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit, take bool) (float64, bool, error) {
+	s.mu.Lock()
+	if time.Since(s.lastPrune) > SWEEP_INTERVAL {
+		s.lastPrune = time.Now()
+		go model.PruneRateLimits(context.Background())
+	}
+	s.mu.Unlock()
+
+	return model.TakeRateLimitToken(ctx, key, limit.Rate, limit.Burst, take)
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return model.DeleteRateLimit(ctx, key)
+}
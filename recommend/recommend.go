@@ -0,0 +1,130 @@
+package recommend
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"context"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+const (
+	// CANDIDATE_POOL is how many candidates are scored per user; only the
+	// best are shown but the rest let the cache serve several page sizes.
+	CANDIDATE_POOL = 100
+	CACHE_TTL = 10 * time.Minute
+	SIDEBAR_SIZE = 3
+	ONBOARDING_SIZE = 20
+
+	MUTUAL_FOLLOW_WEIGHT = 3.0
+	SHARED_LIKE_WEIGHT = 1.0
+	POPULARITY_WEIGHT = 0.5
+)
+
+type entry struct {
+	candidates []model.FollowCandidate
+	expires time.Time
+}
+
+var mu sync.Mutex
+var cache = make(map[int64]entry)
+
+// Score favours accounts followed by many of the people userId follows, then
+// shared likes, with a small, log-damped boost for overall popularity so a
+// handful of celebrities do not crowd out closer connections.
+func Score(candidate model.FollowCandidate) float64 {
+	return MUTUAL_FOLLOW_WEIGHT * float64(candidate.MutualFollows) +
+		SHARED_LIKE_WEIGHT * float64(candidate.SharedLikes) +
+		POPULARITY_WEIGHT * math.Log1p(float64(candidate.Followers))
+}
+
+func rank(candidates []model.FollowCandidate) {
+	for i := range candidates {
+		candidates[i].Score = Score(candidates[i])
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+}
+
+func compute(ctx context.Context, userId int64) ([]model.FollowCandidate, error) {
+	candidates, err := model.GetFollowCandidates(ctx, userId, CANDIDATE_POOL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Top up with popular accounts so new users still get suggestions.
+	if len(candidates) < CANDIDATE_POOL {
+		popular, err := model.GetPopularUsers(ctx, userId, CANDIDATE_POOL)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[int64]bool)
+		for _, candidate := range candidates {
+			seen[candidate.Id] = true
+		}
+		for _, candidate := range popular {
+			if len(candidates) >= CANDIDATE_POOL {
+				break
+			}
+			if !seen[candidate.Id] {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	rank(candidates)
+	return candidates, nil
+}
+
+// WhoToFollow returns up to limit suggested accounts for userId, served from
+// a per-user cache that is refreshed every CACHE_TTL.
+func WhoToFollow(ctx context.Context, userId int64, limit int) ([]model.FollowCandidate, error) {
+	mu.Lock()
+	cached, ok := cache[userId]
+	mu.Unlock()
+
+	candidates := cached.candidates
+	if !ok || time.Now().After(cached.expires) {
+		var err error
+		candidates, err = compute(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		now := time.Now()
+		for id, e := range cache {
+			if now.After(e.expires) {
+				delete(cache, id)
+			}
+		}
+		cache[userId] = entry{candidates: candidates, expires: now.Add(CACHE_TTL)}
+		mu.Unlock()
+	}
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
+
+// Invalidate drops userId's cached suggestions, for example after they
+// follow, block or mute someone.
+func Invalidate(userId int64) {
+	mu.Lock()
+	delete(cache, userId)
+	mu.Unlock()
+}
+
+// Sidebar is WhoToFollow for templates: errors are logged and hidden so a
+// failed recommendation query never breaks the page around it.
+func Sidebar(ctx context.Context, userId int64) []model.FollowCandidate {
+	candidates, err := WhoToFollow(ctx, userId, SIDEBAR_SIZE)
+	if err != nil {
+		logging.Error(ctx, "Could not get suggestions.", "err", err)
+		return nil
+	}
+	return candidates
+}
@@ -0,0 +1,168 @@
+// Package relme verifies profile websites: a site is verified when it links
+// back to the profile with rel="me".
+package relme
+
+import (
+	"io"
+	"net"
+	"time"
+	"errors"
+	"regexp"
+	"strings"
+	"syscall"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	htmlpkg "html"
+)
+
+const (
+	MAX_BODY_BYTES = 1 << 20
+	MAX_REDIRECTS = 5
+	TIMEOUT = 10 * time.Second
+)
+
+// Doer fetches pages. *http.Client satisfies it; tests and proxies can swap
+// in their own.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client is used for every fetch. The default refuses to connect to
+// loopback, private and link-local addresses so that a profile website
+// cannot be used to probe the internal network.
+var Client Doer = &http.Client{
+	Timeout: TIMEOUT,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: TIMEOUT,
+			Control: publicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: TIMEOUT,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= MAX_REDIRECTS {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+var ErrForbiddenAddress = errors.New("website resolves to a non-public address")
+
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		privateNets = append(privateNets, n)
+	}
+}
+
+// publicOnly is a net.Dialer Control function that rejects connections to
+// addresses in privateNets. It runs after DNS resolution, so it also catches
+// public names that point at internal addresses.
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrForbiddenAddress
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+var tagPattern = regexp.MustCompile(`(?is)<(a|link)\s[^>]*>`)
+var attrPattern = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// meLinks returns the href of every <a> and <link> in page whose rel
+// includes "me", resolved against base.
+func meLinks(page string, base *url.URL) []string {
+	var links []string
+	for _, tag := range tagPattern.FindAllString(page, -1) {
+		var rel, href string
+		for _, attr := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			value := htmlpkg.UnescapeString(attr[2] + attr[3] + attr[4])
+			switch strings.ToLower(attr[1]) {
+			case "rel":
+				rel = value
+			case "href":
+				href = value
+			}
+		}
+		isMe := false
+		for _, r := range strings.Fields(strings.ToLower(rel)) {
+			if r == "me" {
+				isMe = true
+			}
+		}
+		if !isMe || href == "" {
+			continue
+		}
+		u, err := base.Parse(href)
+		if err != nil {
+			continue
+		}
+		links = append(links, u.String())
+	}
+	return links
+}
+
+// sameProfile compares URLs ignoring scheme, host case and a trailing slash.
+func sameProfile(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Host, ub.Host) &&
+		strings.TrimSuffix(ua.Path, "/") == strings.TrimSuffix(ub.Path, "/")
+}
+
+// Verify fetches website and reports whether it links to profileURL with
+// rel="me".
+func Verify(website, profileURL string) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, website, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_BODY_BYTES))
+	if err != nil {
+		return false, err
+	}
+
+	// Links are resolved against the final URL after any redirects.
+	base := req.URL
+	if resp.Request != nil {
+		base = resp.Request.URL
+	}
+	for _, link := range meLinks(string(body), base) {
+		if sameProfile(link, profileURL) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,385 @@
+// Package seed fills a development database with a synthetic but realistic
+// social network: a power-law follow graph, Tweets with replies, hashtags
+// and mentions, likes, retweets and direct message conversations. Everything
+// is written through the model package and is fully determined by the seed,
+// so two runs against empty databases produce the same data.
+package seed
+
+import (
+	"context"
+	"log"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"math/rand"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+// Config controls the size and shape of the generated network.
+type Config struct {
+	Seed int64
+	// Usernames are Prefix followed by a word and a number, so that
+	// generated accounts are easy to spot and don't clash with real ones.
+	// See Username.
+	Prefix string
+	Password string
+	Users int
+	// Popularity is the exponent of the power law that decides who gets
+	// followed; higher values concentrate followers on fewer accounts.
+	Popularity float64
+	MeanFollows int
+	TweetsPerUser int
+	// ReplyRate is the fraction of Tweets that reply to an earlier Tweet.
+	ReplyRate float64
+	MeanLikes int
+	MeanRetweets int
+	Conversations int
+	MessagesPerConversation int
+	// ProtectedRate is the fraction of accounts whose Tweets are protected.
+	ProtectedRate float64
+}
+
+// DefaultConfig is sized to make slow queries noticeable on a laptop
+// without taking more than a few minutes to insert.
+var DefaultConfig = Config{
+	Seed: 1,
+	Prefix: "seed_",
+	Password: "password",
+	Users: 1000,
+	Popularity: 1.1,
+	MeanFollows: 40,
+	TweetsPerUser: 20,
+	ReplyRate: 0.25,
+	MeanLikes: 6,
+	MeanRetweets: 1,
+	Conversations: 300,
+	MessagesPerConversation: 12,
+	ProtectedRate: 0.05,
+}
+
+// Summary counts what Generate created.
+type Summary struct {
+	Users int
+	Follows int
+	Tweets int
+	Replies int
+	Likes int
+	Retweets int
+	Conversations int
+	Messages int
+}
+
+// Username returns the name of the i-th generated account. It doesn't
+// depend on the seed, so tools can sign in as generated users without
+// access to the database.
+func Username(prefix string, i int) string {
+	return fmt.Sprintf("%s%s%d", prefix, handleWords[i % len(handleWords)], i)
+}
+
+type generator struct {
+	config Config
+	rand *rand.Rand
+	userIds []int64
+	usernames []string
+	// weights holds each user's cumulative popularity, for sampling users
+	// in proportion to how followable they are.
+	weights []float64
+	followers [][]int
+	following [][]int
+	tweets []tweet
+	summary Summary
+}
+
+type tweet struct {
+	id int64
+	author int
+}
+
+// Generate creates the network described by config.
+func Generate(ctx context.Context, config Config) (Summary, error) {
+	if config.Users < 1 {
+		return Summary{}, fmt.Errorf("seeding needs at least one user")
+	}
+	g := &generator{
+		config: config,
+		rand: rand.New(rand.NewSource(config.Seed)),
+	}
+	steps := []struct {
+		name string
+		run func(context.Context) error
+	}{
+		{"users", g.createUsers},
+		{"follows", g.createFollows},
+		{"tweets", g.createTweets},
+		{"likes and retweets", g.createEngagement},
+		{"conversations", g.createConversations},
+	}
+	for _, step := range steps {
+		log.Printf("Seeding %s...", step.name)
+		err := step.run(ctx)
+		if err != nil {
+			return g.summary, fmt.Errorf("seeding %s: %v", step.name, err)
+		}
+	}
+	return g.summary, nil
+}
+
+func (g *generator) pick(words []string) string {
+	return words[g.rand.Intn(len(words))]
+}
+
+// poisson draws a count with the given mean, using Knuth's method for small
+// means and a normal approximation for large ones.
+func (g *generator) poisson(mean float64) int {
+	if mean <= 0 {
+		return 0
+	}
+	if mean > 30 {
+		n := int(g.rand.NormFloat64() * math.Sqrt(mean) + mean + 0.5)
+		if n < 0 {
+			return 0
+		}
+		return n
+	}
+	limit := math.Exp(-mean)
+	n := 0
+	for p := g.rand.Float64(); p > limit; p *= g.rand.Float64() {
+		n++
+	}
+	return n
+}
+
+// popularUser returns a user index drawn from the power law.
+func (g *generator) popularUser() int {
+	target := g.rand.Float64() * g.weights[len(g.weights) - 1]
+	return sort.SearchFloat64s(g.weights, target)
+}
+
+func (g *generator) createUsers(ctx context.Context) error {
+	// Ranks are shuffled so popularity doesn't follow signup order.
+	ranks := g.rand.Perm(g.config.Users)
+	total := 0.0
+	for i := 0; i < g.config.Users; i++ {
+		username := Username(g.config.Prefix, i)
+		userId, err := model.CreateUser(ctx, username, g.config.Password)
+		if err != nil {
+			return err
+		}
+
+		err = model.EditUser(ctx, model.User{
+			Id: userId,
+			DisplayName: fmt.Sprintf("%s %s", g.pick(firstNames), g.pick(lastNames)),
+			Bio: g.bio(),
+			Location: g.pick(locations),
+			Protected: g.rand.Float64() < g.config.ProtectedRate,
+		})
+		if err != nil {
+			return err
+		}
+
+		total += 1 / math.Pow(float64(ranks[i] + 1), g.config.Popularity)
+		g.userIds = append(g.userIds, userId)
+		g.usernames = append(g.usernames, username)
+		g.weights = append(g.weights, total)
+		g.summary.Users++
+	}
+	g.followers = make([][]int, g.config.Users)
+	g.following = make([][]int, g.config.Users)
+	return nil
+}
+
+func (g *generator) bio() string {
+	parts := []string{g.pick(bioRoles)}
+	for _, topic := range g.rand.Perm(len(topics))[:1 + g.rand.Intn(2)] {
+		parts = append(parts, "#" + topics[topic])
+	}
+	if g.rand.Intn(3) == 0 {
+		parts = append(parts, g.pick(bioTails))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (g *generator) createFollows(ctx context.Context) error {
+	for follower := range g.userIds {
+		// Out-degrees vary widely too, but far less than in-degrees.
+		want := g.poisson(float64(g.config.MeanFollows) * (0.25 + 1.5 * g.rand.Float64()))
+		if want > len(g.userIds) - 1 {
+			want = len(g.userIds) - 1
+		}
+		seen := map[int]bool{follower: true}
+		for attempts := 0; len(seen) <= want && attempts < want * 10; attempts++ {
+			followed := g.popularUser()
+			if seen[followed] {
+				continue
+			}
+			seen[followed] = true
+
+			following, err := model.CreateFollow(ctx, g.userIds[followed], g.userIds[follower])
+			if err != nil {
+				return err
+			}
+			// Requests to protected accounts are left pending.
+			if following {
+				g.followers[followed] = append(g.followers[followed], follower)
+				g.following[follower] = append(g.following[follower], followed)
+				g.summary.Follows++
+			}
+		}
+	}
+	return nil
+}
+
+// text composes a Tweet of at most 140 characters, sometimes mentioning an
+// account author follows and tagging a topic.
+func (g *generator) text(author int, prefix string) string {
+	text := prefix + fmt.Sprintf(g.pick(sentences), g.pick(topics))
+	if following := g.following[author]; len(following) > 0 && g.rand.Intn(4) == 0 {
+		text += " cc @" + g.usernames[following[g.rand.Intn(len(following))]]
+	}
+	if g.rand.Intn(2) == 0 {
+		text += " #" + g.pick(topics)
+	}
+	if len(text) > 140 {
+		text = strings.TrimSpace(text[:140])
+	}
+	return text
+}
+
+func (g *generator) createTweets(ctx context.Context) error {
+	total := len(g.userIds) * g.config.TweetsPerUser
+	for i := 0; i < total; i++ {
+		// Popular accounts also tweet more.
+		author := g.popularUser()
+		if g.rand.Intn(2) == 0 {
+			author = g.rand.Intn(len(g.userIds))
+		}
+
+		request := model.TweetRequest{UserId: g.userIds[author]}
+		if len(g.tweets) > 0 && g.rand.Float64() < g.config.ReplyRate {
+			// Replies favour recent Tweets, as conversations do.
+			recent := len(g.tweets)
+			if recent > 200 {
+				recent = 200
+			}
+			parent := g.tweets[len(g.tweets) - 1 - g.rand.Intn(recent)]
+			request.ParentId = parent.id
+			request.Text = g.text(author, "@" + g.usernames[parent.author] + " ")
+		} else {
+			request.Text = g.text(author, "")
+		}
+
+		tweetId, err := model.CreateTweet(ctx, request)
+		if err == model.ErrBlocked {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		g.tweets = append(g.tweets, tweet{id: tweetId, author: author})
+		g.summary.Tweets++
+		if request.ParentId != 0 {
+			g.summary.Replies++
+		}
+	}
+	return nil
+}
+
+// engager picks who likes or retweets author's Tweet: usually a follower,
+// otherwise anyone.
+func (g *generator) engager(author int) int {
+	if followers := g.followers[author]; len(followers) > 0 && g.rand.Intn(5) != 0 {
+		return followers[g.rand.Intn(len(followers))]
+	}
+	return g.rand.Intn(len(g.userIds))
+}
+
+func (g *generator) createEngagement(ctx context.Context) error {
+	for _, t := range g.tweets {
+		// Engagement scales with the author's audience.
+		audience := 1 + float64(len(g.followers[t.author])) / float64(g.config.MeanFollows + 1)
+		actions := []struct {
+			mean int
+			create func(ctx context.Context, userId, tweetId int64) (bool, error)
+			count *int
+		}{
+			{g.config.MeanLikes, model.CreateLike, &g.summary.Likes},
+			{g.config.MeanRetweets, model.CreateRetweet, &g.summary.Retweets},
+		}
+		for _, action := range actions {
+			seen := map[int]bool{t.author: true}
+			for n := g.poisson(float64(action.mean) * audience); n > 0; n-- {
+				user := g.engager(t.author)
+				if seen[user] {
+					continue
+				}
+				seen[user] = true
+				created, err := action.create(ctx, g.userIds[user], t.id)
+				if err == model.ErrBlocked {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if created {
+					*action.count++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (g *generator) createConversations(ctx context.Context) error {
+	if len(g.userIds) < 2 {
+		return nil
+	}
+	for i := 0; i < g.config.Conversations; i++ {
+		a := g.rand.Intn(len(g.userIds))
+		b := g.rand.Intn(len(g.userIds) - 1)
+		if b >= a {
+			b++
+		}
+		// Most conversations are with someone you follow.
+		if following := g.following[a]; len(following) > 0 && g.rand.Intn(4) != 0 {
+			b = following[g.rand.Intn(len(following))]
+		}
+
+		conversationId, err := model.GetTwoUsersConversation(ctx, g.userIds[a], g.userIds[b])
+		if err != nil {
+			return err
+		}
+		if conversationId == 0 {
+			conversationId, err = model.CreateTwoUsersConversation(ctx, g.userIds[a], g.userIds[b])
+			if err == model.ErrBlocked {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			g.summary.Conversations++
+		}
+
+		sender := a
+		for n := 1 + g.poisson(float64(g.config.MessagesPerConversation - 1)); n > 0; n-- {
+			_, err := model.SmartCreateUser(ctx, model.MessageRequest{
+				SenderId: g.userIds[sender],
+				Text: g.pick(messages),
+				ConversationId: conversationId,
+			})
+			if err != nil {
+				return err
+			}
+			g.summary.Messages++
+			// Replies usually alternate, with the odd double text.
+			if g.rand.Intn(4) != 0 {
+				if sender == a {
+					sender = b
+				} else {
+					sender = a
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package seed
+
+var handleWords = []string{
+	"otter", "falcon", "maple", "harbor", "comet", "cedar", "pixel", "ember", "willow", "quartz",
+	"badger", "lantern", "meadow", "raven", "summit", "tidal", "copper", "fable", "gecko", "nimbus",
+}
+
+var firstNames = []string{
+	"Ada", "Ben", "Chloe", "Dev", "Elena", "Farah", "Gus", "Hana", "Ivan", "Jules",
+	"Kofi", "Lena", "Mateo", "Noor", "Oscar", "Priya", "Quinn", "Rosa", "Sami", "Tomas",
+	"Uma", "Vic", "Wen", "Yara", "Zeke",
+}
+
+var lastNames = []string{
+	"Abara", "Berg", "Castillo", "Dube", "Eriksen", "Fujita", "Garcia", "Haddad", "Ito", "Jensen",
+	"Kowalski", "Lindqvist", "Mensah", "Nakamura", "Okafor", "Patel", "Quintero", "Rossi", "Silva", "Tran",
+}
+
+var locations = []string{
+	"Berlin", "Lagos", "Lisbon", "Melbourne", "Mexico City", "Montreal", "Mumbai", "Nairobi", "Osaka", "Oslo",
+	"Portland", "Reykjavik", "San Francisco", "Seoul", "Toronto", "the internet", "",
+}
+
+var bioRoles = []string{
+	"Software engineer.", "Designer and occasional writer.", "Grad student.", "Coffee first.",
+	"Amateur photographer.", "Teacher by day.", "Building things on the web.", "Professional napper.",
+	"Data nerd.", "Trying my best.",
+}
+
+var bioTails = []string{
+	"Views my own.", "He/him.", "She/her.", "They/them.", "Opinions subject to change.", "DMs open.",
+}
+
+var topics = []string{
+	"golang", "postgres", "design", "coffee", "running", "books", "music", "cooking", "startups", "photography",
+	"climbing", "gaming", "opensource", "javascript", "travel", "space", "football", "gardening", "jazz", "film",
+}
+
+// sentences are Tweet templates; %s is replaced with a topic.
+var sentences = []string{
+	"Spent the whole afternoon on %s and regret nothing",
+	"Hot take: %s is underrated",
+	"Anyone have good recommendations for getting into %s?",
+	"Finally wrote up my notes on %s, link soon",
+	"Can't stop thinking about %s today",
+	"Today I learned something surprising about %s",
+	"Unpopular opinion: %s was better ten years ago",
+	"Who else is going to the %s meetup this week?",
+	"Three years into %s and still a beginner",
+	"Just shipped a small %s side project",
+	"Is it just me or is everyone talking about %s",
+	"Taking a break from %s this weekend",
+}
+
+var messages = []string{
+	"hey!", "did you see that thread?", "haha yes", "ok sounds good", "are you around later?",
+	"just sent it over", "thanks so much", "no worries", "let me check and get back to you",
+	"that's wild", "see you then", "lol", "good point", "can't make it tonight, sorry",
+	"what did you think of it?",
+}
@@ -0,0 +1,390 @@
+package timeline
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"context"
+	"strconv"
+	model "github.com/dustinnewman98/twitter_clone/model"
+	logging "github.com/dustinnewman98/twitter_clone/logging"
+)
+
+const (
+	FEED_SIZE = 200
+	// MAX_ENTRIES bounds each materialized timeline.
+	MAX_ENTRIES = 800
+	// DEFAULT_FANOUT_LIMIT is the follower count above which an account's
+	// Tweets are no longer pushed to followers but merged in at read time.
+	DEFAULT_FANOUT_LIMIT = 10000
+)
+
+// Store holds materialized home timelines. Entries are returned newest
+// first. A Redis-backed store only needs to implement these five methods.
+type Store interface {
+	Push(ctx context.Context, userIds []int64, entry model.TimelineEntry) error
+	Read(ctx context.Context, userId int64, limit int) ([]model.TimelineEntry, error)
+	RemoveActor(ctx context.Context, userId, actorId int64) error
+	RemoveAuthor(ctx context.Context, userId, authorId int64) error
+	Replace(ctx context.Context, userId int64, entries []model.TimelineEntry) error
+}
+
+// PostgresStore keeps timelines in the home_timelines table.
+type PostgresStore struct{}
+
+// MemoryStore keeps timelines in process. It is lost on restart, so Init
+// backfills it in the background.
+type MemoryStore struct {
+	mu sync.RWMutex
+	timelines map[int64][]model.TimelineEntry
+}
+
+// store is nil when the cache is disabled and the home page falls back to
+// model.GetFeed.
+var store Store
+var fanoutLimit int64 = DEFAULT_FANOUT_LIMIT
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{timelines: make(map[int64][]model.TimelineEntry)}
+}
+
+// Init selects the timeline store from TIMELINE_STORE ("postgres" or
+// "memory"); anything else leaves the cache disabled. TIMELINE_FANOUT_LIMIT
+// overrides DEFAULT_FANOUT_LIMIT.
+func Init() {
+	if limit, err := strconv.ParseInt(os.Getenv("TIMELINE_FANOUT_LIMIT"), 10, 64); err == nil {
+		fanoutLimit = limit
+	}
+
+	switch os.Getenv("TIMELINE_STORE") {
+	case "postgres":
+		SetStore(PostgresStore{})
+		go func() {
+			ctx := context.Background()
+			for range time.Tick(time.Hour) {
+				err := model.TrimTimelines(ctx, MAX_ENTRIES)
+				if err != nil {
+					logging.Error(ctx, "Could not trim timelines.", "component", "timeline", "err", err)
+				}
+			}
+		}()
+	case "memory":
+		SetStore(NewMemoryStore())
+		go func() {
+			ctx := context.Background()
+			err := BackfillAll(ctx)
+			if err != nil {
+				logging.Error(ctx, "Could not backfill timelines.", "component", "timeline", "err", err)
+			}
+		}()
+	}
+}
+
+// SetStore replaces the timeline store, or disables the cache when nil.
+// While there is a store, new Tweets and retweets are fanned out as the
+// model saves them.
+func SetStore(s Store) {
+	store = s
+	if s == nil {
+		model.OnPublish = nil
+	} else {
+		model.OnPublish = publishAsync
+	}
+}
+
+func (PostgresStore) Push(ctx context.Context, userIds []int64, entry model.TimelineEntry) error {
+	return model.PushTimelineEntry(ctx, userIds, entry)
+}
+
+func (PostgresStore) Read(ctx context.Context, userId int64, limit int) ([]model.TimelineEntry, error) {
+	return model.GetTimelineEntries(ctx, userId, limit)
+}
+
+func (PostgresStore) RemoveActor(ctx context.Context, userId, actorId int64) error {
+	return model.DeleteTimelineEntriesFromActor(ctx, userId, actorId)
+}
+
+func (PostgresStore) RemoveAuthor(ctx context.Context, userId, authorId int64) error {
+	return model.DeleteTimelineEntriesByAuthor(ctx, userId, authorId)
+}
+
+func (PostgresStore) Replace(ctx context.Context, userId int64, entries []model.TimelineEntry) error {
+	return model.ReplaceTimelineEntries(ctx, userId, entries)
+}
+
+func sortEntries(entries []model.TimelineEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+}
+
+func (s *MemoryStore) Push(ctx context.Context, userIds []int64, entry model.TimelineEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, userId := range userIds {
+		entries := s.timelines[userId]
+		duplicate := false
+		for _, existing := range entries {
+			if existing.TweetId == entry.TweetId {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		entries = append(entries, entry)
+		sortEntries(entries)
+		if len(entries) > MAX_ENTRIES {
+			entries = entries[:MAX_ENTRIES]
+		}
+		s.timelines[userId] = entries
+	}
+	return nil
+}
+
+func (s *MemoryStore) Read(ctx context.Context, userId int64, limit int) ([]model.TimelineEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := s.timelines[userId]
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return append([]model.TimelineEntry(nil), entries...), nil
+}
+
+func (s *MemoryStore) RemoveActor(ctx context.Context, userId, actorId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []model.TimelineEntry
+	for _, entry := range s.timelines[userId] {
+		if entry.ActorId != actorId {
+			kept = append(kept, entry)
+		}
+	}
+	s.timelines[userId] = kept
+	return nil
+}
+
+func (s *MemoryStore) RemoveAuthor(ctx context.Context, userId, authorId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []model.TimelineEntry
+	for _, entry := range s.timelines[userId] {
+		if entry.AuthorId != authorId {
+			kept = append(kept, entry)
+		}
+	}
+	s.timelines[userId] = kept
+	return nil
+}
+
+func (s *MemoryStore) Replace(ctx context.Context, userId int64, entries []model.TimelineEntry) error {
+	entries = append([]model.TimelineEntry(nil), entries...)
+	sortEntries(entries)
+	if len(entries) > MAX_ENTRIES {
+		entries = entries[:MAX_ENTRIES]
+	}
+	s.mu.Lock()
+	s.timelines[userId] = entries
+	s.mu.Unlock()
+	return nil
+}
+
+func publish(ctx context.Context, entry model.TimelineEntry) {
+	if store == nil {
+		return
+	}
+
+	followers, err := model.GetFollowerCount(ctx, entry.ActorId)
+	if err != nil {
+		logging.Error(ctx, "Could not count followers for fan-out.", "err", err)
+		return
+	}
+	if followers > fanoutLimit {
+		// Read time picks these up through GetHeavyFollowedIds instead.
+		return
+	}
+
+	followerIds, err := model.GetFollowerIds(ctx, entry.ActorId)
+	if err != nil {
+		logging.Error(ctx, "Could not get followers for fan-out.", "err", err)
+		return
+	}
+	if len(followerIds) == 0 {
+		return
+	}
+
+	err = store.Push(ctx, followerIds, entry)
+	if err != nil {
+		logging.Error(ctx, "Could not fan out timeline entry.", "err", err)
+	}
+}
+
+// publishAsync fans a new Tweet or retweet out to the actor's followers. It
+// runs in the background so posting does not wait on large follower lists.
+func publishAsync(ctx context.Context, entry model.TimelineEntry) {
+	go publish(logging.Detach(ctx), entry)
+}
+
+// Followed copies followed's recent activity into follower's timeline.
+func Followed(ctx context.Context, follower, followed int64) {
+	if store == nil {
+		return
+	}
+	entries, err := model.GetActivity(ctx, []int64{followed}, FEED_SIZE)
+	if err != nil {
+		logging.Error(ctx, "Could not get activity to backfill.", "err", err)
+		return
+	}
+	for _, entry := range entries {
+		err = store.Push(ctx, []int64{follower}, entry)
+		if err != nil {
+			logging.Error(ctx, "Could not backfill timeline entry.", "err", err)
+			return
+		}
+	}
+}
+
+// Unfollowed removes followed's Tweets and retweets from follower's timeline.
+func Unfollowed(ctx context.Context, follower, followed int64) {
+	if store == nil {
+		return
+	}
+	err := store.RemoveActor(ctx, follower, followed)
+	if err != nil {
+		logging.Error(ctx, "Could not remove timeline entries.", "err", err)
+	}
+}
+
+// Blocked removes everything blocker and blocked posted or retweeted from
+// each other's timelines, including their Tweets retweeted by third parties.
+func Blocked(ctx context.Context, blocker, blocked int64) {
+	if store == nil {
+		return
+	}
+	for _, pair := range [][2]int64{{blocker, blocked}, {blocked, blocker}} {
+		err := store.RemoveActor(ctx, pair[0], pair[1])
+		if err == nil {
+			err = store.RemoveAuthor(ctx, pair[0], pair[1])
+		}
+		if err != nil {
+			logging.Error(ctx, "Could not remove timeline entries.", "err", err)
+		}
+	}
+}
+
+// Backfill rebuilds userId's materialized timeline from the follows,
+// tweets and retweets tables.
+func Backfill(ctx context.Context, userId int64) error {
+	if store == nil {
+		return nil
+	}
+	following, err := model.GetFollowingIds(ctx, userId)
+	if err != nil {
+		return err
+	}
+	heavy, err := model.GetHeavyFollowedIds(ctx, userId, fanoutLimit)
+	if err != nil {
+		return err
+	}
+	isHeavy := make(map[int64]bool)
+	for _, id := range heavy {
+		isHeavy[id] = true
+	}
+
+	var actors []int64
+	for _, id := range following {
+		if !isHeavy[id] {
+			actors = append(actors, id)
+		}
+	}
+
+	var entries []model.TimelineEntry
+	if len(actors) > 0 {
+		entries, err = model.GetActivity(ctx, actors, MAX_ENTRIES)
+		if err != nil {
+			return err
+		}
+	}
+	return store.Replace(ctx, userId, dedupe(entries))
+}
+
+// BackfillAll rebuilds every user's timeline.
+func BackfillAll(ctx context.Context) error {
+	userIds, err := model.GetAllUserIds(ctx)
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		err = Backfill(ctx, userId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupe keeps the newest entry for each Tweet; entries must be sorted
+// newest first.
+func dedupe(entries []model.TimelineEntry) []model.TimelineEntry {
+	seen := make(map[int64]bool)
+	var unique []model.TimelineEntry
+	for _, entry := range entries {
+		if seen[entry.TweetId] {
+			continue
+		}
+		seen[entry.TweetId] = true
+		unique = append(unique, entry)
+	}
+	return unique
+}
+
+// GetHomeFeed reads userId's materialized timeline, merges in recent
+// activity from followed accounts too large to fan out, and hydrates the
+// result. Without a store it is model.GetFeed.
+func GetHomeFeed(ctx context.Context, userId int64) ([]model.Tweet, error) {
+	if store == nil {
+		return model.GetFeed(ctx, userId)
+	}
+
+	entries, err := store.Read(ctx, userId, FEED_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	heavy, err := model.GetHeavyFollowedIds(ctx, userId, fanoutLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(heavy) > 0 {
+		activity, err := model.GetActivity(ctx, heavy, FEED_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, activity...)
+		sortEntries(entries)
+	}
+	entries = dedupe(entries)
+	if len(entries) > FEED_SIZE {
+		entries = entries[:FEED_SIZE]
+	}
+
+	tweetIds := make([]int64, len(entries))
+	for i, entry := range entries {
+		tweetIds[i] = entry.TweetId
+	}
+	hydrated, err := model.GetTweetsByIds(ctx, tweetIds, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var tweets []model.Tweet
+	for _, entry := range entries {
+		if tweet, ok := hydrated[entry.TweetId]; ok {
+			tweets = append(tweets, tweet)
+		}
+	}
+	return tweets, nil
+}
@@ -0,0 +1,52 @@
+package timeline
+
+import (
+	"time"
+	"context"
+	"testing"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+func TestBlocked(t *testing.T) {
+	previous := store
+	defer SetStore(previous)
+	memory := NewMemoryStore()
+	SetStore(memory)
+
+	const ada, ben, cy = 1, 2, 3
+	ctx := context.Background()
+	entries := []model.TimelineEntry{
+		{TweetId: 1, AuthorId: ben, ActorId: ben, CreatedAt: testNow},
+		// Ben retweeting cy, and cy retweeting ben.
+		{TweetId: 2, AuthorId: cy, ActorId: ben, CreatedAt: testNow.Add(time.Minute)},
+		{TweetId: 3, AuthorId: ben, ActorId: cy, CreatedAt: testNow.Add(2 * time.Minute)},
+		{TweetId: 4, AuthorId: cy, ActorId: cy, CreatedAt: testNow.Add(3 * time.Minute)},
+	}
+	for _, entry := range entries {
+		err := memory.Push(ctx, []int64{ada}, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := memory.Push(ctx, []int64{ben}, model.TimelineEntry{TweetId: 5, AuthorId: cy, ActorId: ada, CreatedAt: testNow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Blocked(ctx, ada, ben)
+
+	timeline, err := memory.Read(ctx, ada, FEED_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline) != 1 || timeline[0].TweetId != 4 {
+		t.Errorf("Got timeline %+v, want only Tweet 4", timeline)
+	}
+	timeline, err = memory.Read(ctx, ben, FEED_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline) != 0 {
+		t.Errorf("Got timeline %+v for the blocked user", timeline)
+	}
+}
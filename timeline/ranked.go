@@ -0,0 +1,106 @@
+package timeline
+
+import (
+	"math"
+	"sort"
+	"context"
+	"time"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+const (
+	CANDIDATE_WINDOW = 7 * 24 * time.Hour
+	CANDIDATE_LIMIT = 500
+	RANKED_SIZE = 100
+)
+
+// Ranker scores a timeline candidate; higher scores are shown first.
+// Implementations must be safe for concurrent use.
+type Ranker interface {
+	Score(candidate model.TimelineCandidate, now time.Time) float64
+}
+
+// RankerFunc adapts an ordinary function to a Ranker.
+type RankerFunc func(candidate model.TimelineCandidate, now time.Time) float64
+
+func (f RankerFunc) Score(candidate model.TimelineCandidate, now time.Time) float64 {
+	return f(candidate, now)
+}
+
+// EngagementRanker multiplies a log-damped engagement score by an
+// exponential recency decay. Out-of-network Tweets are discounted so that
+// people you follow still make up most of the timeline.
+type EngagementRanker struct {
+	HalfLife time.Duration
+	LikeWeight float64
+	RetweetWeight float64
+	NetworkWeight float64
+	OutOfNetworkFactor float64
+}
+
+var DefaultRanker Ranker = EngagementRanker{
+	HalfLife: 6 * time.Hour,
+	LikeWeight: 1,
+	RetweetWeight: 2,
+	NetworkWeight: 4,
+	OutOfNetworkFactor: 0.5,
+}
+
+func (r EngagementRanker) Score(candidate model.TimelineCandidate, now time.Time) float64 {
+	engagement := r.LikeWeight * float64(candidate.Likes) +
+		r.RetweetWeight * float64(candidate.Retweets) +
+		r.NetworkWeight * float64(candidate.NetworkEngagements)
+
+	age := now.Sub(candidate.CreatedAt)
+	if age < 0 {
+		age = 0
+	}
+	decay := math.Pow(0.5, float64(age) / float64(r.HalfLife))
+
+	score := (1 + math.Log1p(engagement)) * decay
+	if !candidate.InNetwork {
+		score *= r.OutOfNetworkFactor
+	}
+	return score
+}
+
+// Rank orders candidates by ranker, most recent first among equal scores,
+// and returns their Tweets.
+func Rank(candidates []model.TimelineCandidate, ranker Ranker, now time.Time) []model.Tweet {
+	scores := make([]float64, len(candidates))
+	for i, candidate := range candidates {
+		scores[i] = ranker.Score(candidate, now)
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return candidates[a].CreatedAt.After(candidates[b].CreatedAt)
+	})
+
+	tweets := make([]model.Tweet, len(order))
+	for i, index := range order {
+		tweets[i] = candidates[index].Tweet
+	}
+	return tweets
+}
+
+// GetRankedFeed is the "For you" alternative to model.GetFeed.
+func GetRankedFeed(ctx context.Context, userId int64, ranker Ranker) ([]model.Tweet, error) {
+	candidates, err := model.GetTimelineCandidates(ctx, userId, CANDIDATE_WINDOW, CANDIDATE_LIMIT)
+	if err != nil {
+		return nil, err
+	}
+
+	tweets := Rank(candidates, ranker, time.Now())
+	if len(tweets) > RANKED_SIZE {
+		tweets = tweets[:RANKED_SIZE]
+	}
+	return tweets, nil
+}
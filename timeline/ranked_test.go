@@ -0,0 +1,150 @@
+package timeline
+
+import (
+	"math"
+	"time"
+	"testing"
+	model "github.com/dustinnewman98/twitter_clone/model"
+)
+
+var testNow = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func candidate(id int64, age time.Duration, inNetwork bool, likes, retweets, network int64) model.TimelineCandidate {
+	return model.TimelineCandidate{
+		Tweet: model.Tweet{Id: id},
+		CreatedAt: testNow.Add(-age),
+		InNetwork: inNetwork,
+		Likes: likes,
+		Retweets: retweets,
+		NetworkEngagements: network,
+	}
+}
+
+func TestEngagementRankerScore(t *testing.T) {
+	tests := []struct {
+		name string
+		candidate model.TimelineCandidate
+		want float64
+	}{
+		{"fresh", candidate(1, 0, true, 0, 0, 0), 1},
+		{"one half-life", candidate(1, 6 * time.Hour, true, 0, 0, 0), 0.5},
+		{"two half-lives", candidate(1, 12 * time.Hour, true, 0, 0, 0), 0.25},
+		{"future", candidate(1, -time.Hour, true, 0, 0, 0), 1},
+		{"like", candidate(1, 0, true, 1, 0, 0), 1 + math.Log(2)},
+		{"retweet", candidate(1, 0, true, 0, 1, 0), 1 + math.Log(3)},
+		{"network engagement", candidate(1, 0, true, 0, 0, 1), 1 + math.Log(5)},
+		{"all engagement", candidate(1, 0, true, 1, 1, 1), 1 + math.Log(8)},
+		{"engagement decays", candidate(1, 6 * time.Hour, true, 1, 1, 1), (1 + math.Log(8)) / 2},
+		{"out of network", candidate(1, 0, false, 0, 0, 0), 0.5},
+		{"out of network decays", candidate(1, 6 * time.Hour, false, 1, 1, 1), (1 + math.Log(8)) / 4},
+	}
+
+	for _, test := range tests {
+		got := DefaultRanker.Score(test.candidate, testNow)
+		if math.Abs(got - test.want) > 1e-9 {
+			t.Errorf("%s: got score %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name string
+		candidates []model.TimelineCandidate
+		ranker Ranker
+		want []int64
+	}{
+		{
+			"newest first without engagement",
+			[]model.TimelineCandidate{
+				candidate(1, 3 * time.Hour, true, 0, 0, 0),
+				candidate(2, time.Hour, true, 0, 0, 0),
+				candidate(3, 2 * time.Hour, true, 0, 0, 0),
+			},
+			DefaultRanker,
+			[]int64{2, 3, 1},
+		},
+		{
+			"engagement outranks a little recency",
+			[]model.TimelineCandidate{
+				candidate(1, 0, true, 0, 0, 0),
+				candidate(2, time.Hour, true, 5, 0, 0),
+			},
+			DefaultRanker,
+			[]int64{2, 1},
+		},
+		{
+			"recency outranks old engagement",
+			[]model.TimelineCandidate{
+				candidate(1, 48 * time.Hour, true, 20, 10, 5),
+				candidate(2, 0, true, 0, 0, 0),
+			},
+			DefaultRanker,
+			[]int64{2, 1},
+		},
+		{
+			"retweets weigh more than likes",
+			[]model.TimelineCandidate{
+				candidate(1, 0, true, 1, 0, 0),
+				candidate(2, 0, true, 0, 1, 0),
+				candidate(3, 0, true, 0, 0, 1),
+			},
+			DefaultRanker,
+			[]int64{3, 2, 1},
+		},
+		{
+			"out of network mixed in by engagement",
+			[]model.TimelineCandidate{
+				candidate(1, 3 * time.Hour, true, 0, 0, 0),
+				candidate(2, 0, false, 10, 0, 0),
+				candidate(3, 0, true, 0, 0, 0),
+				candidate(4, time.Hour, false, 0, 0, 0),
+			},
+			DefaultRanker,
+			[]int64{2, 3, 1, 4},
+		},
+		{
+			"in network preferred at equal engagement",
+			[]model.TimelineCandidate{
+				candidate(1, 0, false, 3, 1, 0),
+				candidate(2, 0, true, 3, 1, 0),
+			},
+			DefaultRanker,
+			[]int64{2, 1},
+		},
+		{
+			"equal scores newest first",
+			[]model.TimelineCandidate{
+				candidate(1, 2 * time.Hour, true, 0, 0, 0),
+				candidate(2, time.Hour, false, 0, 0, 0),
+				candidate(3, 3 * time.Hour, true, 0, 0, 0),
+			},
+			RankerFunc(func(model.TimelineCandidate, time.Time) float64 { return 1 }),
+			[]int64{2, 1, 3},
+		},
+		{
+			"no candidates",
+			nil,
+			DefaultRanker,
+			[]int64{},
+		},
+	}
+
+	for _, test := range tests {
+		tweets := Rank(test.candidates, test.ranker, testNow)
+		got := make([]int64, len(tweets))
+		for i, tweet := range tweets {
+			got[i] = tweet.Id
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got order %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got order %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
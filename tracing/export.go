@@ -0,0 +1,258 @@
+package tracing
+
+import (
+	"io"
+	"log"
+	"fmt"
+	"sync"
+	"time"
+	"bytes"
+	"strconv"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+)
+
+const (
+	BATCH_SIZE = 512
+	BATCH_INTERVAL = 5 * time.Second
+	// QUEUE_SIZE spans may wait for export; more are dropped rather than
+	// slowing requests down.
+	QUEUE_SIZE = 4096
+)
+
+// batcher collects ended spans and exports them in the background.
+type batcher struct {
+	exporter Exporter
+	queue chan *Span
+	flushes chan chan struct{}
+	done chan struct{}
+	stopOnce sync.Once
+}
+
+func newBatcher(e Exporter) *batcher {
+	b := &batcher{
+		exporter: e,
+		queue: make(chan *Span, QUEUE_SIZE),
+		flushes: make(chan chan struct{}),
+		done: make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *batcher) add(s *Span) {
+	select {
+	case b.queue <- s:
+	default:
+	}
+}
+
+func (b *batcher) run() {
+	ticker := time.NewTicker(BATCH_INTERVAL)
+	defer ticker.Stop()
+	var batch []*Span
+	export := func() {
+		// Take whatever is already queued so a flush sees every ended span.
+		for len(b.queue) > 0 {
+			batch = append(batch, <-b.queue)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		err := b.exporter.Export(batch)
+		if err != nil {
+			log.Println("Could not export spans.\n", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-b.queue:
+			batch = append(batch, s)
+			if len(batch) >= BATCH_SIZE {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-b.flushes:
+			export()
+			close(flushed)
+		case <-b.done:
+			export()
+			return
+		}
+	}
+}
+
+func (b *batcher) flush() {
+	flushed := make(chan struct{})
+	select {
+	case b.flushes <- flushed:
+		<-flushed
+	case <-b.done:
+	}
+}
+
+func (b *batcher) stop() {
+	b.stopOnce.Do(func() {
+		b.flush()
+		close(b.done)
+	})
+}
+
+// The types below follow the OTLP/JSON encoding of trace data.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource otlpResource `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID string `json:"traceId"`
+	SpanID string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId,omitempty"`
+	Name string `json:"name"`
+	Kind Kind `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano string `json:"endTimeUnixNano"`
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+	Status otlpStatus `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key string `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue *string `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue *bool `json:"boolValue,omitempty"`
+}
+
+// Status codes are UNSET, OK and ERROR.
+type otlpStatus struct {
+	Code int `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func toValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	}
+	s := fmt.Sprint(v)
+	return otlpValue{StringValue: &s}
+}
+
+func toAttributes(attributes []Attribute) []otlpAttribute {
+	var result []otlpAttribute
+	for _, a := range attributes {
+		result = append(result, otlpAttribute{Key: a.Key, Value: toValue(a.Value)})
+	}
+	return result
+}
+
+func toSpan(s *Span) otlpSpan {
+	span := otlpSpan{
+		TraceID: s.TraceID.String(),
+		SpanID: s.SpanID.String(),
+		Name: s.Name,
+		Kind: s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+		EndTimeUnixNano: strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		Attributes: toAttributes(s.Attributes),
+	}
+	if s.ParentID.valid() {
+		span.ParentSpanID = s.ParentID.String()
+	}
+	if s.Err != nil {
+		span.Status = otlpStatus{Code: 2, Message: s.Err.Error()}
+	}
+	return span
+}
+
+func encode(service string, spans []*Span) otlpTraces {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/dustinnewman98/twitter_clone/tracing"}}
+	for _, s := range spans {
+		scope.Spans = append(scope.Spans, toSpan(s))
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: toAttributes([]Attribute{{Key: "service.name", Value: service}})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector's OTLP/HTTP
+// endpoint, such as http://localhost:4318.
+type OTLPExporter struct {
+	Endpoint string
+	Service string
+	Client *http.Client
+}
+
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Service: service,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLPExporter) Export(spans []*Span) error {
+	body, err := json.Marshal(encode(e.Service, spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.Client.Post(e.Endpoint + "/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// ConsoleExporter writes each batch as a line of OTLP/JSON.
+type ConsoleExporter struct {
+	Writer io.Writer
+	Service string
+}
+
+func (e ConsoleExporter) Export(spans []*Span) error {
+	return json.NewEncoder(e.Writer).Encode(encode(e.Service, spans))
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"strings"
+	"net/http"
+	"encoding/hex"
+	mux "github.com/gorilla/mux"
+)
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// parseTraceparent reads a W3C traceparent header such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func parseTraceparent(header string) (TraceID, SpanID, bool) {
+	var traceID TraceID
+	var spanID SpanID
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, traceID.valid() && spanID.valid()
+}
+
+// Middleware starts a span for each request before passing it to next. Spans
+// are named for the router's route template that the request matches, such
+// as "GET /{username}/followers". Requests carrying a traceparent header
+// join the caller's trace.
+func Middleware(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx := r.Context()
+		if traceID, spanID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = withRemoteParent(ctx, traceID, spanID)
+		}
+		ctx, span := Start(ctx, r.Method + " " + route, SERVER,
+			"http.method", r.Method,
+			"http.route", route,
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttributes("http.status_code", sw.status)
+		if sw.status >= 500 {
+			span.SetError(statusError(sw.status))
+		}
+	})
+}
+
+// statusError marks server errors as failed spans.
+type statusError int
+
+func (s statusError) Error() string {
+	return http.StatusText(int(s))
+}
@@ -0,0 +1,209 @@
+// Package tracing records spans for requests, SQL statements, storage
+// operations and template rendering, and exports them in the OpenTelemetry
+// format. Spans are only kept when OTEL_TRACES_EXPORTER is "otlp" or
+// "console"; otherwise Start returns a nil *Span, whose methods do nothing.
+package tracing
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type Kind int
+
+// Kinds match the OpenTelemetry SpanKind values.
+const (
+	INTERNAL Kind = 1
+	SERVER Kind = 2
+	CLIENT Kind = 3
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) valid() bool {
+	return id != TraceID{}
+}
+
+func (id SpanID) valid() bool {
+	return id != SpanID{}
+}
+
+type Attribute struct {
+	Key string
+	Value interface{}
+}
+
+// Span is one timed operation within a trace.
+type Span struct {
+	TraceID TraceID
+	SpanID SpanID
+	ParentID SpanID
+	Name string
+	Kind Kind
+	StartTime time.Time
+	EndTime time.Time
+	Attributes []Attribute
+	Err error
+
+	mu sync.Mutex
+	ended bool
+}
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+var (
+	exporterMu sync.RWMutex
+	// exporter is nil when tracing is off.
+	exporter *batcher
+)
+
+func currentExporter() *batcher {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+// Init starts exporting spans as OTEL_TRACES_EXPORTER says: "otlp" posts
+// them to OTEL_EXPORTER_OTLP_ENDPOINT, "console" prints them as JSON lines
+// for local runs.
+func Init() error {
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "twitter_clone"
+	}
+
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		SetExporter(nil)
+	case "otlp":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			endpoint = "http://localhost:4318"
+		}
+		SetExporter(NewOTLPExporter(endpoint, service))
+	case "console":
+		SetExporter(ConsoleExporter{Writer: os.Stdout, Service: service})
+	default:
+		return fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", name)
+	}
+	return nil
+}
+
+// SetExporter replaces the exporter, flushing spans held for the old one.
+// A nil exporter turns tracing off.
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	if exporter != nil {
+		exporter.stop()
+	}
+	if e == nil {
+		exporter = nil
+		return
+	}
+	exporter = newBatcher(e)
+}
+
+// Flush exports the spans that have ended so far.
+func Flush() {
+	if e := currentExporter(); e != nil {
+		e.flush()
+	}
+}
+
+// Enabled reports whether spans are being recorded.
+func Enabled() bool {
+	return currentExporter() != nil
+}
+
+type contextKey int
+
+const spanKey contextKey = 0
+
+// FromContext returns the span ctx is in, or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// withRemoteParent stands in for a span started by another service, so that
+// spans started here join its trace.
+func withRemoteParent(ctx context.Context, traceID TraceID, spanID SpanID) context.Context {
+	return context.WithValue(ctx, spanKey, &Span{TraceID: traceID, SpanID: spanID, ended: true})
+}
+
+// Start begins a span as a child of the one in ctx, if any, and returns a
+// context carrying the new span. keyvals alternate between string keys and
+// values and become attributes. End must be called on the span.
+func Start(ctx context.Context, name string, kind Kind, keyvals ...interface{}) (context.Context, *Span) {
+	if currentExporter() == nil {
+		return ctx, nil
+	}
+	span := &Span{Name: name, Kind: kind, StartTime: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+	span.SetAttributes(keyvals...)
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+func (s *Span) SetAttributes(keyvals ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i + 1 < len(keyvals); i += 2 {
+		s.Attributes = append(s.Attributes, Attribute{Key: fmt.Sprint(keyvals[i]), Value: keyvals[i + 1]})
+	}
+}
+
+// SetError marks the span as failed. Nil errors are ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Err = err
+}
+
+// End records the span's end time and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	if e := currentExporter(); e != nil {
+		e.add(s)
+	}
+}
@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"sync"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+	mux "github.com/gorilla/mux"
+)
+
+// recorder keeps exported spans for inspection.
+type recorder struct {
+	mu sync.Mutex
+	spans []*Span
+}
+
+func (r *recorder) Export(spans []*Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// record sends spans to a new recorder until the returned function is called.
+func record() (*recorder, func()) {
+	r := &recorder{}
+	SetExporter(r)
+	return r, func() {
+		SetExporter(nil)
+	}
+}
+
+func (r *recorder) flushed() []*Span {
+	Flush()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spans
+}
+
+func TestDisabled(t *testing.T) {
+	ctx, span := Start(context.Background(), "ignored", INTERNAL)
+	span.SetAttributes("key", "value")
+	span.SetError(statusError(500))
+	span.End()
+	if span != nil || FromContext(ctx) != nil {
+		t.Error("Started a span without an exporter")
+	}
+}
+
+func TestStart(t *testing.T) {
+	r, restore := record()
+	defer restore()
+
+	ctx, parent := Start(context.Background(), "parent", SERVER)
+	_, child := Start(ctx, "child", CLIENT, "db.system", "postgresql")
+	child.End()
+	child.End()
+	parent.End()
+
+	spans := r.flushed()
+	if len(spans) != 2 {
+		t.Fatalf("Got %d spans, want 2", len(spans))
+	}
+	if child.TraceID != parent.TraceID || child.ParentID != parent.SpanID {
+		t.Errorf("Child %+v is not in parent %+v", child, parent)
+	}
+	if parent.ParentID.valid() {
+		t.Errorf("Root span has parent %s", parent.ParentID)
+	}
+	if len(child.Attributes) != 1 || child.Attributes[0] != (Attribute{"db.system", "postgresql"}) {
+		t.Errorf("Got attributes %v", child.Attributes)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, spanID, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || traceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("Got %s %s %v", traceID, spanID, ok)
+	}
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		if _, _, ok := parseTraceparent(header); ok {
+			t.Errorf("Accepted %q", header)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r, restore := record()
+	defer restore()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{username}/followers", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "db GetFollowers", CLIENT)
+		span.End()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}).Methods("GET")
+	handler := Middleware(router, router)
+
+	req := httptest.NewRequest("GET", "/ada/followers", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+
+	spans := r.flushed()
+	if len(spans) != 3 {
+		t.Fatalf("Got %d spans, want 3", len(spans))
+	}
+	query, request, unmatched := spans[0], spans[1], spans[2]
+	if request.Name != "GET /{username}/followers" || request.Kind != SERVER || request.Err == nil {
+		t.Errorf("Got request span %+v", request)
+	}
+	if request.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || request.ParentID.String() != "00f067aa0ba902b7" {
+		t.Errorf("Request span did not join the caller's trace: %+v", request)
+	}
+	if query.ParentID != request.SpanID {
+		t.Errorf("Query span %+v is not in request span %+v", query, request)
+	}
+	if unmatched.Name != "GET unmatched" || unmatched.Err != nil {
+		t.Errorf("Got unmatched span %+v", unmatched)
+	}
+}
+
+func TestConsoleExporter(t *testing.T) {
+	var buf bytes.Buffer
+	span := &Span{Name: "template home.html", Kind: INTERNAL, Err: statusError(500)}
+	span.TraceID[0] = 1
+	span.SpanID[0] = 2
+	span.SetAttributes("template.name", "home.html", "http.status_code", 500)
+	err := ConsoleExporter{Writer: &buf, Service: "test"}.Export([]*Span{span})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "}\n") {
+		t.Errorf("Got %q, want one line", buf.String())
+	}
+
+	var traces otlpTraces
+	err = json.Unmarshal(buf.Bytes(), &traces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resource := traces.ResourceSpans[0]
+	if *resource.Resource.Attributes[0].Value.StringValue != "test" {
+		t.Errorf("Got resource %+v", resource.Resource)
+	}
+	got := resource.ScopeSpans[0].Spans[0]
+	if got.TraceID != "01000000000000000000000000000000" || got.SpanID != "0200000000000000" || got.ParentSpanID != "" {
+		t.Errorf("Got IDs %s %s %s", got.TraceID, got.SpanID, got.ParentSpanID)
+	}
+	if got.Status.Code != 2 || *got.Attributes[1].Value.IntValue != "500" {
+		t.Errorf("Got span %+v", got)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var path, contentType string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+	}))
+	defer collector.Close()
+
+	err := NewOTLPExporter(collector.URL + "/", "test").Export([]*Span{{Name: "span"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/traces" || contentType != "application/json" {
+		t.Errorf("Posted %s to %s", contentType, path)
+	}
+}